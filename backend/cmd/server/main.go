@@ -17,6 +17,7 @@ import (
 	"heyspoilme/pkg/auth"
 	"heyspoilme/pkg/email"
 	"heyspoilme/pkg/storage"
+	"heyspoilme/pkg/token"
 )
 
 func main() {
@@ -45,8 +46,41 @@ func main() {
 		log.Printf("Warning: S3 client not initialized: %v", err)
 	}
 
-	// Initialize Google OAuth
-	googleAuth := auth.NewGoogleAuth(cfg.GoogleClientID, cfg.GoogleClientSecret, cfg.GoogleRedirectURL)
+	// Initialize identity providers (Google plus any configured OpenID Connect providers)
+	var identityProviders []auth.Provider
+	if cfg.GoogleClientID != "" {
+		identityProviders = append(identityProviders, auth.NewGoogleProvider(cfg.GoogleClientID, cfg.GoogleClientSecret, cfg.GoogleRedirectURL))
+	}
+	for _, p := range cfg.OIDCProviders {
+		identityProviders = append(identityProviders, auth.NewOIDCProvider(auth.OIDCConfig{
+			Name:         p.Name,
+			IssuerURL:    p.IssuerURL,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+		}))
+	}
+	providers := auth.NewProviders(identityProviders...)
+	log.Printf("[Config] Identity providers: %v", providers.Names())
+
+	// Load JWT signing keys
+	var keySet *token.KeySet
+	if cfg.JWTSigningKeys != "" {
+		keySet, err = token.LoadKeySet(cfg.JWTSigningKeys, cfg.JWTActiveKeyID)
+		if err != nil {
+			log.Fatal("Failed to load JWT signing keys:", err)
+		}
+		log.Printf("[Config] JWT signing key: %s", keySet.ActiveKeyID())
+	} else {
+		if gin.Mode() == gin.ReleaseMode {
+			log.Fatal("JWT_SIGNING_KEYS must be set in release mode")
+		}
+		keySet, err = token.NewEphemeralKeySet()
+		if err != nil {
+			log.Fatal("Failed to generate JWT signing key:", err)
+		}
+	}
 
 	// Initialize ZeptoMail client
 	var emailClient *email.ZeptoMailClient
@@ -73,9 +107,28 @@ func main() {
 	adminRepo := repository.NewAdminRepository(db)
 	cityRepo := repository.NewCityRepository(db)
 	featureFlagRepo := repository.NewFeatureFlagRepository(db)
-
-	// Initialize WebSocket hub
-	hub := websocket.NewHub()
+	sessionRepo := repository.NewSessionRepository(db)
+	wsTicketRepo := repository.NewWSTicketRepository(db)
+	twoFactorRepo := repository.NewTwoFactorRepository(db)
+	oauthRepo := repository.NewOAuthRepository(db)
+	passwordResetRepo := repository.NewPasswordResetRepository(db)
+	magicLinkRepo := repository.NewMagicLinkRepository(db)
+	dataExportRepo := repository.NewDataExportRepository(db)
+	deletionRepo := repository.NewDeletionRepository(db)
+	s3CleanupRepo := repository.NewS3CleanupRepository(db)
+	reactionRepo := repository.NewReactionRepository(db)
+	userEventRepo := repository.NewUserEventRepository(db)
+
+	// Initialize WebSocket hub. The backplane relays its events between servers.
+	var backplane websocket.Backplane = websocket.NewMemoryBackplane()
+	if cfg.RealtimeBackplane != "memory" {
+		backplane, err = websocket.NewPostgresBackplane(db, cfg.DatabaseURL)
+		if err != nil {
+			log.Fatal("Failed to start realtime backplane:", err)
+		}
+	}
+	defer backplane.Close()
+	hub := websocket.NewHub(backplane)
 	go hub.Run()
 
 	// Start background notification job
@@ -90,45 +143,75 @@ func main() {
 	featureFlagService := services.NewFeatureFlagService(featureFlagRepo)
 
 	// Initialize services
-	authService := services.NewAuthService(userRepo, cfg.JWTSecret, emailClient)
+	sessionService := services.NewSessionService(sessionRepo, presenceRepo, hub)
+	var loginAttemptStore repository.LoginAttemptStore = repository.NewPostgresLoginAttemptStore(db)
+	if cfg.LoginAttemptStore == "memory" {
+		loginAttemptStore = repository.NewMemoryLoginAttemptStore()
+	}
+	loginThrottleService := services.NewLoginThrottleService(loginAttemptStore, userRepo, emailClient)
+	go loginThrottleService.Start()
+	authService := services.NewAuthService(userRepo, sessionRepo, passwordResetRepo, magicLinkRepo, wsTicketRepo, sessionService, loginThrottleService, keySet, emailClient)
+	twoFactorService := services.NewTwoFactorService(twoFactorRepo, userRepo)
+	emailChangeService := services.NewEmailChangeService(userRepo, sessionRepo, sessionService, loginThrottleService, emailClient)
+	oauthService := services.NewOAuthService(providers, oauthRepo, userRepo, sessionService)
 	profileService := services.NewProfileService(profileRepo, userRepo)
-	chatService := services.NewChatService(messageRepo, profileRepo, userRepo, hub, featureFlagService)
-	likeService := services.NewLikeService(likeRepo, notificationRepo, profileRepo, hub, featureFlagService)
+	eventService := services.NewEventService(userEventRepo, hub)
+	eventService.RegisterCommands(hub.Commands())
+	go eventService.Start()
+	chatService := services.NewChatService(messageRepo, reactionRepo, profileRepo, userRepo, presenceRepo, eventService, featureFlagService)
+	chatService.RegisterCommands(hub.Commands())
+	likeService := services.NewLikeService(likeRepo, notificationRepo, profileRepo, eventService, featureFlagService)
 	notificationService := services.NewNotificationService(notificationRepo)
-	presenceService := services.NewPresenceService(presenceRepo, hub)
-	accountService := services.NewAccountService(userRepo, profileRepo, messageRepo, likeRepo, notificationRepo, presenceRepo, s3Client)
+	presenceService := services.NewPresenceService(presenceRepo, userRepo, hub)
+	privacyService := services.NewPrivacyService(userRepo, presenceService)
+	presenceService.RegisterCommands(hub.Commands())
+	hub.OnPresenceChange(presenceService.UpdatePresence)
+	hub.OnConnectionChange(presenceService.UpdateConnection)
+	go presenceService.Start()
+	accountService := services.NewAccountService(userRepo, sessionService, time.Duration(cfg.DeletionGraceDays)*24*time.Hour)
+	deletionService := services.NewDeletionService(deletionRepo, hub)
+	accountPurgeJob := services.NewAccountPurgeJobService(userRepo, deletionService)
+	go accountPurgeJob.Start()
+	s3CleanupJob := services.NewS3CleanupJobService(s3CleanupRepo, s3Client)
+	go s3CleanupJob.Start()
 	verificationService := services.NewVerificationService(verificationRepo, profileRepo)
-	adminService := services.NewAdminService(adminRepo, s3Client)
+	dataExportService := services.NewDataExportService(dataExportRepo, userRepo, profileRepo, likeRepo, messageRepo, notificationRepo, verificationRepo, presenceRepo, sessionRepo, s3Client, emailClient)
+	go dataExportService.Start()
+	adminService := services.NewAdminService(adminRepo, deletionService, s3Client)
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authService, profileService, accountService, googleAuth, cfg.FrontendURL)
+	authHandler := handlers.NewAuthHandler(authService, profileService, accountService, twoFactorService, oauthService, cfg.FrontendURL)
 	profileHandler := handlers.NewProfileHandler(profileService)
 	chatHandler := handlers.NewChatHandler(chatService)
 	uploadHandler := handlers.NewUploadHandler(s3Client)
 	likeHandler := handlers.NewLikeHandler(likeService, profileService)
 	notificationHandler := handlers.NewNotificationHandler(notificationService)
 	verificationHandler := handlers.NewVerificationHandler(verificationService)
-	wsHandler := handlers.NewWebSocketHandler(hub, authService, presenceService)
-	adminHandler := handlers.NewAdminHandler(adminService, featureFlagService, s3Client, cfg.AdminCode1, cfg.AdminCode2)
+	sessionHandler := handlers.NewSessionHandler(sessionService)
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactorService)
+	privacyHandler := handlers.NewPrivacyHandler(privacyService)
+	emailChangeHandler := handlers.NewEmailChangeHandler(emailChangeService)
+	dataExportHandler := handlers.NewDataExportHandler(dataExportService)
+	wsHandler := handlers.NewWebSocketHandler(hub, authService, eventService, cfg.AllowedOrigins)
+	adminHandler := handlers.NewAdminHandler(adminService, featureFlagService, loginThrottleService, s3Client, cfg.AdminCode1, cfg.AdminCode2)
 	cityHandler := handlers.NewCityHandler(cityRepo)
 
 	// Initialize auth middleware
-	authMiddleware := middleware.NewAuthMiddleware(cfg.JWTSecret)
+	authMiddleware := middleware.NewAuthMiddleware(keySet, sessionRepo)
 	verificationMiddleware := middleware.NewVerificationMiddleware(userRepo, featureFlagService)
 
 	// Setup Gin
 	r := gin.Default()
 
+	// ClientIP keys the signin throttle, so only believe forwarded headers
+	// from our own proxies
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
 	// CORS configuration
 	r.Use(cors.New(cors.Config{
-		AllowOrigins: []string{
-			cfg.FrontendURL,
-			"https://heyspoil.me",
-			"https://www.heyspoil.me",
-			"http://localhost:5173",
-			"http://localhost:3000",
-			"http://localhost:3003",
-		},
+		AllowOrigins:     cfg.AllowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
@@ -146,13 +229,36 @@ func main() {
 	{
 		authRoutes.POST("/signup", authHandler.Signup)
 		authRoutes.POST("/signin", authHandler.Signin)
+		authRoutes.POST("/signin/2fa", authHandler.VerifyTwoFactor)
 		authRoutes.GET("/google", authHandler.GoogleLogin)
 		authRoutes.GET("/google/callback", authHandler.GoogleCallback)
-		authRoutes.POST("/logout", authHandler.Logout)
+		authRoutes.GET("/oauth/:provider", authHandler.OAuthLogin)
+		authRoutes.GET("/oauth/:provider/callback", authHandler.OAuthCallback)
+		authRoutes.POST("/oauth/exchange", authHandler.ExchangeLoginCode)
+		authRoutes.POST("/refresh", authHandler.RefreshToken)
+		authRoutes.POST("/logout", authMiddleware.RequireAuth(), authHandler.Logout)
+		authRoutes.POST("/logout-all", authMiddleware.RequireAuth(), authHandler.LogoutAll)
+		authRoutes.GET("/sessions", authMiddleware.RequireAuth(), sessionHandler.ListSessions)
+		authRoutes.DELETE("/sessions/:id", authMiddleware.RequireAuth(), sessionHandler.RevokeSession)
+		authRoutes.GET("/2fa", authMiddleware.RequireAuth(), twoFactorHandler.GetStatus)
+		authRoutes.POST("/2fa/setup", authMiddleware.RequireAuth(), twoFactorHandler.Setup)
+		authRoutes.POST("/2fa/enable", authMiddleware.RequireAuth(), twoFactorHandler.Enable)
+		authRoutes.POST("/2fa/disable", authMiddleware.RequireAuth(), twoFactorHandler.Disable)
+		authRoutes.POST("/2fa/recovery-codes", authMiddleware.RequireAuth(), twoFactorHandler.RegenerateRecoveryCodes)
+		authRoutes.POST("/email/change", authMiddleware.RequireAuth(), emailChangeHandler.RequestChange)
+		authRoutes.POST("/email/confirm", emailChangeHandler.ConfirmChange)
+		authRoutes.POST("/email/cancel", emailChangeHandler.CancelChange)
 		authRoutes.GET("/me", authMiddleware.RequireAuth(), authHandler.GetCurrentUser)
 		authRoutes.GET("/verify-email", authHandler.VerifyEmail)
+		authRoutes.POST("/password-reset/request", authHandler.RequestPasswordReset)
+		authRoutes.POST("/password-reset/confirm", authHandler.ConfirmPasswordReset)
+		authRoutes.POST("/magic-link", authHandler.RequestMagicLink)
+		authRoutes.POST("/magic-link/verify", authHandler.VerifyMagicLink)
 		authRoutes.POST("/resend-verification", authMiddleware.RequireAuth(), authHandler.ResendVerificationEmail)
 		authRoutes.DELETE("/account", authMiddleware.RequireAuth(), authHandler.DeleteAccount)
+		authRoutes.POST("/account/pause", authMiddleware.RequireAuth(), authHandler.PauseAccount)
+		authRoutes.POST("/account/export", authMiddleware.RequireAuth(), dataExportHandler.RequestExport)
+		authRoutes.GET("/account/export", authMiddleware.RequireAuth(), dataExportHandler.GetExport)
 	}
 
 	// Public routes for autocomplete (no auth required)
@@ -178,7 +284,16 @@ func main() {
 		// Chat routes - viewing allowed without verification
 		api.GET("/conversations", chatHandler.GetConversations)
 		api.GET("/conversations/:id/messages", chatHandler.GetMessages)
+		api.PUT("/conversations/:id/messages/:messageId", chatHandler.EditMessage)
+		api.DELETE("/conversations/:id/messages/:messageId", chatHandler.DeleteMessage)
+		api.GET("/conversations/:id/messages/:messageId/edits", chatHandler.GetMessageEdits)
+		api.PUT("/conversations/:id/messages/:messageId/reactions/:emoji", chatHandler.AddReaction)
+		api.DELETE("/conversations/:id/messages/:messageId/reactions/:emoji", chatHandler.RemoveReaction)
 		api.GET("/messages/unread-count", chatHandler.GetUnreadCount)
+		api.GET("/messages/sync", chatHandler.SyncMessages)
+		api.POST("/conversations/:id/read", chatHandler.MarkRead)
+		api.GET("/privacy", privacyHandler.GetSettings)
+		api.PUT("/privacy", privacyHandler.UpdateSettings)
 		api.GET("/inbox", chatHandler.GetInbox) // Inbox with locked message support
 
 		// Notification routes
@@ -215,8 +330,9 @@ func main() {
 		verifiedAPI.POST("/conversations/:id/messages", chatHandler.SendMessage)
 	}
 
-	// WebSocket route
+	// WebSocket route (connect with a ticket from /api/ws/ticket)
 	r.GET("/ws", wsHandler.HandleWebSocket)
+	r.POST("/api/ws/ticket", authMiddleware.RequireAuth(), wsHandler.IssueTicket)
 
 	// Admin routes (protected by secret URL path)
 	adminRoutes := r.Group("/admin/:code1/:code2")
@@ -226,6 +342,7 @@ func main() {
 		adminRoutes.GET("/users", adminHandler.ListUsers)
 		adminRoutes.GET("/users/:userId", adminHandler.GetUser)
 		adminRoutes.DELETE("/users/:userId", adminHandler.DeleteUser)
+		adminRoutes.POST("/users/:userId/unlock", adminHandler.UnlockUser)
 		adminRoutes.PUT("/users/:userId/wealth-status", adminHandler.UpdateUserWealthStatus)
 		adminRoutes.PUT("/users/:userId/verification-status", adminHandler.UpdateUserVerificationStatus)
 		adminRoutes.PUT("/users/:userId/presence", adminHandler.UpdateUserPresence)
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -17,8 +18,20 @@ type Config struct {
 	GoogleClientSecret string
 	GoogleRedirectURL  string
 
-	// JWT
-	JWTSecret string
+	// Additional OpenID Connect providers
+	OIDCProviders []OIDCProviderConfig
+
+	// JWT signing keys as comma separated kid:path entries, and the key ID new
+	// tokens are signed with. Without keys an ephemeral dev key is generated.
+	JWTSigningKeys string
+	JWTActiveKeyID string
+
+	// Where failed signin counters are kept: "postgres" (shared by all servers) or "memory"
+	LoginAttemptStore string
+
+	// How realtime events reach the other servers: "postgres" (LISTEN/NOTIFY) or
+	// "memory" for a single server
+	RealtimeBackplane string
 
 	// S3 / Cloudflare R2
 	AWSAccessKeyID     string
@@ -34,16 +47,36 @@ type Config struct {
 	// Frontend URL
 	FrontendURL string
 
+	// Origins allowed to call the API and open WebSockets
+	AllowedOrigins []string
+
+	// Reverse proxies whose X-Forwarded-For is believed (IPs or CIDRs). Without
+	// any, the client IP is the address of the connection.
+	TrustedProxies []string
+
 	// ZeptoMail
 	ZeptoMailAPIKey    string
 	ZeptoMailFromEmail string
 	ZeptoMailFromName  string
 
+	// Days a deleted account can still be restored by signing in before it is purged
+	DeletionGraceDays int
+
 	// Admin
 	AdminCode1 string
 	AdminCode2 string
 }
 
+// OIDCProviderConfig configures a generic OpenID Connect identity provider
+type OIDCProviderConfig struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
 func Load() *Config {
 	return &Config{
 		Port:               getEnv("PORT", "8080"),
@@ -51,7 +84,11 @@ func Load() *Config {
 		GoogleClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
 		GoogleClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
 		GoogleRedirectURL:  getEnv("GOOGLE_REDIRECT_URL", "http://localhost:8080/api/auth/google/callback"),
-		JWTSecret:          getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+		OIDCProviders:      loadOIDCProviders(),
+		JWTSigningKeys:     getEnv("JWT_SIGNING_KEYS", ""),
+		JWTActiveKeyID:     getEnv("JWT_ACTIVE_KEY_ID", ""),
+		LoginAttemptStore:  getEnv("LOGIN_ATTEMPT_STORE", "postgres"),
+		RealtimeBackplane:  getEnv("REALTIME_BACKPLANE", "postgres"),
 		AWSAccessKeyID:     getEnv("AWS_ACCESS_KEY_ID", ""),
 		AWSSecretAccessKey: getEnv("AWS_SECRET_ACCESS_KEY", ""),
 		AWSRegion:          getEnv("AWS_REGION", "auto"),
@@ -60,9 +97,12 @@ func Load() *Config {
 		S3Endpoint:         getEnv("S3_ENDPOINT", ""),
 		DiscordWebhookURL:  getEnv("DISCORD_WEBHOOK_URL", ""),
 		FrontendURL:        getEnv("FRONTEND_URL", "http://localhost:3003"),
+		AllowedOrigins:     loadAllowedOrigins(getEnv("FRONTEND_URL", "http://localhost:3003")),
+		TrustedProxies:     splitList(getEnv("TRUSTED_PROXIES", "")),
 		ZeptoMailAPIKey:    getEnv("ZEPTOMAIL_API_KEY", ""),
 		ZeptoMailFromEmail: getEnv("ZEPTOMAIL_FROM_EMAIL", "noreply@heyspoilme.com"),
 		ZeptoMailFromName:  getEnv("ZEPTOMAIL_FROM_NAME", "HeySpoilMe"),
+		DeletionGraceDays:  getEnvInt("ACCOUNT_DELETION_GRACE_DAYS", 30),
 		AdminCode1:         getEnv("ADMIN_CODE_1", "super"),
 		AdminCode2:         getEnv("ADMIN_CODE_2", "secret"),
 	}
@@ -83,3 +123,56 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// loadAllowedOrigins returns the frontend URL plus ALLOWED_ORIGINS (comma separated),
+// defaulting to the production and local development origins
+func loadAllowedOrigins(frontendURL string) []string {
+	extra := getEnv("ALLOWED_ORIGINS", "https://heyspoil.me,https://www.heyspoil.me,http://localhost:5173,http://localhost:3000,http://localhost:3003")
+
+	origins := []string{frontendURL}
+	for _, origin := range strings.Split(extra, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" && origin != frontendURL {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// splitList splits a comma separated value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadOIDCProviders reads providers listed in OIDC_PROVIDERS (comma separated names).
+// Each name is configured with OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and
+// optionally _REDIRECT_URL and _SCOPES (space separated).
+func loadOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range strings.Split(getEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := OIDCProviderConfig{
+			Name:         name,
+			IssuerURL:    getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", "http://localhost:8080/api/auth/oauth/"+name+"/callback"),
+		}
+		if scopes := getEnv(prefix+"SCOPES", ""); scopes != "" {
+			provider.Scopes = strings.Fields(scopes)
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
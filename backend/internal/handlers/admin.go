@@ -14,20 +14,22 @@ import (
 )
 
 type AdminHandler struct {
-	adminService       *services.AdminService
-	featureFlagService *services.FeatureFlagService
-	s3Client           *storage.S3Client
-	adminCode1         string
-	adminCode2         string
+	adminService         *services.AdminService
+	featureFlagService   *services.FeatureFlagService
+	loginThrottleService *services.LoginThrottleService
+	s3Client             *storage.S3Client
+	adminCode1           string
+	adminCode2           string
 }
 
-func NewAdminHandler(adminService *services.AdminService, featureFlagService *services.FeatureFlagService, s3Client *storage.S3Client, adminCode1, adminCode2 string) *AdminHandler {
+func NewAdminHandler(adminService *services.AdminService, featureFlagService *services.FeatureFlagService, loginThrottleService *services.LoginThrottleService, s3Client *storage.S3Client, adminCode1, adminCode2 string) *AdminHandler {
 	return &AdminHandler{
-		adminService:       adminService,
-		featureFlagService: featureFlagService,
-		s3Client:           s3Client,
-		adminCode1:         adminCode1,
-		adminCode2:         adminCode2,
+		adminService:         adminService,
+		featureFlagService:   featureFlagService,
+		loginThrottleService: loginThrottleService,
+		s3Client:             s3Client,
+		adminCode1:           adminCode1,
+		adminCode2:           adminCode2,
 	}
 }
 
@@ -245,6 +247,23 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "user deleted"})
 }
 
+// UnlockUser clears failed sign in attempts and any lockout on a user's account
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	userIDStr := c.Param("userId")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	if err := h.loginThrottleService.Unlock(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "user unlocked"})
+}
+
 // UpdateUserWealthStatus updates a user's wealth status
 func (h *AdminHandler) UpdateUserWealthStatus(c *gin.Context) {
 	userIDStr := c.Param("userId")
@@ -543,4 +562,3 @@ func (h *AdminHandler) SendMessageAsUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "message sent"})
 }
-
@@ -1,32 +1,46 @@
 package handlers
 
 import (
-	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
 	"heyspoilme/internal/models"
 	"heyspoilme/internal/services"
-	"heyspoilme/pkg/auth"
 )
 
 type AuthHandler struct {
-	authService    *services.AuthService
-	profileService *services.ProfileService
-	accountService *services.AccountService
-	googleAuth     *auth.GoogleAuth
-	frontendURL    string
+	authService      *services.AuthService
+	profileService   *services.ProfileService
+	accountService   *services.AccountService
+	twoFactorService *services.TwoFactorService
+	oauthService     *services.OAuthService
+	frontendURL      string
 }
 
-func NewAuthHandler(authService *services.AuthService, profileService *services.ProfileService, accountService *services.AccountService, googleAuth *auth.GoogleAuth, frontendURL string) *AuthHandler {
+func NewAuthHandler(authService *services.AuthService, profileService *services.ProfileService, accountService *services.AccountService, twoFactorService *services.TwoFactorService, oauthService *services.OAuthService, frontendURL string) *AuthHandler {
 	return &AuthHandler{
-		authService:    authService,
-		profileService: profileService,
-		accountService: accountService,
-		googleAuth:     googleAuth,
-		frontendURL:    frontendURL,
+		authService:      authService,
+		profileService:   profileService,
+		accountService:   accountService,
+		twoFactorService: twoFactorService,
+		oauthService:     oauthService,
+		frontendURL:      frontendURL,
+	}
+}
+
+// sessionMetadata describes the device a sign in request came from
+func sessionMetadata(c *gin.Context, method models.AuthMethod) models.SessionMetadata {
+	return models.SessionMetadata{
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+		AuthMethod: method,
 	}
 }
 
@@ -43,16 +57,18 @@ func (h *AuthHandler) Signup(c *gin.Context) {
 		return
 	}
 
-	token, err := h.authService.GenerateToken(user)
+	tokens, err := h.authService.GenerateToken(user, sessionMetadata(c, models.AuthMethodPassword))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"token":  token,
-		"user":   user,
-		"is_new": true,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"user":          user,
+		"is_new":        true,
 	})
 }
 
@@ -63,13 +79,111 @@ func (h *AuthHandler) Signin(c *gin.Context) {
 		return
 	}
 
-	user, err := h.authService.Signin(req.Email, req.Password)
+	user, err := h.authService.Signin(req.Email, req.Password, c.ClientIP())
 	if err != nil {
+		var throttled *services.LoginThrottledError
+		if errors.As(err, &throttled) {
+			c.Header("Retry-After", throttled.RetryAfterSeconds())
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "locked": throttled.Locked})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
-	token, err := h.authService.GenerateToken(user)
+	h.signinOrChallenge(c, user, models.AuthMethodPassword, false)
+}
+
+// RequestMagicLink emails a one-time sign-in link
+func (h *AuthHandler) RequestMagicLink(c *gin.Context) {
+	var req models.MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.authService.RequestMagicLink(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send sign-in link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the address is valid, a sign-in link is on its way"})
+}
+
+// VerifyMagicLink signs in with the token from a magic link email
+func (h *AuthHandler) VerifyMagicLink(c *gin.Context) {
+	var req models.MagicLinkVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, isNew, err := h.authService.SigninWithMagicLink(req.Token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidMagicLink) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign in"})
+		return
+	}
+
+	h.signinOrChallenge(c, user, models.AuthMethodMagicLink, isNew)
+}
+
+// signinOrChallenge finishes a first-factor signin, or answers with a 2FA
+// challenge when the user has two-factor authentication enabled
+func (h *AuthHandler) signinOrChallenge(c *gin.Context, user *models.User, method models.AuthMethod, isNew bool) {
+	twoFactorEnabled, err := h.twoFactorService.IsEnabled(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign in"})
+		return
+	}
+
+	// With 2FA on, the first factor only earns a challenge; tokens are issued by VerifyTwoFactor
+	if twoFactorEnabled {
+		challengeToken, expiresIn, err := h.twoFactorService.CreateChallenge(user.ID, method)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign in"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"challenge_token":     challengeToken,
+			"expires_in":          expiresIn,
+		})
+		return
+	}
+
+	h.completeSignin(c, user, method, isNew)
+}
+
+// VerifyTwoFactor completes a password, magic link or provider signin with a
+// TOTP or recovery code
+func (h *AuthHandler) VerifyTwoFactor(c *gin.Context) {
+	var req models.TwoFactorVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, method, err := h.twoFactorService.VerifyChallenge(req.ChallengeToken, req.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidChallenge) || errors.Is(err, services.ErrInvalidTwoFactorCode) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+
+	h.completeSignin(c, user, method, false)
+}
+
+// completeSignin starts a session and writes the signin response
+func (h *AuthHandler) completeSignin(c *gin.Context, user *models.User, method models.AuthMethod, isNew bool) {
+	tokens, err := h.authService.GenerateToken(user, sessionMetadata(c, method))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
@@ -78,61 +192,160 @@ func (h *AuthHandler) Signin(c *gin.Context) {
 	profile, _ := h.profileService.GetProfile(user.ID)
 
 	c.JSON(http.StatusOK, gin.H{
-		"token":   token,
-		"user":    user,
-		"profile": profile,
-		"is_new":  false,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"user":          user,
+		"profile":       profile,
+		"is_new":        isNew,
 	})
 }
 
+// oauthStateCookie binds an in-progress provider login to the browser that started it
+const oauthStateCookie = "oauth_state"
+
 func (h *AuthHandler) GoogleLogin(c *gin.Context) {
-	url := h.googleAuth.GetAuthURL()
-	c.Redirect(http.StatusTemporaryRedirect, url)
+	h.oauthLogin(c, "google")
 }
 
 func (h *AuthHandler) GoogleCallback(c *gin.Context) {
+	h.oauthCallback(c, "google")
+}
+
+// OAuthLogin redirects to any configured identity provider
+func (h *AuthHandler) OAuthLogin(c *gin.Context) {
+	h.oauthLogin(c, c.Param("provider"))
+}
+
+func (h *AuthHandler) OAuthCallback(c *gin.Context) {
+	h.oauthCallback(c, c.Param("provider"))
+}
+
+func (h *AuthHandler) oauthLogin(c *gin.Context, provider string) {
+	authURL, state, err := h.oauthService.BeginLogin(c.Request.Context(), provider)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("[OAuth] Failed to start %s login: %v", provider, err)
+		c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/error?error=provider_unavailable")
+		return
+	}
+
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, state, int(10*time.Minute/time.Second), "/api/auth", "", secure, true)
+
+	c.Redirect(http.StatusTemporaryRedirect, authURL)
+}
+
+func (h *AuthHandler) oauthCallback(c *gin.Context, provider string) {
+	if c.Query("error") != "" {
+		c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/error?error=access_denied")
+		return
+	}
+
 	code := c.Query("code")
 	if code == "" {
 		c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/error?error=missing_code")
 		return
 	}
 
-	token, err := h.googleAuth.Exchange(context.Background(), code)
-	if err != nil {
-		c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/error?error=exchange_failed")
+	state := c.Query("state")
+	cookieState, _ := c.Cookie(oauthStateCookie)
+	c.SetCookie(oauthStateCookie, "", -1, "/api/auth", "", false, true)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/error?error=invalid_state")
 		return
 	}
 
-	userInfo, err := h.googleAuth.GetUserInfo(context.Background(), token)
+	loginCode, err := h.oauthService.CompleteLogin(c.Request.Context(), provider, state, code)
 	if err != nil {
-		c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/error?error=userinfo_failed")
+		log.Printf("[OAuth] %s callback failed: %v", provider, err)
+		switch {
+		case errors.Is(err, services.ErrUnknownProvider), errors.Is(err, services.ErrInvalidOAuthState):
+			c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/error?error=invalid_state")
+		case errors.Is(err, services.ErrUnverifiedIdentity):
+			c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/error?error=email_not_verified")
+		default:
+			c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/error?error=exchange_failed")
+		}
 		return
 	}
 
-	user, isNew, err := h.authService.FindOrCreateUser(userInfo.ID, userInfo.Email)
-	if err != nil {
-		c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/error?error=user_creation_failed")
+	c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/callback?code="+url.QueryEscape(loginCode))
+}
+
+// ExchangeLoginCode swaps the one-time code from a provider callback for tokens,
+// or for a 2FA challenge when the user has it on
+func (h *AuthHandler) ExchangeLoginCode(c *gin.Context) {
+	var req models.OAuthExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	jwtToken, err := h.authService.GenerateToken(user)
+	user, loginCode, err := h.oauthService.ExchangeLoginCode(req.Code)
 	if err != nil {
-		c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"/auth/error?error=token_generation_failed")
+		if errors.Is(err, services.ErrInvalidLoginCode) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign in"})
 		return
 	}
 
-	redirectURL := h.frontendURL + "/auth/callback?token=" + jwtToken
-	if isNew {
-		redirectURL += "&is_new=true"
+	// Provider identities are linked by email, so they must not get around 2FA
+	h.signinOrChallenge(c, user, models.AuthMethod(loginCode.Provider), loginCode.IsNew)
+}
+
+// RefreshToken issues a new access token and rotates the refresh token
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.Redirect(http.StatusTemporaryRedirect, redirectURL)
+	tokens, err := h.authService.RefreshToken(req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
 }
 
+// Logout revokes the session the request was made with
 func (h *AuthHandler) Logout(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	sessionID := c.MustGet("session_id").(uuid.UUID)
+
+	if err := h.authService.Logout(userID, sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
 }
 
+// LogoutAll revokes every session of the current user
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	if err := h.authService.LogoutAll(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "logged out from all devices"})
+}
+
 func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
 	userID := c.MustGet("user_id").(uuid.UUID)
 
@@ -176,13 +389,67 @@ func (h *AuthHandler) ResendVerificationEmail(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "verification email sent"})
 }
 
+// RequestPasswordReset sends a password reset link if the email belongs to an account
+func (h *AuthHandler) RequestPasswordReset(c *gin.Context) {
+	var req models.PasswordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.authService.RequestPasswordReset(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send reset email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "if an account exists for this email, a reset link has been sent"})
+}
+
+// ConfirmPasswordReset sets a new password using the token from the reset email
+func (h *AuthHandler) ConfirmPasswordReset(c *gin.Context) {
+	var req models.PasswordResetConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.authService.ResetPassword(req.Token, req.Password); err != nil {
+		if errors.Is(err, services.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password reset successfully"})
+}
+
+// DeleteAccount schedules the account for deletion. Signing in again during the
+// grace period cancels it.
 func (h *AuthHandler) DeleteAccount(c *gin.Context) {
 	userID := c.MustGet("user_id").(uuid.UUID)
 
-	if err := h.accountService.DeleteAccount(userID); err != nil {
+	scheduledAt, err := h.accountService.ScheduleDeletion(userID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "account deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":               "account scheduled for deletion",
+		"deletion_scheduled_at": scheduledAt,
+	})
+}
+
+// PauseAccount hides the account until the user signs in again
+func (h *AuthHandler) PauseAccount(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	if err := h.accountService.PauseAccount(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to pause account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account paused"})
 }
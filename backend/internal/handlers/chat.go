@@ -83,7 +83,7 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
-	message, err := h.chatService.SendMessage(conversationID, userID, req.Content, req.ImageURL)
+	message, err := h.chatService.SendMessage(conversationID, userID, req.Content, req.ImageURL, req.ReplyToID)
 	if err != nil {
 		if errors.Is(err, services.ErrVerificationRequired) {
 			c.JSON(http.StatusForbidden, gin.H{
@@ -119,25 +119,197 @@ func (h *ChatHandler) GetMessages(c *gin.Context) {
 	}
 
 	limit := 50
-	offset := 0
 	if l := c.Query("limit"); l != "" {
 		if parsed, err := strconv.Atoi(l); err == nil {
 			limit = parsed
 		}
 	}
-	if o := c.Query("offset"); o != "" {
-		if parsed, err := strconv.Atoi(o); err == nil {
-			offset = parsed
+
+	page, err := h.chatService.GetMessages(conversationID, userID, c.Query("before"), c.Query("after"), limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// SyncMessages returns everything sent to the user's conversations after the
+// since cursor, for catching up after a WebSocket reconnect
+func (h *ChatHandler) SyncMessages(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	since := c.Query("since")
+	if since == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since cursor is required"})
+		return
+	}
+
+	limit := 200
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil {
+			limit = parsed
 		}
 	}
 
-	messages, err := h.chatService.GetMessages(conversationID, userID, limit, offset)
+	sync, err := h.chatService.SyncMessages(userID, since, limit)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidMessageCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sync messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sync)
+}
+
+// MarkRead marks the conversation as read up to the given message. Clients
+// connected over WebSocket can send a mark_read command instead.
+func (h *ChatHandler) MarkRead(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	conversationID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation ID"})
+		return
+	}
+
+	var req models.MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"messages": messages})
+	if err := h.chatService.MarkRead(conversationID, userID, req.MessageID); err != nil {
+		h.handleMessageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "conversation marked as read"})
+}
+
+// parseMessagePath reads the conversation and message IDs from the URL
+func parseMessagePath(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	conversationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	messageID, err := uuid.Parse(c.Param("messageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return conversationID, messageID, true
+}
+
+// EditMessage changes the text of one of the user's recent messages
+func (h *ChatHandler) EditMessage(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	conversationID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	var req models.EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := h.chatService.EditMessage(conversationID, messageID, userID, req.Content)
+	if err != nil {
+		h.handleMessageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+// DeleteMessage deletes one of the user's messages for everyone
+func (h *ChatHandler) DeleteMessage(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	conversationID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	message, err := h.chatService.DeleteMessage(conversationID, messageID, userID)
+	if err != nil {
+		h.handleMessageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+// GetMessageEdits returns the earlier versions of an edited message
+func (h *ChatHandler) GetMessageEdits(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	conversationID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	edits, err := h.chatService.GetMessageEdits(conversationID, messageID, userID)
+	if err != nil {
+		h.handleMessageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"edits": edits})
+}
+
+// AddReaction reacts to a message with the emoji in the URL
+func (h *ChatHandler) AddReaction(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	conversationID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	if err := h.chatService.AddReaction(conversationID, messageID, userID, c.Param("emoji")); err != nil {
+		h.handleMessageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "reaction added"})
+}
+
+// RemoveReaction takes back the user's reaction with the emoji in the URL
+func (h *ChatHandler) RemoveReaction(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	conversationID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	if err := h.chatService.RemoveReaction(conversationID, messageID, userID, c.Param("emoji")); err != nil {
+		h.handleMessageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "reaction removed"})
+}
+
+func (h *ChatHandler) handleMessageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNotMessageSender), errors.Is(err, services.ErrMessageNotVisible), errors.Is(err, services.ErrNotParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMessageDeleted), errors.Is(err, services.ErrEditWindowExpired):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
 }
 
 func (h *ChatHandler) GetUnreadCount(c *gin.Context) {
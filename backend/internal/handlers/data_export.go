@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/services"
+)
+
+type DataExportHandler struct {
+	dataExportService *services.DataExportService
+}
+
+func NewDataExportHandler(dataExportService *services.DataExportService) *DataExportHandler {
+	return &DataExportHandler{dataExportService: dataExportService}
+}
+
+// RequestExport queues an archive of the current user's data. The download link
+// is emailed when it's ready.
+func (h *DataExportHandler) RequestExport(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	export, err := h.dataExportService.RequestExport(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrExportInProgress), errors.Is(err, services.ErrExportTooSoon):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "export": export})
+		case errors.Is(err, services.ErrExportUnavailable):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to request export"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, export)
+}
+
+// GetExport returns the status of the latest export, with a download link once it's ready
+func (h *DataExportHandler) GetExport(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	export, err := h.dataExportService.GetLatestExport(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get export"})
+		return
+	}
+	if export == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no export requested"})
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
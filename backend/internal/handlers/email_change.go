@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/internal/services"
+)
+
+type EmailChangeHandler struct {
+	emailChangeService *services.EmailChangeService
+}
+
+func NewEmailChangeHandler(emailChangeService *services.EmailChangeService) *EmailChangeHandler {
+	return &EmailChangeHandler{emailChangeService: emailChangeService}
+}
+
+// RequestChange sends a confirmation link to the new address. The current
+// address keeps working until the link is used.
+func (h *EmailChangeHandler) RequestChange(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	sessionID := c.MustGet("session_id").(uuid.UUID)
+
+	var req models.EmailChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.emailChangeService.RequestChange(userID, sessionID, req.NewEmail, req.Password, c.ClientIP()); err != nil {
+		h.handleError(c, err, "failed to request email change")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Check your new email to confirm the change"})
+}
+
+// ConfirmChange switches the account to the new address
+func (h *EmailChangeHandler) ConfirmChange(c *gin.Context) {
+	var req models.EmailChangeTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	change, err := h.emailChangeService.ConfirmChange(req.Token)
+	if err != nil {
+		h.handleError(c, err, "failed to confirm email change")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email changed", "email": change.NewEmail})
+}
+
+// CancelChange stops a pending change, or undoes a confirmed one, from the link
+// sent to the old address
+func (h *EmailChangeHandler) CancelChange(c *gin.Context) {
+	var req models.EmailChangeTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	change, err := h.emailChangeService.CancelChange(req.Token)
+	if err != nil {
+		h.handleError(c, err, "failed to cancel email change")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email change cancelled", "email": change.OldEmail, "reverted": change.Reverted})
+}
+
+func (h *EmailChangeHandler) handleError(c *gin.Context, err error, fallback string) {
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", throttled.RetryAfterSeconds())
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "locked": throttled.Locked})
+	case errors.Is(err, services.ErrReauthenticationRequired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "reauthentication_required": true})
+	case errors.Is(err, services.ErrEmailTaken), errors.Is(err, services.ErrPreviousEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEmailUnchanged), errors.Is(err, services.ErrInvalidEmailChangeToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -1,8 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
-	"strings"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -13,20 +14,26 @@ import (
 )
 
 type WebSocketHandler struct {
-	hub             *websocket.Hub
-	authService     *services.AuthService
-	presenceService *services.PresenceService
-	upgrader        gorillaws.Upgrader
+	hub          *websocket.Hub
+	authService  *services.AuthService
+	eventService *services.EventService
+	upgrader     gorillaws.Upgrader
 }
 
-func NewWebSocketHandler(hub *websocket.Hub, authService *services.AuthService, presenceService *services.PresenceService) *WebSocketHandler {
+func NewWebSocketHandler(hub *websocket.Hub, authService *services.AuthService, eventService *services.EventService, allowedOrigins []string) *WebSocketHandler {
+	origins := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		origins[origin] = true
+	}
+
 	return &WebSocketHandler{
-		hub:             hub,
-		authService:     authService,
-		presenceService: presenceService,
+		hub:          hub,
+		authService:  authService,
+		eventService: eventService,
 		upgrader: gorillaws.Upgrader{
+			// Only our own frontends may open a socket, same as the CORS allow-list
 			CheckOrigin: func(r *http.Request) bool {
-				return true
+				return origins[r.Header.Get("Origin")]
 			},
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -34,72 +41,85 @@ func NewWebSocketHandler(hub *websocket.Hub, authService *services.AuthService,
 	}
 }
 
-func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
-	// Get token from query parameter for WebSocket
-	token := c.Query("token")
-	if token == "" {
-		// Also try Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader != "" {
-			parts := strings.Split(authHeader, " ")
-			if len(parts) == 2 && parts[0] == "Bearer" {
-				token = parts[1]
-			}
-		}
+// IssueTicket returns a single-use ticket for connecting to /ws. Browsers can't
+// set headers on WebSocket requests, and a ticket in the URL is harmless once
+// used, unlike an access token.
+func (h *WebSocketHandler) IssueTicket(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	sessionID := c.MustGet("session_id").(uuid.UUID)
+
+	ticket, expiresIn, err := h.authService.IssueWSTicket(userID, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue ticket"})
+		return
 	}
 
-	if token == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     ticket,
+		"expires_in": expiresIn,
+	})
+}
+
+// parseSince reads the sequence number of the last event a reconnecting client
+// saw. Fresh connections leave it out and get -1.
+func parseSince(c *gin.Context) (int64, bool) {
+	value := c.Query("since")
+	if value == "" {
+		return -1, true
+	}
+	since, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || since < 0 {
+		return 0, false
+	}
+	return since, true
+}
+
+func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
+	since, ok := parseSince(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since"})
 		return
 	}
 
-	claims, err := h.authService.ValidateToken(token)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+	// Check the origin before redeeming, so a cross-origin attempt can't burn
+	// a valid ticket. The upgrader checks it again.
+	if !h.upgrader.CheckOrigin(c.Request) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
 		return
 	}
 
-	userID := claims.UserID
+	ticket := c.Query("ticket")
+	if ticket == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing ticket"})
+		return
+	}
 
-	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	wsTicket, err := h.authService.RedeemWSTicket(ticket)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidWSTicket) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to validate ticket"})
 		return
 	}
 
-	client := websocket.NewClient(h.hub, conn, userID)
-	h.hub.Register(client)
-
-	h.presenceService.SetOnline(userID)
-
-	go func() {
-		client.WritePump()
-	}()
-
-	go func() {
-		client.ReadPump()
-		h.presenceService.SetOffline(userID)
-	}()
-}
-
-func (h *WebSocketHandler) HandleConnection(c *gin.Context) {
-	userID := c.MustGet("user_id").(uuid.UUID)
+	userID := wsTicket.UserID
 
 	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		return
 	}
 
-	client := websocket.NewClient(h.hub, conn, userID)
+	client := websocket.NewClient(h.hub, conn, userID, wsTicket.SessionID, c.ClientIP())
+	// The hub marks the user online with their first connection and offline
+	// once the last one closes
 	h.hub.Register(client)
 
-	h.presenceService.SetOnline(userID)
-
-	go func() {
-		client.WritePump()
-	}()
+	go client.WritePump()
+	go client.ReadPump()
 
-	go func() {
-		client.ReadPump()
-		h.presenceService.SetOffline(userID)
-	}()
+	// Registering first means nothing published meanwhile is missed. Clients
+	// drop events they get twice by their sequence number.
+	h.eventService.Resume(client, since)
 }
@@ -1,27 +1,27 @@
 package middleware
 
 import (
-	"errors"
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+
+	"heyspoilme/internal/repository"
+	"heyspoilme/pkg/token"
 )
 
 type AuthMiddleware struct {
-	jwtSecret string
-}
-
-type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
-	jwt.RegisteredClaims
+	keySet      *token.KeySet
+	sessionRepo *repository.SessionRepository
 }
 
-func NewAuthMiddleware(jwtSecret string) *AuthMiddleware {
-	return &AuthMiddleware{jwtSecret: jwtSecret}
+func NewAuthMiddleware(keySet *token.KeySet, sessionRepo *repository.SessionRepository) *AuthMiddleware {
+	return &AuthMiddleware{
+		keySet:      keySet,
+		sessionRepo: sessionRepo,
+	}
 }
 
 func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
@@ -40,35 +40,39 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
-		claims, err := m.validateToken(parts[1])
+		claims, err := m.keySet.Parse(parts[1])
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
 
-		c.Set("user_id", claims.UserID)
-		c.Set("email", claims.Email)
-
-		c.Next()
-	}
-}
+		// Reject tokens whose session was logged out or revoked
+		if claims.SessionID == uuid.Nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			c.Abort()
+			return
+		}
+		active, err := m.sessionRepo.IsActive(claims.SessionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to validate session"})
+			c.Abort()
+			return
+		}
+		if !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+			c.Abort()
+			return
+		}
 
-func (m *AuthMiddleware) validateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
+		if err := m.sessionRepo.Touch(claims.SessionID); err != nil {
+			log.Printf("[Auth] Failed to update last use of session %s: %v", claims.SessionID, err)
 		}
-		return []byte(m.jwtSecret), nil
-	})
 
-	if err != nil {
-		return nil, err
-	}
+		c.Set("user_id", claims.UserID)
+		c.Set("email", claims.Email)
+		c.Set("session_id", claims.SessionID)
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+		c.Next()
 	}
-
-	return nil, errors.New("invalid token")
 }
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DataExportStatus string
+
+const (
+	DataExportStatusPending    DataExportStatus = "pending"
+	DataExportStatusProcessing DataExportStatus = "processing"
+	DataExportStatusReady      DataExportStatus = "ready"
+	DataExportStatusFailed     DataExportStatus = "failed"
+	DataExportStatusExpired    DataExportStatus = "expired"
+)
+
+// DataExport is a request for an archive of everything stored about a user
+type DataExport struct {
+	ID          uuid.UUID        `json:"id" db:"id"`
+	UserID      uuid.UUID        `json:"user_id" db:"user_id"`
+	Status      DataExportStatus `json:"status" db:"status"`
+	S3Key       string           `json:"-" db:"s3_key"`
+	SizeBytes   int64            `json:"size_bytes,omitempty" db:"size_bytes"`
+	Error       string           `json:"-" db:"error"`
+	CreatedAt   time.Time        `json:"created_at" db:"created_at"`
+	StartedAt   *time.Time       `json:"started_at,omitempty" db:"started_at"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty" db:"completed_at"`
+	ExpiresAt   *time.Time       `json:"expires_at,omitempty" db:"expires_at"`
+	DownloadURL string           `json:"download_url,omitempty" db:"-"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "github.com/google/uuid"
+
+// EmailChange describes a confirmed or cancelled change of a user's email
+type EmailChange struct {
+	UserID   uuid.UUID `json:"user_id"`
+	OldEmail string    `json:"old_email"`
+	NewEmail string    `json:"new_email"`
+	Reverted bool      `json:"reverted"` // Cancelled after the change had already been confirmed
+}
+
+type EmailChangeRequest struct {
+	NewEmail string `json:"new_email" binding:"required,email"`
+	Password string `json:"password"`
+}
+
+type EmailChangeTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
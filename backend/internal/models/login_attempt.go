@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// LoginAttempt counts recent failed signins for an account email or a client IP
+type LoginAttempt struct {
+	Key          string     `json:"key" db:"key"`
+	Failures     int        `json:"failures" db:"failures"`
+	LastFailedAt time.Time  `json:"last_failed_at" db:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+}
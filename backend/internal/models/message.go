@@ -1,6 +1,10 @@
 package models
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,8 +16,47 @@ type Message struct {
 	SenderID       uuid.UUID  `json:"sender_id" db:"sender_id"`
 	Content        string     `json:"content" db:"content"`
 	ImageURL       *string    `json:"image_url,omitempty" db:"image_url"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty" db:"delivered_at"`
 	ReadAt         *time.Time `json:"read_at,omitempty" db:"read_at"`
 	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	EditedAt       *time.Time `json:"edited_at,omitempty" db:"edited_at"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty" db:"deleted_at"` // Tombstone: content and image are cleared
+	// UpdatedAt is when the message was sent or last edited, deleted or reacted to
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	// Cursor marks this message's position for keyset pagination and sync
+	Cursor string `json:"cursor" db:"-"`
+	// Reactions are counted per emoji from the point of view of the reader
+	Reactions []ReactionCount `json:"reactions,omitempty" db:"-"`
+	// ReplyToID is the message this one quotes and ReplyTo a snippet of it
+	ReplyToID *uuid.UUID     `json:"reply_to_id,omitempty" db:"reply_to_id"`
+	ReplyTo   *QuotedMessage `json:"reply_to,omitempty" db:"-"`
+}
+
+// quoteSnippetLength is how many characters of a quoted message are embedded in replies
+const quoteSnippetLength = 100
+
+// QuotedMessage is the part of a replied-to message shown above the reply
+type QuotedMessage struct {
+	ID       uuid.UUID `json:"id"`
+	SenderID uuid.UUID `json:"sender_id"`
+	Snippet  string    `json:"snippet"`
+	HasImage bool      `json:"has_image"`
+	Deleted  bool      `json:"deleted"` // The quoted message was deleted for everyone since
+}
+
+// QuoteOf builds the snippet of msg embedded in replies to it
+func QuoteOf(msg *Message) *QuotedMessage {
+	quote := &QuotedMessage{ID: msg.ID, SenderID: msg.SenderID}
+	if msg.DeletedAt != nil {
+		quote.Deleted = true
+		return quote
+	}
+	quote.HasImage = msg.ImageURL != nil && *msg.ImageURL != ""
+	quote.Snippet = msg.Content
+	if runes := []rune(msg.Content); len(runes) > quoteSnippetLength {
+		quote.Snippet = string(runes[:quoteSnippetLength]) + "…"
+	}
+	return quote
 }
 
 type MessageWithSender struct {
@@ -22,25 +65,141 @@ type MessageWithSender struct {
 	SenderImage string `json:"sender_image,omitempty"`
 }
 
+// MessageEdit is the content a message had before one of its edits
+type MessageEdit struct {
+	ID              uuid.UUID `json:"id" db:"id"`
+	MessageID       uuid.UUID `json:"message_id" db:"message_id"`
+	PreviousContent string    `json:"previous_content" db:"previous_content"`
+	EditedAt        time.Time `json:"edited_at" db:"edited_at"`
+}
+
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"max=2000"`
+}
+
+// MessagePage is one page of a conversation's messages, newest first
+type MessagePage struct {
+	Messages []Message `json:"messages"`
+	HasMore  bool      `json:"has_more"`
+}
+
+// MessageSync is a batch of the changes in all of a user's conversations after
+// a cursor, in the order they happened. Messages sent since the cursor are in
+// Messages; earlier ones that were edited, deleted or reacted to since are in
+// Updated with their current state.
+type MessageSync struct {
+	Messages []Message `json:"messages"`
+	Updated  []Message `json:"updated"`
+	Cursor   string    `json:"cursor"`
+	HasMore  bool      `json:"has_more"`
+}
+
+// MessageCursor is a position in the (created_at, id) ordering of messages.
+// Unlike an offset it stays valid while new messages arrive.
+type MessageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// CursorFor returns the cursor pointing at msg
+func CursorFor(msg *Message) MessageCursor {
+	return MessageCursor{CreatedAt: msg.CreatedAt, ID: msg.ID}
+}
+
+// SyncCursorFor returns the cursor pointing at msg in the (updated_at, id)
+// ordering sync walks. A message nobody has changed since it was sent has the
+// same cursor in both orderings, so cursors of live messages work for sync.
+func SyncCursorFor(msg *Message) MessageCursor {
+	return MessageCursor{CreatedAt: msg.UpdatedAt, ID: msg.ID}
+}
+
+// After reports whether c comes after other, comparing the way Postgres
+// compares (timestamp, uuid) rows
+func (c MessageCursor) After(other MessageCursor) bool {
+	if !c.CreatedAt.Equal(other.CreatedAt) {
+		return c.CreatedAt.After(other.CreatedAt)
+	}
+	return bytes.Compare(c.ID[:], other.ID[:]) > 0
+}
+
+// Encode returns the opaque form of the cursor handed to clients
+func (c MessageCursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseMessageCursor decodes a cursor produced by Encode
+func ParseMessageCursor(s string) (MessageCursor, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return MessageCursor{}, false
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return MessageCursor{}, false
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return MessageCursor{}, false
+	}
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return MessageCursor{}, false
+	}
+	return MessageCursor{CreatedAt: createdAt, ID: parsedID}, true
+}
+
 type SendMessageRequest struct {
-	Content  string  `json:"content" binding:"max=2000"`
-	ImageURL *string `json:"image_url,omitempty"`
+	Content   string     `json:"content" binding:"max=2000"`
+	ImageURL  *string    `json:"image_url,omitempty"`
+	ReplyToID *uuid.UUID `json:"reply_to_id,omitempty"`
 }
 
 type WSMessageType string
 
 const (
-	WSTypeMessage      WSMessageType = "message"
-	WSTypeTyping       WSMessageType = "typing"
-	WSTypeStopTyping   WSMessageType = "stop_typing"
-	WSTypeReadReceipt  WSMessageType = "read_receipt"
-	WSTypeNotification WSMessageType = "notification"
-	WSTypePresence     WSMessageType = "presence"
+	WSTypeMessage       WSMessageType = "message"
+	WSTypeTyping        WSMessageType = "typing"
+	WSTypeStopTyping    WSMessageType = "stop_typing"
+	WSTypeReadReceipt   WSMessageType = "read_receipt"
+	WSTypeNotification  WSMessageType = "notification"
+	WSTypePresence      WSMessageType = "presence"
+	WSTypeMessageEdit   WSMessageType = "message_edited"
+	WSTypeMessageDelete WSMessageType = "message_deleted"
+	WSTypeReaction      WSMessageType = "reaction"
+	WSTypeDelivery      WSMessageType = "delivery_receipt"
+	WSTypeAck           WSMessageType = "ack"
+	WSTypeResume        WSMessageType = "resume"
+
+	// Commands sent by clients. Typing indicators use WSTypeTyping and
+	// WSTypeStopTyping in both directions, and clients send WSTypeResume to ask
+	// for events they missed.
+	WSTypeMarkRead            WSMessageType = "mark_read"
+	WSTypeHeartbeat           WSMessageType = "heartbeat"
+	WSTypePresenceSubscribe   WSMessageType = "presence_subscribe"
+	WSTypePresenceUnsubscribe WSMessageType = "presence_unsubscribe"
+	WSTypeAckEvents           WSMessageType = "ack_events"
 )
 
+// Transient reports whether events of this type only matter as they happen,
+// like typing indicators and presence. They are neither stored nor replayed.
+func (t WSMessageType) Transient() bool {
+	switch t {
+	case WSTypeTyping, WSTypeStopTyping, WSTypePresence, WSTypeAck, WSTypeResume:
+		return true
+	}
+	return false
+}
+
+// WSProtocolVersion is the version of the inbound command protocol. Commands
+// that leave out the version are treated as this one.
+const WSProtocolVersion = 1
+
 type WSMessage struct {
 	Type    WSMessageType `json:"type"`
 	Payload interface{}   `json:"payload"`
+	// Position in the recipient's event stream. Zero for transient events.
+	Seq int64 `json:"seq,omitempty"`
 }
 
 type WSTypingPayload struct {
@@ -48,11 +207,96 @@ type WSTypingPayload struct {
 	UserID         uuid.UUID `json:"user_id"`
 }
 
+// WSCommand is a message sent by a client. The payload is decoded once the
+// type is known. When ID is set the server answers with an ack carrying it.
+type WSCommand struct {
+	Version int             `json:"v"`
+	ID      string          `json:"id,omitempty"`
+	Type    WSMessageType   `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// WSAckPayload answers a command. Failed commands are always acked, even
+// without an ID, so clients can see protocol errors.
+type WSAckPayload struct {
+	ID    string `json:"id,omitempty"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// WSTypingCommandPayload starts or stops the typing indicator in a conversation
+type WSTypingCommandPayload struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+}
+
+// WSMarkReadPayload marks everything the other side sent in a conversation up
+// to and including MessageID as read
+type WSMarkReadPayload struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	MessageID      uuid.UUID `json:"message_id"`
+}
+
+type MarkReadRequest struct {
+	MessageID uuid.UUID `json:"message_id" binding:"required"`
+}
+
+// WSReadReceiptPayload tells a sender that UserID has read their messages in
+// the conversation up to and including MessageID
+type WSReadReceiptPayload struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	MessageID      uuid.UUID `json:"message_id"`
+	ReadAt         time.Time `json:"read_at"`
+}
+
+// WSDeliveryReceiptPayload tells a sender that their messages in the
+// conversation created up to DeliveredAt have reached UserID
+type WSDeliveryReceiptPayload struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	DeliveredAt    time.Time `json:"delivered_at"`
+}
+
 type WSPresencePayload struct {
 	UserID   uuid.UUID `json:"user_id"`
 	IsOnline bool      `json:"is_online"`
 }
 
+// WSPresenceSubscribePayload lists the users whose presence a connection starts
+// or stops following, typically the profiles it has on screen. Unsubscribing
+// without user_ids stops following everyone.
+type WSPresenceSubscribePayload struct {
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+// WSResumePayload is sent once a connection has been sent the events it missed.
+// Seq is the user's latest event at that point. Reset means events after the
+// client's sequence number were already pruned, so it has to reload its state.
+type WSResumePayload struct {
+	Seq   int64 `json:"seq"`
+	Reset bool  `json:"reset"`
+}
+
+// WSResumeCommandPayload asks for the events after Since, for clients that
+// noticed a gap in sequence numbers
+type WSResumeCommandPayload struct {
+	Since int64 `json:"since"`
+}
+
+// WSAckEventsPayload acknowledges every event up to and including Seq
+type WSAckEventsPayload struct {
+	Seq int64 `json:"seq"`
+}
+
+// UserEvent is an event stored in a user's outbox
+type UserEvent struct {
+	UserID    uuid.UUID       `json:"user_id"`
+	Seq       int64           `json:"seq"`
+	Type      WSMessageType   `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
 // MessageNotificationInfo contains info needed to send email notification for unread message
 type MessageNotificationInfo struct {
 	MessageID      uuid.UUID
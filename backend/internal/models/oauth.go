@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuthState is kept server-side between redirecting to an identity provider
+// and handling its callback
+type OAuthState struct {
+	StateHash    string    `json:"-" db:"state_hash"`
+	Provider     string    `json:"provider" db:"provider"`
+	Nonce        string    `json:"-" db:"nonce"`
+	CodeVerifier string    `json:"-" db:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// OAuthLoginCode is the one-time code the frontend exchanges for tokens after
+// a provider login, so tokens never appear in a redirect URL
+type OAuthLoginCode struct {
+	UserID   uuid.UUID `json:"user_id" db:"user_id"`
+	Provider string    `json:"provider" db:"provider"`
+	IsNew    bool      `json:"is_new" db:"is_new"`
+}
+
+type OAuthExchangeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// LastSeenVisibility says how precisely other members see when the user was
+// last online
+type LastSeenVisibility string
+
+const (
+	LastSeenExact       LastSeenVisibility = "exact"
+	LastSeenApproximate LastSeenVisibility = "approximate" // Shown as one of the LastSeen* buckets
+	LastSeenHidden      LastSeenVisibility = "hidden"
+)
+
+// Buckets shown to others instead of the exact time by approximate last seen
+const (
+	LastSeenRecently  = "recently" // Within the last three days
+	LastSeenThisWeek  = "this_week"
+	LastSeenThisMonth = "this_month"
+	LastSeenLongAgo   = "long_ago"
+)
+
+// PrivacySettings control what other members learn about the user's activity
+type PrivacySettings struct {
+	ReadReceipts     bool               `json:"read_receipts"`      // Off hides read receipts both ways
+	HideOnlineStatus bool               `json:"hide_online_status"` // Others always see the user as offline
+	LastSeen         LastSeenVisibility `json:"last_seen"`
+}
+
+// UpdatePrivacySettingsRequest changes only the settings that are present
+type UpdatePrivacySettingsRequest struct {
+	ReadReceipts     *bool               `json:"read_receipts"`
+	HideOnlineStatus *bool               `json:"hide_online_status"`
+	LastSeen         *LastSeenVisibility `json:"last_seen" binding:"omitempty,oneof=exact approximate hidden"`
+}
+
+// ApproximateLastSeen buckets a last seen time for members who only share it
+// roughly
+func ApproximateLastSeen(lastSeen, now time.Time) string {
+	age := now.Sub(lastSeen)
+	switch {
+	case age <= 3*24*time.Hour:
+		return LastSeenRecently
+	case age <= 7*24*time.Hour:
+		return LastSeenThisWeek
+	case age <= 30*24*time.Hour:
+		return LastSeenThisMonth
+	default:
+		return LastSeenLongAgo
+	}
+}
@@ -89,6 +89,30 @@ type ProfileWithImages struct {
 	IsLiked      bool           `json:"is_liked"`
 	WealthStatus string         `json:"wealth_status,omitempty"`
 	HasLikedMe   bool           `json:"has_liked_me"`
+	// Set instead of LastSeen when the member only shares it approximately
+	LastSeenApprox string `json:"last_seen_approx,omitempty"`
+}
+
+// ApplyPresencePrivacy hides what the member's privacy settings keep from
+// other members. Invisible members appear offline, and their last seen is never
+// exact since it moves with every heartbeat while they are online.
+func (p *ProfileWithImages) ApplyPresencePrivacy(hideOnline bool, lastSeen LastSeenVisibility, now time.Time) {
+	if hideOnline {
+		p.IsOnline = false
+		if lastSeen == LastSeenExact {
+			lastSeen = LastSeenApproximate
+		}
+	}
+
+	switch lastSeen {
+	case LastSeenApproximate:
+		if p.LastSeen != nil {
+			p.LastSeenApprox = ApproximateLastSeen(*p.LastSeen, now)
+		}
+		p.LastSeen = nil
+	case LastSeenHidden:
+		p.LastSeen = nil
+	}
 }
 
 type CreateProfileRequest struct {
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// ReactionCount is how many people reacted to a message with one emoji
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"` // Whether the viewing user is one of them
+}
+
+// WSReactionPayload announces a reaction being added to or removed from a message
+type WSReactionPayload struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	MessageID      uuid.UUID `json:"message_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	Emoji          string    `json:"emoji"`
+	Added          bool      `json:"added"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "github.com/google/uuid"
+
+// S3CleanupItem is an S3 object queued for deletion
+type S3CleanupItem struct {
+	ID       uuid.UUID `json:"id" db:"id"`
+	S3Key    string    `json:"s3_key" db:"s3_key"`
+	Reason   string    `json:"reason" db:"reason"`
+	Attempts int       `json:"attempts" db:"attempts"`
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuthMethod records how a session was signed in: with a password, a magic
+// link, or through the named identity provider
+type AuthMethod string
+
+const (
+	AuthMethodPassword  AuthMethod = "password"
+	AuthMethodGoogle    AuthMethod = "google"
+	AuthMethodMagicLink AuthMethod = "magic_link"
+)
+
+// Session is a server-side login session. Access tokens carry the session ID
+// so they can be rejected once the session is revoked.
+type Session struct {
+	ID               uuid.UUID  `json:"id" db:"id"`
+	UserID           uuid.UUID  `json:"user_id" db:"user_id"`
+	RefreshTokenHash string     `json:"-" db:"refresh_token_hash"`
+	UserAgent        string     `json:"user_agent" db:"user_agent"`
+	IPAddress        string     `json:"ip_address" db:"ip_address"`
+	AuthMethod       AuthMethod `json:"auth_method" db:"auth_method"`
+	ExpiresAt        time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt       time.Time  `json:"last_used_at" db:"last_used_at"`
+}
+
+// SessionMetadata describes the device a new session is created from
+type SessionMetadata struct {
+	UserAgent  string
+	IPAddress  string
+	AuthMethod AuthMethod
+}
+
+// WSConnection is a live WebSocket connection opened with a session's tokens
+type WSConnection struct {
+	ID          uuid.UUID `json:"id"`
+	SessionID   uuid.UUID `json:"session_id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// WSTicket authorizes opening a single WebSocket connection for a session
+type WSTicket struct {
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	SessionID uuid.UUID `json:"session_id" db:"session_id"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// SessionWithConnections is a session as shown in the "signed-in devices" list
+type SessionWithConnections struct {
+	Session
+	Current     bool           `json:"current"`
+	Connections []WSConnection `json:"connections"`
+}
+
+// AuthTokens is returned to clients after a successful sign in or refresh
+type AuthTokens struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Access token lifetime in seconds
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
@@ -41,6 +41,15 @@ func (w WealthStatus) CanViewMessages() bool {
 	return w.CanMessage()
 }
 
+// AccountStatus says whether an account is visible to other users
+type AccountStatus string
+
+const (
+	AccountStatusActive          AccountStatus = "active"
+	AccountStatusPaused          AccountStatus = "paused"
+	AccountStatusPendingDeletion AccountStatus = "pending_deletion"
+)
+
 type User struct {
 	ID                         uuid.UUID      `json:"id" db:"id"`
 	GoogleID                   sql.NullString `json:"-" db:"google_id"`
@@ -51,6 +60,8 @@ type User struct {
 	VerificationTokenExpiresAt sql.NullTime   `json:"-" db:"verification_token_expires_at"`
 	WealthStatus               WealthStatus   `json:"wealth_status" db:"wealth_status"`
 	WealthStatusExpiresAt      sql.NullTime   `json:"wealth_status_expires_at,omitempty" db:"wealth_status_expires_at"`
+	Status                     AccountStatus  `json:"status" db:"status"`
+	DeletionScheduledAt        *time.Time     `json:"deletion_scheduled_at,omitempty" db:"deletion_scheduled_at"`
 	CreatedAt                  time.Time      `json:"created_at" db:"created_at"`
 	UpdatedAt                  time.Time      `json:"updated_at" db:"updated_at"`
 }
@@ -69,3 +80,20 @@ type SigninRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
 }
+
+type PasswordResetRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type MagicLinkVerifyRequest struct {
+	Token string `json:"token" binding:"required"`
+}
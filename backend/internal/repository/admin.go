@@ -437,90 +437,6 @@ func (r *AdminRepository) GetProfileImage(imageID uuid.UUID) (*models.ProfileIma
 	return &img, nil
 }
 
-// DeleteUser deletes a user and all related data
-func (r *AdminRepository) DeleteUser(userID uuid.UUID) error {
-	tx, err := r.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	// Delete in order of dependencies
-	// 1. Delete messages in conversations the user is part of
-	_, err = tx.Exec(`
-		DELETE FROM messages WHERE conversation_id IN (
-			SELECT conversation_id FROM conversation_participants WHERE user_id = $1
-		)
-	`, userID)
-	if err != nil {
-		return err
-	}
-
-	// 2. Delete conversation participants
-	_, err = tx.Exec(`
-		DELETE FROM conversation_participants WHERE conversation_id IN (
-			SELECT conversation_id FROM conversation_participants WHERE user_id = $1
-		)
-	`, userID)
-	if err != nil {
-		return err
-	}
-
-	// 3. Delete conversations
-	_, err = tx.Exec(`
-		DELETE FROM conversations WHERE id IN (
-			SELECT id FROM conversations WHERE initiated_by = $1
-		) OR id NOT IN (SELECT conversation_id FROM conversation_participants)
-	`, userID)
-	if err != nil {
-		return err
-	}
-
-	// 4. Delete likes
-	_, err = tx.Exec(`DELETE FROM likes WHERE liker_id = $1 OR liked_id = $1`, userID)
-	if err != nil {
-		return err
-	}
-
-	// 5. Delete notifications
-	_, err = tx.Exec(`DELETE FROM notifications WHERE user_id = $1`, userID)
-	if err != nil {
-		return err
-	}
-
-	// 6. Delete profile images
-	_, err = tx.Exec(`DELETE FROM profile_images WHERE user_id = $1`, userID)
-	if err != nil {
-		return err
-	}
-
-	// 7. Delete verification requests
-	_, err = tx.Exec(`DELETE FROM verification_requests WHERE user_id = $1`, userID)
-	if err != nil {
-		return err
-	}
-
-	// 8. Delete user presence
-	_, err = tx.Exec(`DELETE FROM user_presence WHERE user_id = $1`, userID)
-	if err != nil {
-		return err
-	}
-
-	// 9. Delete profile
-	_, err = tx.Exec(`DELETE FROM profiles WHERE user_id = $1`, userID)
-	if err != nil {
-		return err
-	}
-
-	// 10. Delete user
-	_, err = tx.Exec(`DELETE FROM users WHERE id = $1`, userID)
-	if err != nil {
-		return err
-	}
-
-	return tx.Commit()
-}
-
 // UpdateUserWealthStatus updates a user's wealth status (trusted member)
 func (r *AdminRepository) UpdateUserWealthStatus(userID uuid.UUID, status string) error {
 	_, err := r.db.Exec(`
@@ -675,13 +591,15 @@ func (r *AdminRepository) ListAllImages(limit, offset int) ([]AdminImage, int, e
 	return images, total, nil
 }
 
-// UpdateUserPresence updates a user's online status and last_seen time
+// UpdateUserPresence updates a user's online status and last_seen time. Users
+// set online stay online without a connection, and the presence sweeper leaves
+// them alone, until they are set offline again.
 func (r *AdminRepository) UpdateUserPresence(userID uuid.UUID, isOnline bool) error {
 	now := time.Now().UTC()
 
 	// Try to update existing record
 	result, err := r.db.Exec(`
-		UPDATE user_presence SET is_online = $1, last_seen = $2 WHERE user_id = $3
+		UPDATE user_presence SET is_online = $1, forced = $1, last_seen = $2 WHERE user_id = $3
 	`, isOnline, now, userID)
 	if err != nil {
 		return err
@@ -691,7 +609,7 @@ func (r *AdminRepository) UpdateUserPresence(userID uuid.UUID, isOnline bool) er
 	if rowsAffected == 0 {
 		// Insert new record
 		_, err = r.db.Exec(`
-			INSERT INTO user_presence (user_id, is_online, last_seen) VALUES ($1, $2, $3)
+			INSERT INTO user_presence (user_id, is_online, forced, last_seen) VALUES ($1, $2, $2, $3)
 		`, userID, isOnline, now)
 		return err
 	}
@@ -792,8 +710,8 @@ func (r *AdminRepository) SendMessageAsUser(senderID, recipientID uuid.UUID, con
 	// Insert message
 	messageID := uuid.New()
 	_, err = r.db.Exec(`
-		INSERT INTO messages (id, conversation_id, sender_id, content, created_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO messages (id, conversation_id, sender_id, content, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
 	`, messageID, conversationID, senderID, content, now)
 	if err != nil {
 		return err
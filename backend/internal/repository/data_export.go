@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+)
+
+type DataExportRepository struct {
+	db *sql.DB
+}
+
+func NewDataExportRepository(db *sql.DB) *DataExportRepository {
+	return &DataExportRepository{db: db}
+}
+
+const dataExportColumns = `id, user_id, status, COALESCE(s3_key, ''), COALESCE(size_bytes, 0), COALESCE(error, ''),
+	created_at, started_at, completed_at, expires_at`
+
+func scanDataExport(row rowScanner) (*models.DataExport, error) {
+	export := &models.DataExport{}
+	var startedAt, completedAt, expiresAt sql.NullTime
+	err := row.Scan(&export.ID, &export.UserID, &export.Status, &export.S3Key, &export.SizeBytes, &export.Error,
+		&export.CreatedAt, &startedAt, &completedAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if startedAt.Valid {
+		export.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		export.CompletedAt = &completedAt.Time
+	}
+	if expiresAt.Valid {
+		export.ExpiresAt = &expiresAt.Time
+	}
+
+	return export, nil
+}
+
+func (r *DataExportRepository) Create(userID uuid.UUID) (*models.DataExport, error) {
+	return scanDataExport(r.db.QueryRow(`
+		INSERT INTO data_exports (id, user_id, status, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+dataExportColumns,
+		uuid.New(), userID, models.DataExportStatusPending, time.Now().UTC()))
+}
+
+// FindLatestForUser returns the user's most recent export, or nil if they never requested one
+func (r *DataExportRepository) FindLatestForUser(userID uuid.UUID) (*models.DataExport, error) {
+	export, err := scanDataExport(r.db.QueryRow(`
+		SELECT `+dataExportColumns+` FROM data_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, userID))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// ClaimNext marks the oldest pending export as processing and returns it, or nil
+// if there is none. Exports stuck in processing for longer than staleAfter (e.g.
+// after a crash) are picked up again. SKIP LOCKED lets several servers run the
+// worker without building the same archive twice.
+func (r *DataExportRepository) ClaimNext(staleAfter time.Duration) (*models.DataExport, error) {
+	now := time.Now().UTC()
+	export, err := scanDataExport(r.db.QueryRow(`
+		UPDATE data_exports SET status = $1, started_at = $2
+		WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status = $3 OR (status = $1 AND started_at < $4)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+dataExportColumns,
+		models.DataExportStatusProcessing, now, models.DataExportStatusPending, now.Add(-staleAfter)))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+func (r *DataExportRepository) MarkReady(id uuid.UUID, s3Key string, sizeBytes int64, expiresAt time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE data_exports SET status = $1, s3_key = $2, size_bytes = $3, completed_at = $4, expires_at = $5
+		WHERE id = $6
+	`, models.DataExportStatusReady, s3Key, sizeBytes, time.Now().UTC(), expiresAt, id)
+	return err
+}
+
+func (r *DataExportRepository) MarkFailed(id uuid.UUID, reason string) error {
+	_, err := r.db.Exec(`
+		UPDATE data_exports SET status = $1, error = $2, completed_at = $3 WHERE id = $4
+	`, models.DataExportStatusFailed, reason, time.Now().UTC(), id)
+	return err
+}
+
+// FindExpired returns ready exports whose download window has closed
+func (r *DataExportRepository) FindExpired(limit int) ([]models.DataExport, error) {
+	rows, err := r.db.Query(`
+		SELECT `+dataExportColumns+` FROM data_exports
+		WHERE status = $1 AND expires_at < $2
+		ORDER BY expires_at
+		LIMIT $3
+	`, models.DataExportStatusReady, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []models.DataExport
+	for rows.Next() {
+		export, err := scanDataExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, *export)
+	}
+
+	return exports, rows.Err()
+}
+
+func (r *DataExportRepository) MarkExpired(id uuid.UUID) error {
+	_, err := r.db.Exec(`
+		UPDATE data_exports SET status = $1, s3_key = NULL WHERE id = $2
+	`, models.DataExportStatusExpired, id)
+	return err
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/pkg/storage"
+)
+
+type DeletionRepository struct {
+	db *sql.DB
+}
+
+func NewDeletionRepository(db *sql.DB) *DeletionRepository {
+	return &DeletionRepository{db: db}
+}
+
+// DeleteUser removes a user and everything tied to them in one transaction, and
+// queues their S3 objects for deletion in that same transaction. It reports
+// false if the user no longer exists, so retrying a deletion is safe.
+func (r *DeletionRepository) DeleteUser(userID uuid.UUID) (bool, error) {
+	return r.deleteUser(userID, `SELECT email FROM users WHERE id = $1 FOR UPDATE`, userID)
+}
+
+// DeleteUserIfDue is DeleteUser for accounts whose deletion grace period has
+// ended. The account is checked under the same row lock as the delete, so one
+// restored by signing in meanwhile is left alone and false is reported.
+func (r *DeletionRepository) DeleteUserIfDue(userID uuid.UUID, now time.Time) (bool, error) {
+	return r.deleteUser(userID, `
+		SELECT email FROM users
+		WHERE id = $1 AND status = $2 AND deletion_scheduled_at <= $3
+		FOR UPDATE
+	`, userID, models.AccountStatusPendingDeletion, now)
+}
+
+// deleteUser locks the user's row with lockQuery, which selects their email,
+// and deletes them if it returns a row
+func (r *DeletionRepository) deleteUser(userID uuid.UUID, lockQuery string, args ...interface{}) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRow(lockQuery, args...).Scan(&email)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	keys, err := collectUserS3Keys(tx, userID)
+	if err != nil {
+		return false, err
+	}
+	if err := enqueueS3Keys(tx, keys, "account_deletion"); err != nil {
+		return false, err
+	}
+
+	// Whole conversations go, including the other participant's messages;
+	// messages and participants cascade from conversations
+	_, err = tx.Exec(`
+		DELETE FROM conversations WHERE id IN (
+			SELECT conversation_id FROM conversation_participants WHERE user_id = $1
+		) OR initiated_by = $1
+	`, userID)
+	if err != nil {
+		return false, err
+	}
+
+	// Keep verification requests this user reviewed as an admin
+	_, err = tx.Exec(`UPDATE verification_requests SET reviewed_by = NULL WHERE reviewed_by = $1`, userID)
+	if err != nil {
+		return false, err
+	}
+
+	// Magic links are keyed by address rather than user
+	_, err = tx.Exec(`DELETE FROM magic_link_tokens WHERE email = $1`, email)
+	if err != nil {
+		return false, err
+	}
+
+	// Everything else (profile, images, likes, notifications, presence,
+	// verification requests, sessions, exports...) cascades from users
+	_, err = tx.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// collectUserS3Keys finds every stored object that belongs to the user or to a
+// conversation that will be deleted with them
+func collectUserS3Keys(tx *sql.Tx, userID uuid.UUID) ([]string, error) {
+	queries := []struct {
+		query   string
+		fromURL bool
+	}{
+		{`SELECT s3_key FROM profile_images WHERE user_id = $1`, false},
+		{`SELECT s3_key FROM data_exports WHERE user_id = $1 AND s3_key IS NOT NULL`, false},
+		{`SELECT image_url FROM messages
+		  WHERE image_url IS NOT NULL AND image_url != ''
+		    AND conversation_id IN (SELECT conversation_id FROM conversation_participants WHERE user_id = $1)`, true},
+		{`SELECT document_url FROM verification_requests WHERE user_id = $1`, true},
+		{`SELECT video_url FROM verification_requests WHERE user_id = $1`, true},
+	}
+
+	var keys []string
+	for _, q := range queries {
+		rows, err := tx.Query(q.query, userID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var value string
+			if err := rows.Scan(&value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if q.fromURL {
+				value = storage.KeyFromURL(value)
+			}
+			if value != "" {
+				keys = append(keys, value)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
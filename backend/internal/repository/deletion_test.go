@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// stubDriver answers each query with the single-column rows of the first table
+// named in it, and records the arguments it was given
+type stubDriver struct {
+	tables map[string][]string
+	args   [][]driver.Value
+}
+
+func (d *stubDriver) Open(string) (driver.Conn, error) { return &stubConn{d}, nil }
+
+type stubConn struct{ driver *stubDriver }
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) { return &stubStmt{c.driver, query}, nil }
+func (c *stubConn) Close() error                              { return nil }
+func (c *stubConn) Begin() (driver.Tx, error)                 { return stubTx{}, nil }
+
+type stubTx struct{}
+
+func (stubTx) Commit() error   { return nil }
+func (stubTx) Rollback() error { return nil }
+
+type stubStmt struct {
+	driver *stubDriver
+	query  string
+}
+
+func (s *stubStmt) Close() error  { return nil }
+func (s *stubStmt) NumInput() int { return -1 }
+
+func (s *stubStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("stub driver only runs queries")
+}
+
+func (s *stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.driver.args = append(s.driver.args, args)
+
+	// The table the query selects from, not the ones in its subqueries
+	from := strings.Fields(s.query[strings.Index(s.query, "FROM ")+len("FROM "):])[0]
+	return &stubRows{values: s.driver.tables[from]}, nil
+}
+
+type stubRows struct {
+	values []string
+}
+
+func (r *stubRows) Columns() []string { return []string{"value"} }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
+
+func TestCollectUserS3Keys(t *testing.T) {
+	const base = "https://bucket.s3.amazonaws.com"
+	stub := &stubDriver{tables: map[string][]string{
+		"profile_images": {"profiles/u/1.jpg", "profiles/u/2.jpg"},
+		"data_exports":   {"exports/u/export.zip"},
+		"messages": {
+			base + "/chat/c/u/photo.jpg",
+			base + "/elsewhere/unknown.jpg", // not one of ours, skipped
+		},
+		"verification_requests": {base + "/profiles/u/id.jpg"},
+	}}
+	sql.Register("stub-"+t.Name(), stub)
+	db, err := sql.Open("stub-"+t.Name(), "")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+
+	userID := uuid.New()
+	keys, err := collectUserS3Keys(tx, userID)
+	if err != nil {
+		t.Fatalf("collectUserS3Keys: %v", err)
+	}
+
+	// Verification requests are read twice, once for the document and once
+	// for the video
+	want := []string{
+		"profiles/u/1.jpg",
+		"profiles/u/2.jpg",
+		"exports/u/export.zip",
+		"chat/c/u/photo.jpg",
+		"profiles/u/id.jpg",
+		"profiles/u/id.jpg",
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+
+	for i, args := range stub.args {
+		if len(args) != 1 || args[0] != userID.String() {
+			t.Errorf("query %d args = %v, want only the user ID", i, args)
+		}
+	}
+}
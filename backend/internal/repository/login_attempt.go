@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"heyspoilme/internal/models"
+)
+
+// LoginAttemptStore keeps failed signin counters. Postgres shares them across
+// servers; the in-memory store suits a single instance or local development.
+type LoginAttemptStore interface {
+	// Get returns the counter for key, or nil if there is none
+	Get(key string) (*models.LoginAttempt, error)
+	// RecordFailure counts a failed attempt. Counters whose last failure is
+	// older than window start again from one.
+	RecordFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempt, error)
+	// Lock blocks key until the given time
+	Lock(key string, until time.Time) error
+	// Reset clears the counter and any lock
+	Reset(key string) error
+	// Prune removes counters that are neither recent nor locked
+	Prune(before time.Time) error
+}
+
+type PostgresLoginAttemptStore struct {
+	db *sql.DB
+}
+
+func NewPostgresLoginAttemptStore(db *sql.DB) *PostgresLoginAttemptStore {
+	return &PostgresLoginAttemptStore{db: db}
+}
+
+func (s *PostgresLoginAttemptStore) Get(key string) (*models.LoginAttempt, error) {
+	attempt, err := scanLoginAttempt(s.db.QueryRow(`
+		SELECT key, failures, last_failed_at, locked_until FROM login_attempts WHERE key = $1
+	`, key))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return attempt, err
+}
+
+func (s *PostgresLoginAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	return scanLoginAttempt(s.db.QueryRow(`
+		INSERT INTO login_attempts (key, failures, last_failed_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failed_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failed_at = EXCLUDED.last_failed_at
+		RETURNING key, failures, last_failed_at, locked_until
+	`, key, now, now.Add(-window)))
+}
+
+func (s *PostgresLoginAttemptStore) Lock(key string, until time.Time) error {
+	_, err := s.db.Exec(`UPDATE login_attempts SET locked_until = $1 WHERE key = $2`, until, key)
+	return err
+}
+
+func (s *PostgresLoginAttemptStore) Reset(key string) error {
+	_, err := s.db.Exec(`DELETE FROM login_attempts WHERE key = $1`, key)
+	return err
+}
+
+func (s *PostgresLoginAttemptStore) Prune(before time.Time) error {
+	_, err := s.db.Exec(`
+		DELETE FROM login_attempts
+		WHERE last_failed_at < $1 AND (locked_until IS NULL OR locked_until < $1)
+	`, before)
+	return err
+}
+
+func scanLoginAttempt(row rowScanner) (*models.LoginAttempt, error) {
+	attempt := &models.LoginAttempt{}
+	var lockedUntil sql.NullTime
+	if err := row.Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailedAt, &lockedUntil); err != nil {
+		return nil, err
+	}
+
+	if lockedUntil.Valid {
+		attempt.LockedUntil = &lockedUntil.Time
+	}
+
+	return attempt, nil
+}
+
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempt
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: make(map[string]models.LoginAttempt)}
+}
+
+func (s *MemoryLoginAttemptStore) Get(key string) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	return &attempt, nil
+}
+
+func (s *MemoryLoginAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok || attempt.LastFailedAt.Before(now.Add(-window)) {
+		attempt.Key = key
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailedAt = now
+	s.attempts[key] = attempt
+
+	return &attempt, nil
+}
+
+func (s *MemoryLoginAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.attempts[key]; ok {
+		attempt.LockedUntil = &until
+		s.attempts[key] = attempt
+	}
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Prune(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, attempt := range s.attempts {
+		if attempt.LastFailedAt.Before(before) && (attempt.LockedUntil == nil || attempt.LockedUntil.Before(before)) {
+			delete(s.attempts, key)
+		}
+	}
+	return nil
+}
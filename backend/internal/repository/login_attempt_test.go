@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryLoginAttemptStore(t *testing.T) {
+	store := NewMemoryLoginAttemptStore()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	const window = time.Hour
+
+	attempt, err := store.Get("ip:203.0.113.1")
+	if err != nil || attempt != nil {
+		t.Fatalf("Get on an empty store = %+v, %v, want nil, nil", attempt, err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		attempt, err = store.RecordFailure("ip:203.0.113.1", now, window)
+		if err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+		if attempt.Failures != i || attempt.Key != "ip:203.0.113.1" {
+			t.Fatalf("attempt = %+v, want %d failures", attempt, i)
+		}
+	}
+
+	// The returned counter is a copy, changing it doesn't change the store
+	attempt.Failures = 100
+	if stored, _ := store.Get("ip:203.0.113.1"); stored.Failures != 3 {
+		t.Errorf("stored failures = %d, want 3", stored.Failures)
+	}
+
+	// A failure after the window starts the count again
+	attempt, err = store.RecordFailure("ip:203.0.113.1", now.Add(window+time.Second), window)
+	if err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+	if attempt.Failures != 1 {
+		t.Errorf("failures after the window = %d, want 1", attempt.Failures)
+	}
+
+	// Reset clears the counter
+	if err := store.Reset("ip:203.0.113.1"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if attempt, _ := store.Get("ip:203.0.113.1"); attempt != nil {
+		t.Errorf("after Reset: attempt = %+v, want nil", attempt)
+	}
+}
+
+func TestMemoryLoginAttemptStoreLock(t *testing.T) {
+	store := NewMemoryLoginAttemptStore()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Locking a key without failures does nothing
+	if err := store.Lock("email:nobody@example.com", now.Add(time.Hour)); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if attempt, _ := store.Get("email:nobody@example.com"); attempt != nil {
+		t.Errorf("attempt = %+v, want nil", attempt)
+	}
+
+	if _, err := store.RecordFailure("email:someone@example.com", now, time.Hour); err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+	until := now.Add(15 * time.Minute)
+	if err := store.Lock("email:someone@example.com", until); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	attempt, _ := store.Get("email:someone@example.com")
+	if attempt.LockedUntil == nil || !attempt.LockedUntil.Equal(until) {
+		t.Errorf("LockedUntil = %v, want %v", attempt.LockedUntil, until)
+	}
+
+	// Further failures keep the lock
+	attempt, _ = store.RecordFailure("email:someone@example.com", now.Add(time.Minute), time.Hour)
+	if attempt.LockedUntil == nil || attempt.Failures != 2 {
+		t.Errorf("attempt = %+v, want 2 failures and the lock kept", attempt)
+	}
+}
+
+func TestMemoryLoginAttemptStorePrune(t *testing.T) {
+	store := NewMemoryLoginAttemptStore()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store.RecordFailure("stale", now.Add(-2*time.Hour), time.Hour)
+	store.RecordFailure("recent", now, time.Hour)
+	store.RecordFailure("locked", now.Add(-2*time.Hour), time.Hour)
+	store.Lock("locked", now.Add(time.Hour))
+
+	if err := store.Prune(now.Add(-time.Hour)); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+
+	for key, want := range map[string]bool{"stale": false, "recent": true, "locked": true} {
+		attempt, _ := store.Get(key)
+		if (attempt != nil) != want {
+			t.Errorf("%s kept = %v, want %v", key, attempt != nil, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MagicLinkRepository struct {
+	db *sql.DB
+}
+
+func NewMagicLinkRepository(db *sql.DB) *MagicLinkRepository {
+	return &MagicLinkRepository{db: db}
+}
+
+// Create stores a new sign-in token and invalidates any earlier unused ones for
+// the address, so only the most recent email link works
+func (r *MagicLinkRepository) Create(email, tokenHash string, expiresAt time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	_, err = tx.Exec(`
+		UPDATE magic_link_tokens SET used_at = $1 WHERE email = $2 AND used_at IS NULL
+	`, now, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO magic_link_tokens (id, email, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.New(), email, tokenHash, expiresAt, now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CountSince returns how many links were requested for the address since the given time
+func (r *MagicLinkRepository) CountSince(email string, since time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRow(`
+		SELECT COUNT(*) FROM magic_link_tokens WHERE email = $1 AND created_at > $2
+	`, email, since).Scan(&count)
+	return count, err
+}
+
+// Consume marks the token as used and returns the address it was sent to. It
+// returns an empty string if the token is unknown, expired or was already used.
+func (r *MagicLinkRepository) Consume(tokenHash string) (string, error) {
+	var email string
+	now := time.Now().UTC()
+	err := r.db.QueryRow(`
+		UPDATE magic_link_tokens SET used_at = $1
+		WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1
+		RETURNING email
+	`, now, tokenHash).Scan(&email)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return email, nil
+}
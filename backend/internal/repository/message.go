@@ -2,11 +2,13 @@ package repository
 
 import (
 	"database/sql"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 
 	"heyspoilme/internal/models"
+	"heyspoilme/pkg/storage"
 )
 
 type MessageRepository struct {
@@ -109,27 +111,19 @@ func (r *MessageRepository) GetUserConversations(userID uuid.UUID) ([]models.Con
 		}
 		partRows.Close()
 
-		var lastMsg models.Message
-		var readAt sql.NullTime
-		var imageURL sql.NullString
-		err = r.db.QueryRow(`
-			SELECT id, conversation_id, sender_id, content, image_url, read_at, created_at
-			FROM messages WHERE conversation_id = $1
-			ORDER BY created_at DESC LIMIT 1
-		`, conv.ID).Scan(&lastMsg.ID, &lastMsg.ConversationID, &lastMsg.SenderID, &lastMsg.Content, &imageURL, &readAt, &lastMsg.CreatedAt)
+		// Edits and deletes happen in place, so the preview always shows the
+		// latest version or the tombstone
+		lastMsg, err := scanMessage(r.db.QueryRow(messageSelect+`
+			WHERE m.conversation_id = $1
+			ORDER BY m.created_at DESC, m.id DESC LIMIT 1
+		`, conv.ID))
 		if err == nil {
-			if readAt.Valid {
-				lastMsg.ReadAt = &readAt.Time
-			}
-			if imageURL.Valid {
-				lastMsg.ImageURL = &imageURL.String
-			}
-			conv.LastMessage = &lastMsg
+			conv.LastMessage = lastMsg
 		}
 
 		r.db.QueryRow(`
 			SELECT COUNT(*) FROM messages 
-			WHERE conversation_id = $1 AND sender_id != $2 AND read_at IS NULL
+			WHERE conversation_id = $1 AND sender_id != $2 AND read_at IS NULL AND deleted_at IS NULL
 		`, conv.ID, userID).Scan(&conv.UnreadCount)
 
 		conversations = append(conversations, conv)
@@ -182,20 +176,29 @@ func (r *MessageRepository) GetConversationParticipants(conversationID uuid.UUID
 	return participants, nil
 }
 
-func (r *MessageRepository) CreateMessage(conversationID, senderID uuid.UUID, content string, imageURL *string) (*models.Message, error) {
+// CreateMessage stores a new message. replyTo is the already validated message
+// it quotes, if any.
+func (r *MessageRepository) CreateMessage(conversationID, senderID uuid.UUID, content string, imageURL *string, replyTo *models.Message) (*models.Message, error) {
+	// Postgres keeps microseconds, so truncate for the cursor to match what's stored
 	msg := &models.Message{
 		ID:             uuid.New(),
 		ConversationID: conversationID,
 		SenderID:       senderID,
 		Content:        content,
 		ImageURL:       imageURL,
-		CreatedAt:      time.Now().UTC(),
+		CreatedAt:      time.Now().UTC().Truncate(time.Microsecond),
+	}
+	msg.UpdatedAt = msg.CreatedAt
+	msg.Cursor = models.CursorFor(msg).Encode()
+	if replyTo != nil {
+		msg.ReplyToID = &replyTo.ID
+		msg.ReplyTo = models.QuoteOf(replyTo)
 	}
 
 	_, err := r.db.Exec(`
-		INSERT INTO messages (id, conversation_id, sender_id, content, image_url, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, msg.ID, msg.ConversationID, msg.SenderID, msg.Content, msg.ImageURL, msg.CreatedAt)
+		INSERT INTO messages (id, conversation_id, sender_id, content, image_url, reply_to_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+	`, msg.ID, msg.ConversationID, msg.SenderID, msg.Content, msg.ImageURL, msg.ReplyToID, msg.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -205,45 +208,314 @@ func (r *MessageRepository) CreateMessage(conversationID, senderID uuid.UUID, co
 	return msg, nil
 }
 
-func (r *MessageRepository) GetMessages(conversationID uuid.UUID, limit, offset int) ([]models.Message, error) {
-	rows, err := r.db.Query(`
-		SELECT id, conversation_id, sender_id, content, image_url, read_at, created_at
-		FROM messages WHERE conversation_id = $1
-		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3
-	`, conversationID, limit, offset)
+// GetMessages returns up to limit messages of a conversation, newest first.
+// With before set only messages older than the cursor are returned, with after
+// set only newer ones. Paging on (created_at, id) instead of an offset means
+// messages arriving mid-scroll don't shift the pages.
+func (r *MessageRepository) GetMessages(conversationID uuid.UUID, before, after *models.MessageCursor, limit int) ([]models.Message, error) {
+	query := messageSelect + ` WHERE m.conversation_id = $1`
+	args := []interface{}{conversationID}
+
+	// Walking forward from after needs the oldest messages past the cursor,
+	// so that query runs ascending and is flipped below
+	ascending := false
+	switch {
+	case before != nil:
+		query += ` AND (m.created_at, m.id) < ($2, $3) ORDER BY m.created_at DESC, m.id DESC`
+		args = append(args, before.CreatedAt, before.ID)
+	case after != nil:
+		query += ` AND (m.created_at, m.id) > ($2, $3) ORDER BY m.created_at ASC, m.id ASC`
+		args = append(args, after.CreatedAt, after.ID)
+		ascending = true
+	default:
+		query += ` ORDER BY m.created_at DESC, m.id DESC`
+	}
+	query += fmt.Sprintf(" LIMIT $%d", len(args)+1)
+	args = append(args, limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if ascending {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	return messages, nil
+}
+
+// GetMessagesSince returns up to limit messages from all of the user's
+// conversations sent or changed after the cursor, in (updated_at, id) order.
+// Clients call it after reconnecting to catch up on whatever they missed while
+// offline, edits and deletes included.
+func (r *MessageRepository) GetMessagesSince(userID uuid.UUID, since models.MessageCursor, limit int) ([]models.Message, error) {
+	rows, err := r.db.Query(messageSelect+`
+		JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id
+		WHERE cp.user_id = $1 AND (m.updated_at, m.id) > ($2, $3)
+		ORDER BY m.updated_at ASC, m.id ASC
+		LIMIT $4
+	`, userID, since.CreatedAt, since.ID, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []models.Message
+	return scanMessages(rows)
+}
+
+func scanMessages(rows *sql.Rows) ([]models.Message, error) {
+	messages := []models.Message{}
 	for rows.Next() {
-		var msg models.Message
-		var readAt sql.NullTime
-		var imageURL sql.NullString
-		err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Content, &imageURL, &readAt, &msg.CreatedAt)
+		msg, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
-		if readAt.Valid {
-			msg.ReadAt = &readAt.Time
+		messages = append(messages, *msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// messageSelect selects messages as m along with the message each one quotes.
+// Rows are read with scanMessage.
+const messageSelect = `
+	SELECT m.id, m.conversation_id, m.sender_id, m.content, m.image_url, m.read_at, m.created_at, m.edited_at, m.deleted_at,
+		m.delivered_at, m.updated_at, q.id, q.sender_id, q.content, q.image_url, q.deleted_at
+	FROM messages m
+	LEFT JOIN messages q ON q.id = m.reply_to_id`
+
+// scanMessage reads a row selected with messageSelect
+func scanMessage(row rowScanner) (*models.Message, error) {
+	var msg models.Message
+	var readAt, editedAt, deletedAt, deliveredAt sql.NullTime
+	var imageURL sql.NullString
+	var quoteID, quoteSenderID uuid.NullUUID
+	var quoteContent, quoteImageURL sql.NullString
+	var quoteDeletedAt sql.NullTime
+	err := row.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Content, &imageURL, &readAt, &msg.CreatedAt, &editedAt, &deletedAt,
+		&deliveredAt, &msg.UpdatedAt, &quoteID, &quoteSenderID, &quoteContent, &quoteImageURL, &quoteDeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if quoteID.Valid {
+		quoted := models.Message{ID: quoteID.UUID, SenderID: quoteSenderID.UUID, Content: quoteContent.String}
+		if quoteImageURL.Valid {
+			quoted.ImageURL = &quoteImageURL.String
 		}
-		if imageURL.Valid {
-			msg.ImageURL = &imageURL.String
+		if quoteDeletedAt.Valid {
+			quoted.DeletedAt = &quoteDeletedAt.Time
 		}
-		messages = append(messages, msg)
+		msg.ReplyToID = &quoted.ID
+		msg.ReplyTo = models.QuoteOf(&quoted)
 	}
+	if readAt.Valid {
+		msg.ReadAt = &readAt.Time
+	}
+	if imageURL.Valid {
+		msg.ImageURL = &imageURL.String
+	}
+	if editedAt.Valid {
+		msg.EditedAt = &editedAt.Time
+	}
+	if deliveredAt.Valid {
+		msg.DeliveredAt = &deliveredAt.Time
+	}
+	if deletedAt.Valid {
+		msg.DeletedAt = &deletedAt.Time
+	}
+	msg.Cursor = models.CursorFor(&msg).Encode()
+	return &msg, nil
+}
 
-	return messages, nil
+// FindMessage returns a single message, or nil if it doesn't exist
+func (r *MessageRepository) FindMessage(messageID uuid.UUID) (*models.Message, error) {
+	msg, err := scanMessage(r.db.QueryRow(messageSelect+` WHERE m.id = $1`, messageID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return msg, err
 }
 
-func (r *MessageRepository) MarkMessagesAsRead(conversationID, userID uuid.UUID) error {
-	_, err := r.db.Exec(`
-		UPDATE messages SET read_at = $1
+// EditMessage replaces a message's content and records what it said before in
+// message_edits. Returns nil if the message is gone or already deleted.
+func (r *MessageRepository) EditMessage(messageID uuid.UUID, content string) (*models.Message, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow(`
+		SELECT content FROM messages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+	`, messageID).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if _, err := tx.Exec(`
+		INSERT INTO message_edits (message_id, previous_content, edited_at)
+		VALUES ($1, $2, $3)
+	`, messageID, previous, now); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE messages SET content = $1, edited_at = $2, updated_at = $2 WHERE id = $3
+	`, content, now, messageID); err != nil {
+		return nil, err
+	}
+
+	msg, err := scanMessage(tx.QueryRow(messageSelect+` WHERE m.id = $1`, messageID))
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, tx.Commit()
+}
+
+// DeleteMessage turns a message into a tombstone for everyone: the content,
+// image, edit history and reactions are removed but the row stays so the
+// thread keeps its shape, and replies quoting it show it as deleted. The image
+// object is queued for removal from S3 in the same transaction. Returns nil if
+// the message is gone or already deleted.
+func (r *MessageRepository) DeleteMessage(messageID uuid.UUID) (*models.Message, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var imageURL sql.NullString
+	err = tx.QueryRow(`
+		SELECT image_url FROM messages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+	`, messageID).Scan(&imageURL)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if imageURL.Valid && imageURL.String != "" {
+		if err := enqueueS3Keys(tx, []string{storage.KeyFromURL(imageURL.String)}, "message_deletion"); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE message_id = $1`, messageID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_reactions WHERE message_id = $1`, messageID); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE messages SET content = '', image_url = NULL, deleted_at = $1, updated_at = $1 WHERE id = $2
+	`, time.Now().UTC(), messageID); err != nil {
+		return nil, err
+	}
+
+	msg, err := scanMessage(tx.QueryRow(messageSelect+` WHERE m.id = $1`, messageID))
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, tx.Commit()
+}
+
+// GetMessageEdits returns a message's earlier versions, oldest first
+func (r *MessageRepository) GetMessageEdits(messageID uuid.UUID) ([]models.MessageEdit, error) {
+	rows, err := r.db.Query(`
+		SELECT id, message_id, previous_content, edited_at
+		FROM message_edits WHERE message_id = $1
+		ORDER BY edited_at ASC
+	`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []models.MessageEdit{}
+	for rows.Next() {
+		var edit models.MessageEdit
+		if err := rows.Scan(&edit.ID, &edit.MessageID, &edit.PreviousContent, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+
+	return edits, rows.Err()
+}
+
+// MarkReadUpTo marks the messages the other side sent in a conversation, up to
+// and including the cursor, as read (and delivered, if that was missed).
+// Returns how many messages changed.
+func (r *MessageRepository) MarkReadUpTo(conversationID, readerID uuid.UUID, upTo models.MessageCursor, readAt time.Time) (int64, error) {
+	result, err := r.db.Exec(`
+		UPDATE messages SET read_at = $1, delivered_at = COALESCE(delivered_at, $1)
 		WHERE conversation_id = $2 AND sender_id != $3 AND read_at IS NULL
-	`, time.Now().UTC(), conversationID, userID)
-	return err
+		  AND (created_at, id) <= ($4, $5)
+	`, readAt, conversationID, readerID, upTo.CreatedAt, upTo.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MarkDelivered marks everything the other side sent in a conversation as
+// delivered to the recipient. Returns how many messages changed.
+func (r *MessageRepository) MarkDelivered(conversationID, recipientID uuid.UUID, deliveredAt time.Time) (int64, error) {
+	result, err := r.db.Exec(`
+		UPDATE messages SET delivered_at = $1
+		WHERE conversation_id = $2 AND sender_id != $3 AND delivered_at IS NULL
+	`, deliveredAt, conversationID, recipientID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MarkDeliveredToUser marks messages in all of the user's conversations as
+// delivered to them and returns the conversations that had any
+func (r *MessageRepository) MarkDeliveredToUser(recipientID uuid.UUID, deliveredAt time.Time) ([]uuid.UUID, error) {
+	rows, err := r.db.Query(`
+		WITH delivered AS (
+			UPDATE messages m SET delivered_at = $1
+			FROM conversation_participants cp
+			WHERE cp.conversation_id = m.conversation_id AND cp.user_id = $2
+			  AND m.sender_id != $2 AND m.delivered_at IS NULL
+			RETURNING m.conversation_id
+		)
+		SELECT DISTINCT conversation_id FROM delivered
+	`, deliveredAt, recipientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversationIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		conversationIDs = append(conversationIDs, id)
+	}
+	return conversationIDs, rows.Err()
 }
 
 func (r *MessageRepository) GetUnreadMessageCount(userID uuid.UUID) (int, error) {
@@ -251,7 +523,7 @@ func (r *MessageRepository) GetUnreadMessageCount(userID uuid.UUID) (int, error)
 	err := r.db.QueryRow(`
 		SELECT COUNT(*) FROM messages m
 		JOIN conversation_participants cp ON m.conversation_id = cp.conversation_id
-		WHERE cp.user_id = $1 AND m.sender_id != $1 AND m.read_at IS NULL
+		WHERE cp.user_id = $1 AND m.sender_id != $1 AND m.read_at IS NULL AND m.deleted_at IS NULL
 	`, userID).Scan(&count)
 	return count, err
 }
@@ -294,6 +566,7 @@ func (r *MessageRepository) GetUnreadMessagesNeedingNotification(olderThan time.
 		WHERE m.read_at IS NULL 
 		  AND m.created_at < $1
 		  AND m.notification_email_sent_at IS NULL
+		  AND m.deleted_at IS NULL
 		  AND u.email_verified = true
 	`, cutoffTime)
 	if err != nil {
@@ -328,42 +601,3 @@ func (r *MessageRepository) MarkNotificationEmailSent(messageID uuid.UUID) error
 	`, time.Now().UTC(), messageID)
 	return err
 }
-
-// DeleteUserConversations deletes all conversations where the user is a participant
-// This also handles cleanup of related messages and participants
-func (r *MessageRepository) DeleteUserConversations(userID uuid.UUID) error {
-	// Get all conversations the user is in
-	rows, err := r.db.Query(`
-		SELECT conversation_id FROM conversation_participants WHERE user_id = $1
-	`, userID)
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
-
-	var conversationIDs []uuid.UUID
-	for rows.Next() {
-		var convID uuid.UUID
-		if err := rows.Scan(&convID); err != nil {
-			return err
-		}
-		conversationIDs = append(conversationIDs, convID)
-	}
-
-	for _, convID := range conversationIDs {
-		// Delete messages in the conversation
-		if _, err := r.db.Exec(`DELETE FROM messages WHERE conversation_id = $1`, convID); err != nil {
-			return err
-		}
-		// Delete participants
-		if _, err := r.db.Exec(`DELETE FROM conversation_participants WHERE conversation_id = $1`, convID); err != nil {
-			return err
-		}
-		// Delete the conversation
-		if _, err := r.db.Exec(`DELETE FROM conversations WHERE id = $1`, convID); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+)
+
+// OAuthRepository stores identity provider links and the short-lived state of
+// in-progress provider logins
+type OAuthRepository struct {
+	db *sql.DB
+}
+
+func NewOAuthRepository(db *sql.DB) *OAuthRepository {
+	return &OAuthRepository{db: db}
+}
+
+// FindUserIDByIdentity returns the user linked to the provider account, or uuid.Nil
+func (r *OAuthRepository) FindUserIDByIdentity(provider, subject string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := r.db.QueryRow(`
+		SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2
+	`, provider, subject).Scan(&userID)
+
+	if err == sql.ErrNoRows {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, nil
+}
+
+func (r *OAuthRepository) LinkIdentity(userID uuid.UUID, provider, subject, email string) error {
+	_, err := r.db.Exec(`
+		INSERT INTO user_identities (id, user_id, provider, subject, email, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, uuid.New(), userID, provider, subject, email, time.Now().UTC())
+	return err
+}
+
+// CreateState stores the state of a new provider login and clears out expired ones
+func (r *OAuthRepository) CreateState(state *models.OAuthState) error {
+	now := time.Now().UTC()
+
+	if _, err := r.db.Exec(`DELETE FROM oauth_states WHERE expires_at < $1`, now); err != nil {
+		return err
+	}
+
+	_, err := r.db.Exec(`
+		INSERT INTO oauth_states (state_hash, provider, nonce, code_verifier, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, state.StateHash, state.Provider, state.Nonce, state.CodeVerifier, state.ExpiresAt, now)
+	return err
+}
+
+// ConsumeState deletes and returns the login state so it can only be used once.
+// It returns nil if the state is unknown, expired or belongs to another provider.
+func (r *OAuthRepository) ConsumeState(stateHash, provider string) (*models.OAuthState, error) {
+	state := &models.OAuthState{}
+	err := r.db.QueryRow(`
+		DELETE FROM oauth_states
+		WHERE state_hash = $1 AND provider = $2 AND expires_at > $3
+		RETURNING state_hash, provider, nonce, code_verifier, expires_at
+	`, stateHash, provider, time.Now().UTC()).Scan(&state.StateHash, &state.Provider, &state.Nonce, &state.CodeVerifier, &state.ExpiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (r *OAuthRepository) CreateLoginCode(codeHash string, userID uuid.UUID, provider string, isNew bool, expiresAt time.Time) error {
+	_, err := r.db.Exec(`
+		INSERT INTO oauth_login_codes (code_hash, user_id, provider, is_new, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, codeHash, userID, provider, isNew, expiresAt, time.Now().UTC())
+	return err
+}
+
+// ConsumeLoginCode marks the code as used and returns it. It returns nil if the
+// code is unknown, expired or was already exchanged.
+func (r *OAuthRepository) ConsumeLoginCode(codeHash string) (*models.OAuthLoginCode, error) {
+	code := &models.OAuthLoginCode{}
+	now := time.Now().UTC()
+	err := r.db.QueryRow(`
+		UPDATE oauth_login_codes SET used_at = $1
+		WHERE code_hash = $2 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id, provider, is_new
+	`, now, codeHash).Scan(&code.UserID, &code.Provider, &code.IsNew)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return code, nil
+}
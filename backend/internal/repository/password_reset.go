@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PasswordResetRepository struct {
+	db *sql.DB
+}
+
+func NewPasswordResetRepository(db *sql.DB) *PasswordResetRepository {
+	return &PasswordResetRepository{db: db}
+}
+
+// Create stores a new reset token and invalidates any earlier unused ones,
+// so only the most recent email link works
+func (r *PasswordResetRepository) Create(userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	_, err = tx.Exec(`
+		UPDATE password_reset_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL
+	`, now, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO password_reset_tokens (id, user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.New(), userID, tokenHash, expiresAt, now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ResetPassword spends the token and, in the same transaction, sets the new
+// password hash and revokes every session of the token's user. Completing a
+// reset proves ownership of the address, so the email is marked verified as
+// well. It returns uuid.Nil, changing nothing, if the token is unknown, expired
+// or was already used.
+func (r *PasswordResetRepository) ResetPassword(tokenHash, passwordHash string) (uuid.UUID, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	var userID uuid.UUID
+	now := time.Now().UTC()
+	err = tx.QueryRow(`
+		UPDATE password_reset_tokens SET used_at = $1
+		WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id
+	`, now, tokenHash).Scan(&userID)
+
+	if err == sql.ErrNoRows {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET password_hash = $1, email_verified = true, verification_token = NULL, verification_token_expires_at = NULL, updated_at = $2 WHERE id = $3
+	`, passwordHash, now, userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL
+	`, now, userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, tx.Commit()
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 
 	"heyspoilme/internal/models"
 )
@@ -17,25 +18,297 @@ func NewPresenceRepository(db *sql.DB) *PresenceRepository {
 	return &PresenceRepository{db: db}
 }
 
-func (r *PresenceRepository) Upsert(userID uuid.UUID, isOnline bool) error {
+// Connect records that the user has connections on the given server and marks
+// them online. It reports whether they were offline until now.
+func (r *PresenceRepository) Connect(userID, nodeID uuid.UUID) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
 	now := time.Now().UTC()
-	_, err := r.db.Exec(`
+	wasOnline, _, err := lockPresence(tx, userID, now)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_presence_nodes (user_id, node_id, connected_at, last_heartbeat_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT (user_id, node_id) DO UPDATE SET last_heartbeat_at = EXCLUDED.last_heartbeat_at
+	`, userID, nodeID, now)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_presence SET is_online = true, last_seen = $1, updated_at = $1 WHERE user_id = $2
+	`, now, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return !wasOnline, tx.Commit()
+}
+
+// Disconnect records that the user's last connection on the given server has
+// closed. They are marked offline only if no other server still has one and an
+// admin hasn't forced them online, and it reports whether that happened.
+func (r *PresenceRepository) Disconnect(userID, nodeID uuid.UUID) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	wasOnline, forced, err := lockPresence(tx, userID, now)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM user_presence_nodes WHERE user_id = $1 AND node_id = $2
+	`, userID, nodeID)
+	if err != nil {
+		return false, err
+	}
+
+	var connected bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM user_presence_nodes WHERE user_id = $1)
+	`, userID).Scan(&connected)
+	if err != nil {
+		return false, err
+	}
+	if connected || forced {
+		return false, tx.Commit()
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_presence SET is_online = false, last_seen = $1, updated_at = $1 WHERE user_id = $2
+	`, now, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return wasOnline, tx.Commit()
+}
+
+// lockPresence creates the user's presence row if needed and locks it, so
+// connects and disconnects from different servers apply one at a time. It
+// returns whether the user is currently online and whether an admin forced it.
+func lockPresence(tx *sql.Tx, userID uuid.UUID, now time.Time) (bool, bool, error) {
+	_, err := tx.Exec(`
 		INSERT INTO user_presence (user_id, is_online, last_seen, updated_at)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (user_id) DO UPDATE SET
-			is_online = $2,
-			last_seen = CASE WHEN $2 = false THEN $3 ELSE user_presence.last_seen END,
-			updated_at = $4
-	`, userID, isOnline, now, now)
+		VALUES ($1, false, $2, $2)
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID, now)
+	if err != nil {
+		return false, false, err
+	}
+
+	var isOnline, forced bool
+	err = tx.QueryRow(`
+		SELECT is_online, forced FROM user_presence WHERE user_id = $1 FOR UPDATE
+	`, userID).Scan(&isOnline, &forced)
+	return isOnline, forced, err
+}
+
+// Heartbeat records that the user is still connected to the given server and
+// moves their last_seen forward. It returns false if the server had no record
+// of the user, for instance because it was swept while the database was
+// unreachable.
+func (r *PresenceRepository) Heartbeat(userID, nodeID uuid.UUID) (bool, error) {
+	now := time.Now().UTC()
+	result, err := r.db.Exec(`
+		UPDATE user_presence_nodes SET last_heartbeat_at = $1 WHERE user_id = $2 AND node_id = $3
+	`, now, userID, nodeID)
+	if err != nil {
+		return false, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return false, nil
+	}
+
+	_, err = r.db.Exec(`
+		UPDATE user_presence SET last_seen = $1, updated_at = $1 WHERE user_id = $2
+	`, now, userID)
+	return true, err
+}
+
+// SaveConnection records one of the user's open connections on the given
+// server, or refreshes its heartbeat if it is already recorded
+func (r *PresenceRepository) SaveConnection(userID, nodeID uuid.UUID, conn models.WSConnection) error {
+	_, err := r.db.Exec(`
+		INSERT INTO user_connections (id, user_id, session_id, node_id, remote_addr, connected_at, last_heartbeat_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET last_heartbeat_at = EXCLUDED.last_heartbeat_at
+	`, conn.ID, userID, conn.SessionID, nodeID, conn.RemoteAddr, conn.ConnectedAt, time.Now().UTC())
 	return err
 }
 
-func (r *PresenceRepository) SetOnline(userID uuid.UUID) error {
-	return r.Upsert(userID, true)
+// DeleteConnection forgets a connection that has closed
+func (r *PresenceRepository) DeleteConnection(connectionID uuid.UUID) error {
+	_, err := r.db.Exec(`DELETE FROM user_connections WHERE id = $1`, connectionID)
+	return err
 }
 
-func (r *PresenceRepository) SetOffline(userID uuid.UUID) error {
-	return r.Upsert(userID, false)
+// ListConnections returns the user's open connections on every server, oldest
+// first
+func (r *PresenceRepository) ListConnections(userID uuid.UUID) ([]models.WSConnection, error) {
+	rows, err := r.db.Query(`
+		SELECT id, session_id, remote_addr, connected_at
+		FROM user_connections WHERE user_id = $1
+		ORDER BY connected_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var connections []models.WSConnection
+	for rows.Next() {
+		var conn models.WSConnection
+		if err := rows.Scan(&conn.ID, &conn.SessionID, &conn.RemoteAddr, &conn.ConnectedAt); err != nil {
+			return nil, err
+		}
+		connections = append(connections, conn)
+	}
+	return connections, rows.Err()
+}
+
+// presenceSweepBatch caps how many users one sweep transaction handles
+const presenceSweepBatch = 500
+
+// SweepStale drops connection records whose last heartbeat is older than cutoff,
+// which is what a crashed server leaves behind, and marks their users offline
+// unless another server still has them. last_seen keeps the time of the last
+// heartbeat. It returns the users who went offline.
+func (r *PresenceRepository) SweepStale(cutoff time.Time) ([]uuid.UUID, error) {
+	_, err := r.db.Exec(`DELETE FROM user_connections WHERE last_heartbeat_at < $1`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	var offline []uuid.UUID
+	for {
+		stale, err := r.collectUserIDs(`
+			SELECT DISTINCT user_id FROM user_presence_nodes WHERE last_heartbeat_at < $1 LIMIT $2
+		`, cutoff, presenceSweepBatch)
+		if err != nil {
+			return offline, err
+		}
+		if len(stale) == 0 {
+			return offline, nil
+		}
+
+		swept, err := r.markOffline(stale, cutoff)
+		if err != nil {
+			return offline, err
+		}
+		offline = append(offline, swept...)
+
+		if len(stale) < presenceSweepBatch {
+			return offline, nil
+		}
+	}
+}
+
+// Reconcile marks offline every user flagged online without any connection
+// record, left over from servers that stopped before connections were tracked
+// per server. Admin-forced users are kept. It returns the users it changed.
+func (r *PresenceRepository) Reconcile() ([]uuid.UUID, error) {
+	candidates, err := r.collectUserIDs(`
+		SELECT up.user_id FROM user_presence up
+		WHERE up.is_online = true AND up.forced = false
+			AND NOT EXISTS(SELECT 1 FROM user_presence_nodes upn WHERE upn.user_id = up.user_id)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	var offline []uuid.UUID
+	for start := 0; start < len(candidates); start += presenceSweepBatch {
+		end := start + presenceSweepBatch
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+		// No record is older than the zero time, so only the check for
+		// remaining connections applies
+		changed, err := r.markOffline(candidates[start:end], time.Time{})
+		if err != nil {
+			return offline, err
+		}
+		offline = append(offline, changed...)
+	}
+	return offline, nil
+}
+
+// markOffline deletes the users' connection records older than cutoff, then
+// marks offline those with no record left who aren't forced online. The
+// presence rows are locked first, in the same order Connect and Disconnect
+// take them, so a user connecting at the same moment is never swept.
+func (r *PresenceRepository) markOffline(userIDs []uuid.UUID, cutoff time.Time) ([]uuid.UUID, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		SELECT 1 FROM user_presence WHERE user_id = ANY($1::uuid[]) ORDER BY user_id FOR UPDATE
+	`, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM user_presence_nodes WHERE user_id = ANY($1::uuid[]) AND last_heartbeat_at < $2
+	`, pq.Array(userIDs), cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		UPDATE user_presence up SET is_online = false, updated_at = $2
+		WHERE up.user_id = ANY($1::uuid[]) AND up.is_online = true AND up.forced = false
+			AND NOT EXISTS(SELECT 1 FROM user_presence_nodes upn WHERE upn.user_id = up.user_id)
+		RETURNING up.user_id
+	`, pq.Array(userIDs), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	offline, err := scanUserIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return offline, tx.Commit()
+}
+
+func (r *PresenceRepository) collectUserIDs(query string, args ...interface{}) ([]uuid.UUID, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanUserIDs(rows)
+}
+
+// scanUserIDs reads a single column of user IDs and closes rows
+func scanUserIDs(rows *sql.Rows) ([]uuid.UUID, error) {
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
 func (r *PresenceRepository) GetPresence(userID uuid.UUID) (*models.UserPresence, error) {
@@ -79,7 +352,67 @@ func (r *PresenceRepository) GetOnlineUsers(userIDs []uuid.UUID) (map[uuid.UUID]
 	return result, nil
 }
 
-func (r *PresenceRepository) Delete(userID uuid.UUID) error {
-	_, err := r.db.Exec(`DELETE FROM user_presence WHERE user_id = $1`, userID)
-	return err
+// ConnectedUsers returns which of the given users have a WebSocket connection
+// open on any server
+func (r *PresenceRepository) ConnectedUsers(userIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	result := make(map[uuid.UUID]bool)
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+
+	rows, err := r.db.Query(`
+		SELECT DISTINCT user_id FROM user_presence_nodes WHERE user_id = ANY($1::uuid[])
+	`, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID uuid.UUID
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		result[userID] = true
+	}
+
+	return result, rows.Err()
+}
+
+// ConnectedAudience returns the users who may follow the user's presence
+// without subscribing, conversation partners and mutual likes, limited to those
+// connected right now
+func (r *PresenceRepository) ConnectedAudience(userID uuid.UUID) ([]uuid.UUID, error) {
+	return r.collectUserIDs(`
+		WITH related AS (
+			SELECT cp2.user_id
+			FROM conversation_participants cp1
+			JOIN conversation_participants cp2 ON cp2.conversation_id = cp1.conversation_id AND cp2.user_id <> cp1.user_id
+			WHERE cp1.user_id = $1
+			UNION
+			SELECT l1.liked_id
+			FROM likes l1
+			JOIN likes l2 ON l2.liker_id = l1.liked_id AND l2.liked_id = l1.liker_id
+			WHERE l1.liker_id = $1
+		)
+		SELECT r.user_id FROM related r
+		WHERE EXISTS(SELECT 1 FROM user_presence_nodes upn WHERE upn.user_id = r.user_id)
+	`, userID)
+}
+
+// VisibleUsers returns which of the candidates the viewer may follow the
+// presence of: members with an active profile the viewer can open, leaving out
+// the viewer and anyone in invisible mode
+func (r *PresenceRepository) VisibleUsers(viewerID uuid.UUID, candidates []uuid.UUID) ([]uuid.UUID, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	return r.collectUserIDs(`
+		SELECT u.id
+		FROM users u
+		JOIN profiles p ON p.user_id = u.id
+		WHERE u.id = ANY($2::uuid[]) AND u.id <> $1
+		  AND u.status = 'active' AND u.hide_online_status = false
+	`, viewerID, pq.Array(candidates))
 }
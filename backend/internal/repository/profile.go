@@ -208,34 +208,6 @@ func (r *ProfileRepository) DeleteImage(imageID, userID uuid.UUID) error {
 	return err
 }
 
-func (r *ProfileRepository) GetImageS3Keys(userID uuid.UUID) ([]string, error) {
-	rows, err := r.db.Query(`SELECT s3_key FROM profile_images WHERE user_id = $1`, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var keys []string
-	for rows.Next() {
-		var key string
-		if err := rows.Scan(&key); err != nil {
-			return nil, err
-		}
-		keys = append(keys, key)
-	}
-	return keys, nil
-}
-
-func (r *ProfileRepository) DeleteAllImages(userID uuid.UUID) error {
-	_, err := r.db.Exec(`DELETE FROM profile_images WHERE user_id = $1`, userID)
-	return err
-}
-
-func (r *ProfileRepository) Delete(userID uuid.UUID) error {
-	_, err := r.db.Exec(`DELETE FROM profiles WHERE user_id = $1`, userID)
-	return err
-}
-
 // CreateFakeProfile creates a profile marked as fake (for demo/seed purposes)
 func (r *ProfileRepository) CreateFakeProfile(userID uuid.UUID, displayName string, gender models.Gender, age int, bio, salaryRange, city, state string, lat, lng float64) (*models.Profile, error) {
 	profile := &models.Profile{
@@ -280,7 +252,9 @@ func (r *ProfileRepository) ListProfiles(requestingUserID uuid.UUID, userLat, us
 	r.db.QueryRow(`SELECT gender FROM profiles WHERE user_id = $1`, requestingUserID).Scan(&requestingUserGender)
 	isFemaleViewingMales := requestingUserGender == models.GenderFemale && query.Gender == "male"
 
-	whereClauses := []string{"p.user_id != $1", "p.is_complete = true"}
+	// Paused accounts and accounts pending deletion stay out of browse
+	whereClauses := []string{"p.user_id != $1", "p.is_complete = true",
+		"EXISTS(SELECT 1 FROM users au WHERE au.id = p.user_id AND au.status = 'active')"}
 	args := []interface{}{requestingUserID}
 	argIndex := 2
 
@@ -310,7 +284,9 @@ func (r *ProfileRepository) ListProfiles(requestingUserID uuid.UUID, userLat, us
 		argIndex++
 	}
 	if query.OnlineOnly {
-		whereClauses = append(whereClauses, "EXISTS(SELECT 1 FROM user_presence up2 WHERE up2.user_id = p.user_id AND up2.is_online = true)")
+		// Members in invisible mode never show up as online
+		whereClauses = append(whereClauses, `EXISTS(SELECT 1 FROM user_presence up2 JOIN users u2 ON u2.id = up2.user_id
+			WHERE up2.user_id = p.user_id AND up2.is_online = true AND u2.hide_online_status = false)`)
 	}
 
 	whereClause := strings.Join(whereClauses, " AND ")
@@ -371,6 +347,12 @@ func (r *ProfileRepository) ListProfiles(requestingUserID uuid.UUID, userLat, us
 		distancePenaltyCalc = "0"
 	}
 
+	// Invisible members rank as if they were offline, so the order doesn't give them away.
+	// Likewise recent activity only counts for members who show their exact last seen.
+	visibleOnlineCalc := "(COALESCE(up.is_online, false) AND NOT COALESCE(u.hide_online_status, false))"
+	visibleLastSeenCalc := `CASE WHEN NOT COALESCE(u.hide_online_status, false)
+		AND COALESCE(u.last_seen_visibility, 'exact') = 'exact' THEN up.last_seen END`
+
 	// Base scoring formula
 	var finalScoreCalc string
 	if isFemaleViewingMales {
@@ -384,26 +366,26 @@ func (r *ProfileRepository) ListProfiles(requestingUserID uuid.UUID, userLat, us
 				WHEN 'low' THEN 100
 				ELSE 0 END +
 			CASE WHEN p.is_verified THEN 50 ELSE 0 END +
-			CASE WHEN COALESCE(up.is_online, false) THEN 30
-				 WHEN up.last_seen > NOW() - INTERVAL '1 hour' THEN 20
-				 WHEN up.last_seen > NOW() - INTERVAL '1 day' THEN 10
+			CASE WHEN %s THEN 30
+				 WHEN %s > NOW() - INTERVAL '1 hour' THEN 20
+				 WHEN %s > NOW() - INTERVAL '1 day' THEN 10
 				 ELSE 0 END +
 			CASE WHEN EXISTS(SELECT 1 FROM likes WHERE liker_id = p.user_id AND liked_id = $1) THEN 40
 				 ELSE 0 END +
 			(p.profile_score * 0.5) +
 			%s
-		`, distancePenaltyCalc)
+		`, visibleOnlineCalc, visibleLastSeenCalc, visibleLastSeenCalc, distancePenaltyCalc)
 	} else {
 		// Default ranking (male viewing females, or any other case)
 		finalScoreCalc = fmt.Sprintf(`
 			p.profile_score +
-			CASE WHEN COALESCE(up.is_online, false) THEN 15
-				 WHEN up.last_seen > NOW() - INTERVAL '1 hour' THEN 5
+			CASE WHEN %s THEN 15
+				 WHEN %s > NOW() - INTERVAL '1 hour' THEN 5
 				 ELSE 0 END +
 			CASE WHEN EXISTS(SELECT 1 FROM likes WHERE liker_id = p.user_id AND liked_id = $1) THEN 30
 				 ELSE 0 END +
 			%s
-		`, distancePenaltyCalc)
+		`, visibleOnlineCalc, visibleLastSeenCalc, distancePenaltyCalc)
 	}
 
 	mainQuery := fmt.Sprintf(`
@@ -412,6 +394,7 @@ func (r *ProfileRepository) ListProfiles(requestingUserID uuid.UUID, userLat, us
 			   %s as distance,
 			   COALESCE(up.is_online, false) as is_online,
 			   up.last_seen,
+			   COALESCE(u.hide_online_status, false), COALESCE(u.last_seen_visibility, 'exact'),
 			   EXISTS(SELECT 1 FROM likes WHERE liker_id = $1 AND liked_id = p.user_id) as is_liked,
 			   EXISTS(SELECT 1 FROM likes WHERE liker_id = p.user_id AND liked_id = $1) as has_liked_me,
 			   COALESCE(u.wealth_status, 'none') as wealth_status,
@@ -430,15 +413,18 @@ func (r *ProfileRepository) ListProfiles(requestingUserID uuid.UUID, userLat, us
 	}
 	defer rows.Close()
 
+	now := time.Now().UTC()
 	var profiles []models.ProfileWithImages
 	for rows.Next() {
 		var p models.ProfileWithImages
 		var distance sql.NullFloat64
 		var lastSeen sql.NullTime
+		var hideOnline bool
+		var lastSeenVisibility models.LastSeenVisibility
 		var finalScore float64 // scanned but not stored - used only for ordering
 		err := rows.Scan(&p.ID, &p.UserID, &p.DisplayName, &p.Gender, &p.Age, &p.Bio, &p.SalaryRange,
 			&p.City, &p.State, &p.Latitude, &p.Longitude, &p.IsComplete, &p.IsVerified, &p.ProfileScore, &p.CreatedAt, &p.UpdatedAt,
-			&distance, &p.IsOnline, &lastSeen, &p.IsLiked, &p.HasLikedMe, &p.WealthStatus, &finalScore)
+			&distance, &p.IsOnline, &lastSeen, &hideOnline, &lastSeenVisibility, &p.IsLiked, &p.HasLikedMe, &p.WealthStatus, &finalScore)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -448,6 +434,7 @@ func (r *ProfileRepository) ListProfiles(requestingUserID uuid.UUID, userLat, us
 		if lastSeen.Valid {
 			p.LastSeen = &lastSeen.Time
 		}
+		p.ApplyPresencePrivacy(hideOnline, lastSeenVisibility, now)
 
 		images, _ := r.GetImages(p.UserID)
 		p.Images = images
@@ -471,16 +458,22 @@ func (r *ProfileRepository) GetProfileWithDetails(profileUserID, requestingUserI
 	images, _ := r.GetImages(profileUserID)
 	result.Images = images
 
-	var isOnline bool
+	var isOnline, hideOnline bool
 	var lastSeen sql.NullTime
+	var lastSeenVisibility models.LastSeenVisibility
 	r.db.QueryRow(`
-		SELECT COALESCE(is_online, false), last_seen 
-		FROM user_presence WHERE user_id = $1
-	`, profileUserID).Scan(&isOnline, &lastSeen)
+		SELECT COALESCE(up.is_online, false), up.last_seen, u.hide_online_status, u.last_seen_visibility
+		FROM users u LEFT JOIN user_presence up ON up.user_id = u.id
+		WHERE u.id = $1
+	`, profileUserID).Scan(&isOnline, &lastSeen, &hideOnline, &lastSeenVisibility)
 	result.IsOnline = isOnline
 	if lastSeen.Valid {
 		result.LastSeen = &lastSeen.Time
 	}
+	// Members always see their own status as it is
+	if profileUserID != requestingUserID {
+		result.ApplyPresencePrivacy(hideOnline, lastSeenVisibility, time.Now().UTC())
+	}
 
 	var isLiked bool
 	r.db.QueryRow(`
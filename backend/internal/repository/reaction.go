@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"heyspoilme/internal/models"
+)
+
+type ReactionRepository struct {
+	db *sql.DB
+}
+
+func NewReactionRepository(db *sql.DB) *ReactionRepository {
+	return &ReactionRepository{db: db}
+}
+
+// Add records a reaction and marks the message changed for sync. Returns false
+// if the user had already reacted to the message with this emoji.
+func (r *ReactionRepository) Add(messageID, userID uuid.UUID, emoji string) (bool, error) {
+	result, err := r.db.Exec(`
+		WITH added AS (
+			INSERT INTO message_reactions (message_id, user_id, emoji, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING
+			RETURNING message_id
+		)
+		UPDATE messages SET updated_at = $4 WHERE id IN (SELECT message_id FROM added)
+	`, messageID, userID, emoji, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Remove deletes a reaction and marks the message changed for sync. Returns
+// false if there was nothing to remove.
+func (r *ReactionRepository) Remove(messageID, userID uuid.UUID, emoji string) (bool, error) {
+	result, err := r.db.Exec(`
+		WITH removed AS (
+			DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+			RETURNING message_id
+		)
+		UPDATE messages SET updated_at = $4 WHERE id IN (SELECT message_id FROM removed)
+	`, messageID, userID, emoji, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// CountsForMessages aggregates the reactions on each message, in the order
+// each emoji was first used. viewerID marks the emojis the viewer reacted with.
+func (r *ReactionRepository) CountsForMessages(messageIDs []uuid.UUID, viewerID uuid.UUID) (map[uuid.UUID][]models.ReactionCount, error) {
+	counts := make(map[uuid.UUID][]models.ReactionCount)
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+
+	ids := make([]string, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := r.db.Query(`
+		SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id = $2)
+		FROM message_reactions
+		WHERE message_id = ANY($1::uuid[])
+		GROUP BY message_id, emoji
+		ORDER BY MIN(created_at) ASC
+	`, pq.Array(ids), viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID uuid.UUID
+		var count models.ReactionCount
+		if err := rows.Scan(&messageID, &count.Emoji, &count.Count, &count.Reacted); err != nil {
+			return nil, err
+		}
+		counts[messageID] = append(counts[messageID], count)
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+)
+
+type S3CleanupRepository struct {
+	db *sql.DB
+}
+
+func NewS3CleanupRepository(db *sql.DB) *S3CleanupRepository {
+	return &S3CleanupRepository{db: db}
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// enqueueS3Keys queues objects for deletion. Keys already in the queue are
+// skipped, so enqueueing the same object twice is harmless.
+func enqueueS3Keys(db execer, keys []string, reason string) error {
+	now := time.Now().UTC()
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		_, err := db.Exec(`
+			INSERT INTO s3_cleanup_queue (id, s3_key, reason, next_attempt_at, created_at)
+			VALUES ($1, $2, $3, $4, $4)
+			ON CONFLICT (s3_key) DO NOTHING
+		`, uuid.New(), key, reason, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *S3CleanupRepository) Enqueue(keys []string, reason string) error {
+	return enqueueS3Keys(r.db, keys, reason)
+}
+
+// ClaimDue returns up to limit items that are due for an attempt and pushes their
+// next attempt back by lease, so another worker won't pick them up meanwhile
+func (r *S3CleanupRepository) ClaimDue(limit int, lease time.Duration) ([]models.S3CleanupItem, error) {
+	now := time.Now().UTC()
+	rows, err := r.db.Query(`
+		UPDATE s3_cleanup_queue SET next_attempt_at = $1
+		WHERE id IN (
+			SELECT id FROM s3_cleanup_queue
+			WHERE next_attempt_at <= $2
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, s3_key, reason, attempts
+	`, now.Add(lease), now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.S3CleanupItem
+	for rows.Next() {
+		var item models.S3CleanupItem
+		if err := rows.Scan(&item.ID, &item.S3Key, &item.Reason, &item.Attempts); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// Complete removes an item whose object was deleted
+func (r *S3CleanupRepository) Complete(id uuid.UUID) error {
+	_, err := r.db.Exec(`DELETE FROM s3_cleanup_queue WHERE id = $1`, id)
+	return err
+}
+
+// Fail records a failed attempt and when to try again
+func (r *S3CleanupRepository) Fail(id uuid.UUID, reason string, nextAttemptAt time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE s3_cleanup_queue SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2 WHERE id = $3
+	`, reason, nextAttemptAt, id)
+	return err
+}
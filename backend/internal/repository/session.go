@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+)
+
+type SessionRepository struct {
+	db *sql.DB
+}
+
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func (r *SessionRepository) Create(userID uuid.UUID, refreshTokenHash string, meta models.SessionMetadata, expiresAt time.Time) (*models.Session, error) {
+	session := &models.Session{
+		ID:               uuid.New(),
+		UserID:           userID,
+		RefreshTokenHash: refreshTokenHash,
+		UserAgent:        meta.UserAgent,
+		IPAddress:        meta.IPAddress,
+		AuthMethod:       meta.AuthMethod,
+		ExpiresAt:        expiresAt,
+		CreatedAt:        time.Now().UTC(),
+		LastUsedAt:       time.Now().UTC(),
+	}
+
+	_, err := r.db.Exec(`
+		INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip_address, auth_method, expires_at, created_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, session.ID, session.UserID, session.RefreshTokenHash, session.UserAgent, session.IPAddress, session.AuthMethod,
+		session.ExpiresAt, session.CreatedAt, session.LastUsedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (r *SessionRepository) FindByID(id uuid.UUID) (*models.Session, error) {
+	return r.findOne(`WHERE id = $1`, id)
+}
+
+func (r *SessionRepository) FindByRefreshTokenHash(hash string) (*models.Session, error) {
+	return r.findOne(`WHERE refresh_token_hash = $1`, hash)
+}
+
+// FindByPreviousRefreshTokenHash finds the session a rotated-out refresh token belonged to
+func (r *SessionRepository) FindByPreviousRefreshTokenHash(hash string) (*models.Session, error) {
+	return r.findOne(`WHERE previous_refresh_token_hash = $1`, hash)
+}
+
+const sessionColumns = `id, user_id, refresh_token_hash, user_agent, ip_address, auth_method, expires_at, revoked_at, created_at, last_used_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	session := &models.Session{}
+	var revokedAt sql.NullTime
+	err := row.Scan(&session.ID, &session.UserID, &session.RefreshTokenHash, &session.UserAgent, &session.IPAddress,
+		&session.AuthMethod, &session.ExpiresAt, &revokedAt, &session.CreatedAt, &session.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+
+	return session, nil
+}
+
+func (r *SessionRepository) findOne(where string, args ...interface{}) (*models.Session, error) {
+	session, err := scanSession(r.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions `+where, args...))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// ListActiveForUser returns the user's signed-in sessions, most recently used first
+func (r *SessionRepository) ListActiveForUser(userID uuid.UUID) ([]models.Session, error) {
+	rows, err := r.db.Query(`
+		SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_used_at DESC
+	`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Rotate swaps the session's refresh token. It only succeeds if the old token is
+// still current, so two concurrent refreshes with the same token can't both win.
+func (r *SessionRepository) Rotate(sessionID uuid.UUID, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE sessions
+		SET refresh_token_hash = $1, previous_refresh_token_hash = $2, expires_at = $3, last_used_at = $4
+		WHERE id = $5 AND refresh_token_hash = $2 AND revoked_at IS NULL
+	`, newHash, oldHash, expiresAt, time.Now().UTC(), sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// IsActive reports whether the session exists, has not been revoked and has not expired
+func (r *SessionRepository) IsActive(sessionID uuid.UUID) (bool, error) {
+	var active bool
+	err := r.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > $2)
+	`, sessionID, time.Now().UTC()).Scan(&active)
+	return active, err
+}
+
+// Touch records that the session was just used. Writes are skipped if the session
+// was already marked as used within the last minute.
+func (r *SessionRepository) Touch(sessionID uuid.UUID) error {
+	now := time.Now().UTC()
+	_, err := r.db.Exec(`
+		UPDATE sessions SET last_used_at = $1 WHERE id = $2 AND last_used_at < $3
+	`, now, sessionID, now.Add(-time.Minute))
+	return err
+}
+
+// RevokeForUser revokes a session only if it belongs to the user. It reports
+// whether an active session was revoked.
+func (r *SessionRepository) RevokeForUser(sessionID, userID uuid.UUID) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+	`, time.Now().UTC(), sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// RevokeAllForUser revokes every active session of the user (logout from all devices)
+func (r *SessionRepository) RevokeAllForUser(userID uuid.UUID) error {
+	_, err := r.db.Exec(`
+		UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL
+	`, time.Now().UTC(), userID)
+	return err
+}
@@ -0,0 +1,241 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+)
+
+// TwoFactorRepository stores TOTP enrollments, recovery codes and signin challenges.
+// Methods that depend on the current time take it as an argument so callers
+// control the clock.
+type TwoFactorRepository struct {
+	db *sql.DB
+}
+
+func NewTwoFactorRepository(db *sql.DB) *TwoFactorRepository {
+	return &TwoFactorRepository{db: db}
+}
+
+func (r *TwoFactorRepository) Find(userID uuid.UUID) (*models.TwoFactor, error) {
+	tf := &models.TwoFactor{}
+	var enabledAt sql.NullTime
+	err := r.db.QueryRow(`
+		SELECT user_id, secret, enabled_at, last_used_step, created_at
+		FROM user_two_factor WHERE user_id = $1
+	`, userID).Scan(&tf.UserID, &tf.Secret, &enabledAt, &tf.LastUsedStep, &tf.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if enabledAt.Valid {
+		tf.EnabledAt = &enabledAt.Time
+	}
+
+	return tf, nil
+}
+
+// SavePending stores a new secret awaiting confirmation. An already enabled
+// enrollment is left untouched.
+func (r *TwoFactorRepository) SavePending(userID uuid.UUID, secret string, now time.Time) error {
+	_, err := r.db.Exec(`
+		INSERT INTO user_two_factor (user_id, secret, last_used_step, created_at, updated_at)
+		VALUES ($1, $2, 0, $3, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, updated_at = EXCLUDED.updated_at
+		WHERE user_two_factor.enabled_at IS NULL
+	`, userID, secret, now)
+	return err
+}
+
+// Enable confirms a pending enrollment and stores its first set of recovery codes
+func (r *TwoFactorRepository) Enable(userID uuid.UUID, step int64, recoveryCodeHashes []string, now time.Time) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE user_two_factor SET enabled_at = $1, last_used_step = $2, updated_at = $1
+		WHERE user_id = $3 AND enabled_at IS NULL
+	`, now, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected != 1 {
+		return false, nil
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodeHashes, now); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// UseStep records that the code for step was used. It fails if that step (or a
+// later one) was already used, so a code can't be replayed.
+func (r *TwoFactorRepository) UseStep(userID uuid.UUID, step int64, now time.Time) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE user_two_factor SET last_used_step = $1, updated_at = $2
+		WHERE user_id = $3 AND enabled_at IS NOT NULL AND last_used_step < $1
+	`, step, now, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// Disable removes the enrollment along with its recovery codes and open challenges
+func (r *TwoFactorRepository) Disable(userID uuid.UUID) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		`DELETE FROM two_factor_challenges WHERE user_id = $1`,
+		`DELETE FROM two_factor_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM user_two_factor WHERE user_id = $1`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes discards the user's old recovery codes and stores new ones
+func (r *TwoFactorRepository) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string, now time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID uuid.UUID, codeHashes []string, now time.Time) error {
+	if _, err := tx.Exec(`DELETE FROM two_factor_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(`
+			INSERT INTO two_factor_recovery_codes (id, user_id, code_hash, created_at)
+			VALUES ($1, $2, $3, $4)
+		`, uuid.New(), userID, hash, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks a recovery code as used. It reports false if the code
+// doesn't belong to the user or was already used.
+func (r *TwoFactorRepository) UseRecoveryCode(userID uuid.UUID, codeHash string, now time.Time) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE two_factor_recovery_codes SET used_at = $1
+		WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL
+	`, now, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+func (r *TwoFactorRepository) CreateChallenge(userID uuid.UUID, method models.AuthMethod, tokenHash string, expiresAt, now time.Time) error {
+	_, err := r.db.Exec(`
+		INSERT INTO two_factor_challenges (id, user_id, auth_method, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, uuid.New(), userID, method, tokenHash, expiresAt, now)
+	return err
+}
+
+// FindActiveChallenge returns the challenge if it is unused and not yet expired
+func (r *TwoFactorRepository) FindActiveChallenge(tokenHash string, now time.Time) (*models.TwoFactorChallenge, error) {
+	challenge := &models.TwoFactorChallenge{}
+	err := r.db.QueryRow(`
+		SELECT id, user_id, auth_method, attempts, expires_at
+		FROM two_factor_challenges
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+	`, tokenHash, now).Scan(&challenge.ID, &challenge.UserID, &challenge.AuthMethod, &challenge.Attempts, &challenge.ExpiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// UseChallengeAttempt counts an attempt at the challenge, as long as fewer than
+// maxAttempts have been made. The check and the increment are one statement so
+// concurrent guesses can't get past the limit.
+func (r *TwoFactorRepository) UseChallengeAttempt(challengeID uuid.UUID, maxAttempts int) (bool, error) {
+	var attempts int
+	err := r.db.QueryRow(`
+		UPDATE two_factor_challenges SET attempts = attempts + 1
+		WHERE id = $1 AND attempts < $2
+		RETURNING attempts
+	`, challengeID, maxAttempts).Scan(&attempts)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompleteChallenge marks the challenge as used. Only the first caller wins.
+func (r *TwoFactorRepository) CompleteChallenge(challengeID uuid.UUID, now time.Time) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE two_factor_challenges SET used_at = $1 WHERE id = $2 AND used_at IS NULL
+	`, now, challengeID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
@@ -2,13 +2,19 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 
 	"heyspoilme/internal/models"
 )
 
+// ErrPreviousEmailTaken is returned when a confirmed email change can't be
+// reverted because another account now uses the old address
+var ErrPreviousEmailTaken = errors.New("previous email is now used by another account")
+
 type UserRepository struct {
 	db *sql.DB
 }
@@ -17,20 +23,21 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) Create(googleID, email string, emailVerified bool) (*models.User, error) {
+// Create adds a user who signed up through an identity provider
+func (r *UserRepository) Create(email string, emailVerified bool) (*models.User, error) {
 	user := &models.User{
 		ID:            uuid.New(),
-		GoogleID:      sql.NullString{String: googleID, Valid: googleID != ""},
 		Email:         email,
 		EmailVerified: emailVerified,
+		Status:        models.AccountStatusActive,
 		CreatedAt:     time.Now().UTC(),
 		UpdatedAt:     time.Now().UTC(),
 	}
 
 	_, err := r.db.Exec(`
-		INSERT INTO users (id, google_id, email, email_verified, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, user.ID, user.GoogleID, user.Email, user.EmailVerified, user.CreatedAt, user.UpdatedAt)
+		INSERT INTO users (id, email, email_verified, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, user.ID, user.Email, user.EmailVerified, user.CreatedAt, user.UpdatedAt)
 
 	if err != nil {
 		return nil, err
@@ -47,6 +54,7 @@ func (r *UserRepository) CreateWithPassword(email, passwordHash, verificationTok
 		EmailVerified:              false,
 		VerificationToken:          sql.NullString{String: verificationToken, Valid: true},
 		VerificationTokenExpiresAt: sql.NullTime{Time: tokenExpiresAt, Valid: true},
+		Status:                     models.AccountStatusActive,
 		CreatedAt:                  time.Now().UTC(),
 		UpdatedAt:                  time.Now().UTC(),
 	}
@@ -63,35 +71,15 @@ func (r *UserRepository) CreateWithPassword(email, passwordHash, verificationTok
 	return user, nil
 }
 
-func (r *UserRepository) FindByGoogleID(googleID string) (*models.User, error) {
-	user := &models.User{}
-	err := r.db.QueryRow(`
-		SELECT id, google_id, email, password_hash, email_verified, verification_token, verification_token_expires_at, 
-		       COALESCE(wealth_status, 'none'), wealth_status_expires_at, created_at, updated_at
-		FROM users WHERE google_id = $1
-	`, googleID).Scan(&user.ID, &user.GoogleID, &user.Email, &user.PasswordHash, &user.EmailVerified, 
-		&user.VerificationToken, &user.VerificationTokenExpiresAt, &user.WealthStatus, &user.WealthStatusExpiresAt,
-		&user.CreatedAt, &user.UpdatedAt)
-
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	return user, nil
-}
-
 func (r *UserRepository) FindByID(id uuid.UUID) (*models.User, error) {
 	user := &models.User{}
 	err := r.db.QueryRow(`
 		SELECT id, google_id, email, password_hash, email_verified, verification_token, verification_token_expires_at,
-		       COALESCE(wealth_status, 'none'), wealth_status_expires_at, created_at, updated_at
+		       COALESCE(wealth_status, 'none'), wealth_status_expires_at, status, deletion_scheduled_at, created_at, updated_at
 		FROM users WHERE id = $1
 	`, id).Scan(&user.ID, &user.GoogleID, &user.Email, &user.PasswordHash, &user.EmailVerified, 
 		&user.VerificationToken, &user.VerificationTokenExpiresAt, &user.WealthStatus, &user.WealthStatusExpiresAt,
-		&user.CreatedAt, &user.UpdatedAt)
+		&user.Status, &user.DeletionScheduledAt, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -107,11 +95,11 @@ func (r *UserRepository) FindByEmail(email string) (*models.User, error) {
 	user := &models.User{}
 	err := r.db.QueryRow(`
 		SELECT id, google_id, email, password_hash, email_verified, verification_token, verification_token_expires_at,
-		       COALESCE(wealth_status, 'none'), wealth_status_expires_at, created_at, updated_at
+		       COALESCE(wealth_status, 'none'), wealth_status_expires_at, status, deletion_scheduled_at, created_at, updated_at
 		FROM users WHERE email = $1
 	`, email).Scan(&user.ID, &user.GoogleID, &user.Email, &user.PasswordHash, &user.EmailVerified, 
 		&user.VerificationToken, &user.VerificationTokenExpiresAt, &user.WealthStatus, &user.WealthStatusExpiresAt,
-		&user.CreatedAt, &user.UpdatedAt)
+		&user.Status, &user.DeletionScheduledAt, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -123,61 +111,15 @@ func (r *UserRepository) FindByEmail(email string) (*models.User, error) {
 	return user, nil
 }
 
-func (r *UserRepository) FindOrCreate(googleID, email string) (*models.User, bool, error) {
-	user, err := r.FindByGoogleID(googleID)
-	if err != nil {
-		return nil, false, err
-	}
-
-	if user != nil {
-		// Ensure Google users are always verified
-		if !user.EmailVerified {
-			_, err = r.db.Exec(`UPDATE users SET email_verified = true, updated_at = $1 WHERE id = $2`,
-				time.Now().UTC(), user.ID)
-			if err != nil {
-				return nil, false, err
-			}
-			user.EmailVerified = true
-		}
-		return user, false, nil
-	}
-
-	// Check if user exists with this email (might have signed up with password first)
-	user, err = r.FindByEmail(email)
-	if err != nil {
-		return nil, false, err
-	}
-
-	if user != nil {
-		// Link Google account to existing user and mark as verified
-		_, err = r.db.Exec(`UPDATE users SET google_id = $1, email_verified = true, verification_token = NULL, verification_token_expires_at = NULL, updated_at = $2 WHERE id = $3`,
-			googleID, time.Now().UTC(), user.ID)
-		if err != nil {
-			return nil, false, err
-		}
-		user.GoogleID = sql.NullString{String: googleID, Valid: true}
-		user.EmailVerified = true
-		return user, false, nil
-	}
-
-	// Create new user with Google - automatically verified
-	user, err = r.Create(googleID, email, true)
-	if err != nil {
-		return nil, false, err
-	}
-
-	return user, true, nil
-}
-
 func (r *UserRepository) FindByVerificationToken(token string) (*models.User, error) {
 	user := &models.User{}
 	err := r.db.QueryRow(`
 		SELECT id, google_id, email, password_hash, email_verified, verification_token, verification_token_expires_at,
-		       COALESCE(wealth_status, 'none'), wealth_status_expires_at, created_at, updated_at
+		       COALESCE(wealth_status, 'none'), wealth_status_expires_at, status, deletion_scheduled_at, created_at, updated_at
 		FROM users WHERE verification_token = $1
 	`, token).Scan(&user.ID, &user.GoogleID, &user.Email, &user.PasswordHash, &user.EmailVerified, 
 		&user.VerificationToken, &user.VerificationTokenExpiresAt, &user.WealthStatus, &user.WealthStatusExpiresAt,
-		&user.CreatedAt, &user.UpdatedAt)
+		&user.Status, &user.DeletionScheduledAt, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -196,6 +138,18 @@ func (r *UserRepository) VerifyEmail(userID uuid.UUID) error {
 	return err
 }
 
+// ClaimUnverified marks the address verified and removes any password, so the
+// account can only sign in by email link or provider. Both change in one
+// statement so a password is never left on a claimed account.
+func (r *UserRepository) ClaimUnverified(userID uuid.UUID) error {
+	_, err := r.db.Exec(`
+		UPDATE users SET email_verified = true, password_hash = NULL, verification_token = NULL,
+			verification_token_expires_at = NULL, updated_at = $1
+		WHERE id = $2
+	`, time.Now().UTC(), userID)
+	return err
+}
+
 func (r *UserRepository) UpdateVerificationToken(userID uuid.UUID, token string, expiresAt time.Time) error {
 	_, err := r.db.Exec(`
 		UPDATE users SET verification_token = $1, verification_token_expires_at = $2, updated_at = $3 WHERE id = $4
@@ -203,11 +157,49 @@ func (r *UserRepository) UpdateVerificationToken(userID uuid.UUID, token string,
 	return err
 }
 
-func (r *UserRepository) Delete(userID uuid.UUID) error {
-	_, err := r.db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+// SetStatus pauses or restores an account. Restoring also cancels a scheduled deletion.
+func (r *UserRepository) SetStatus(userID uuid.UUID, status models.AccountStatus) error {
+	now := time.Now().UTC()
+	_, err := r.db.Exec(`
+		UPDATE users SET status = $1, status_changed_at = $2, deletion_scheduled_at = NULL, updated_at = $2 WHERE id = $3
+	`, status, now, userID)
+	return err
+}
+
+// ScheduleDeletion marks the account for purging once the grace period ends
+func (r *UserRepository) ScheduleDeletion(userID uuid.UUID, scheduledAt time.Time) error {
+	now := time.Now().UTC()
+	_, err := r.db.Exec(`
+		UPDATE users SET status = $1, status_changed_at = $2, deletion_scheduled_at = $3, updated_at = $2 WHERE id = $4
+	`, models.AccountStatusPendingDeletion, now, scheduledAt, userID)
 	return err
 }
 
+// FindDueForDeletion returns accounts whose deletion grace period has ended
+func (r *UserRepository) FindDueForDeletion(limit int) ([]uuid.UUID, error) {
+	rows, err := r.db.Query(`
+		SELECT id FROM users
+		WHERE status = $1 AND deletion_scheduled_at <= $2
+		ORDER BY deletion_scheduled_at
+		LIMIT $3
+	`, models.AccountStatusPendingDeletion, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	return userIDs, rows.Err()
+}
+
 // UpdateWealthStatus updates the user's wealth status and optional expiry
 func (r *UserRepository) UpdateWealthStatus(userID uuid.UUID, status models.WealthStatus, expiresAt *time.Time) error {
 	if expiresAt != nil {
@@ -259,3 +251,142 @@ func (r *UserRepository) ExpireWealthStatuses() (int64, error) {
 	}
 	return result.RowsAffected()
 }
+
+// SetPendingEmail starts an email change. Any earlier unconfirmed change is replaced.
+func (r *UserRepository) SetPendingEmail(userID uuid.UUID, pendingEmail, tokenHash string, tokenExpiresAt time.Time, cancelTokenHash string, cancelExpiresAt time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE users SET pending_email = $1, email_change_token = $2, email_change_token_expires_at = $3,
+		       email_change_cancel_token = $4, email_change_cancel_expires_at = $5, previous_email = NULL, updated_at = $6
+		WHERE id = $7
+	`, pendingEmail, tokenHash, tokenExpiresAt, cancelTokenHash, cancelExpiresAt, time.Now().UTC(), userID)
+	return err
+}
+
+// EmailInUse reports whether any account uses the address, ignoring case
+func (r *UserRepository) EmailInUse(email string) (bool, error) {
+	var inUse bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($1))`, email).Scan(&inUse)
+	return inUse, err
+}
+
+// ConfirmEmailChange switches the user to their pending address. It returns nil if
+// the token is unknown or expired, or the address was taken in the meantime.
+func (r *UserRepository) ConfirmEmailChange(tokenHash string) (*models.EmailChange, error) {
+	change := &models.EmailChange{}
+	now := time.Now().UTC()
+	err := r.db.QueryRow(`
+		UPDATE users SET previous_email = email, email = pending_email, email_verified = true,
+		       pending_email = NULL, email_change_token = NULL, email_change_token_expires_at = NULL, updated_at = $1
+		WHERE email_change_token = $2 AND email_change_token_expires_at > $1
+		  AND NOT EXISTS (SELECT 1 FROM users other WHERE LOWER(other.email) = LOWER(users.pending_email))
+		RETURNING id, previous_email, email
+	`, now, tokenHash).Scan(&change.UserID, &change.OldEmail, &change.NewEmail)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+// CancelEmailChange drops a pending change, or reverts a confirmed one while the
+// cancel link is still valid. It returns nil if the token is unknown or expired.
+func (r *UserRepository) CancelEmailChange(cancelTokenHash string) (*models.EmailChange, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	change := &models.EmailChange{}
+	var previousEmail, pendingEmail sql.NullString
+	err = tx.QueryRow(`
+		SELECT id, email, previous_email, pending_email FROM users
+		WHERE email_change_cancel_token = $1 AND email_change_cancel_expires_at > $2
+		FOR UPDATE
+	`, cancelTokenHash, now).Scan(&change.UserID, &change.NewEmail, &previousEmail, &pendingEmail)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	change.OldEmail = change.NewEmail
+	if previousEmail.Valid {
+		// Already confirmed: put the old address back, unless another account
+		// has signed up with it since
+		change.OldEmail = previousEmail.String
+		change.Reverted = true
+
+		var taken bool
+		err = tx.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($1) AND id != $2)
+		`, change.OldEmail, change.UserID).Scan(&taken)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrPreviousEmailTaken
+		}
+	} else {
+		change.NewEmail = pendingEmail.String
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET email = $1, pending_email = NULL, email_change_token = NULL, email_change_token_expires_at = NULL,
+		       email_change_cancel_token = NULL, email_change_cancel_expires_at = NULL, previous_email = NULL, updated_at = $2
+		WHERE id = $3
+	`, change.OldEmail, now, change.UserID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		// Claimed between the check and the update
+		return nil, ErrPreviousEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return change, tx.Commit()
+}
+
+// GetPrivacySettings returns the user's privacy settings, or nil if the user doesn't exist
+func (r *UserRepository) GetPrivacySettings(userID uuid.UUID) (*models.PrivacySettings, error) {
+	var settings models.PrivacySettings
+	err := r.db.QueryRow(`
+		SELECT read_receipts_enabled, hide_online_status, last_seen_visibility FROM users WHERE id = $1
+	`, userID).Scan(&settings.ReadReceipts, &settings.HideOnlineStatus, &settings.LastSeen)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (r *UserRepository) UpdatePrivacySettings(userID uuid.UUID, settings *models.PrivacySettings) error {
+	_, err := r.db.Exec(`
+		UPDATE users SET read_receipts_enabled = $1, hide_online_status = $2, last_seen_visibility = $3, updated_at = $4
+		WHERE id = $5
+	`, settings.ReadReceipts, settings.HideOnlineStatus, settings.LastSeen, time.Now().UTC(), userID)
+	return err
+}
+
+// ReadReceiptsEnabled reports which of the given users share read receipts
+func (r *UserRepository) ReadReceiptsEnabled(userIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	enabled := make(map[uuid.UUID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		settings, err := r.GetPrivacySettings(userID)
+		if err != nil {
+			return nil, err
+		}
+		enabled[userID] = settings != nil && settings.ReadReceipts
+	}
+	return enabled, nil
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+)
+
+type UserEventRepository struct {
+	db *sql.DB
+}
+
+func NewUserEventRepository(db *sql.DB) *UserEventRepository {
+	return &UserEventRepository{db: db}
+}
+
+// Append stores an event in the user's outbox under their next sequence number
+// and returns it. Taking the number locks the user's counter until the event is
+// committed, so events become visible in sequence order.
+func (r *UserEventRepository) Append(userID uuid.UUID, eventType models.WSMessageType, payload []byte) (int64, error) {
+	var seq int64
+	err := r.db.QueryRow(`
+		WITH next AS (
+			INSERT INTO user_event_sequences (user_id, last_seq) VALUES ($1, 1)
+			ON CONFLICT (user_id) DO UPDATE SET last_seq = user_event_sequences.last_seq + 1
+			RETURNING last_seq
+		)
+		INSERT INTO user_events (user_id, seq, type, payload, created_at)
+		SELECT $1, last_seq, $2, $3, $4 FROM next
+		RETURNING seq
+	`, userID, eventType, payload, time.Now().UTC()).Scan(&seq)
+	return seq, err
+}
+
+// CurrentSeq returns the sequence number of the user's latest event, or zero
+// if they never had one
+func (r *UserEventRepository) CurrentSeq(userID uuid.UUID) (int64, error) {
+	var seq int64
+	err := r.db.QueryRow(`SELECT last_seq FROM user_event_sequences WHERE user_id = $1`, userID).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// ListSince returns up to limit of the user's stored events after the given
+// sequence number, oldest first
+func (r *UserEventRepository) ListSince(userID uuid.UUID, since int64, limit int) ([]models.UserEvent, error) {
+	rows, err := r.db.Query(`
+		SELECT user_id, seq, type, payload, created_at
+		FROM user_events
+		WHERE user_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`, userID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.UserEvent
+	for rows.Next() {
+		var event models.UserEvent
+		if err := rows.Scan(&event.UserID, &event.Seq, &event.Type, &event.Payload, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Ack records that the session has seen the user's events up to seq, then
+// prunes the events every live session of the user has acknowledged. Sessions
+// that never acknowledged anything don't hold events back.
+func (r *UserEventRepository) Ack(sessionID, userID uuid.UUID, seq int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`
+		INSERT INTO user_event_acks (session_id, user_id, seq, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (session_id) DO UPDATE
+		SET seq = GREATEST(user_event_acks.seq, EXCLUDED.seq), updated_at = EXCLUDED.updated_at
+	`, sessionID, userID, seq, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM user_events
+		WHERE user_id = $1 AND seq <= (
+			SELECT MIN(a.seq)
+			FROM user_event_acks a
+			JOIN sessions s ON s.id = a.session_id
+			WHERE a.user_id = $1 AND s.revoked_at IS NULL AND s.expires_at > $2
+		)
+	`, userID, now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteOlderThan removes events created before the cutoff, acknowledged or
+// not, and returns how many were removed
+func (r *UserEventRepository) DeleteOlderThan(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM user_events WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
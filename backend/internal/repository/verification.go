@@ -116,6 +116,50 @@ func (r *VerificationRepository) GetLatestByUserID(userID uuid.UUID) (*models.Ve
 	return &v, nil
 }
 
+// ListByUserID returns all of the user's verification requests, newest first
+func (r *VerificationRepository) ListByUserID(userID uuid.UUID) ([]models.VerificationRequest, error) {
+	rows, err := r.db.Query(`
+		SELECT id, user_id, document_type, document_url, video_url, verification_code, status,
+		       rejection_reason, created_at, reviewed_at, reviewed_by
+		FROM verification_requests
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []models.VerificationRequest
+	for rows.Next() {
+		var v models.VerificationRequest
+		var rejectionReason sql.NullString
+		var reviewedAt sql.NullTime
+		var reviewedBy sql.NullString
+
+		err := rows.Scan(&v.ID, &v.UserID, &v.DocumentType, &v.DocumentURL, &v.VideoURL,
+			&v.VerificationCode, &v.Status, &rejectionReason, &v.CreatedAt, &reviewedAt, &reviewedBy)
+		if err != nil {
+			return nil, err
+		}
+
+		if rejectionReason.Valid {
+			v.RejectionReason = &rejectionReason.String
+		}
+		if reviewedAt.Valid {
+			v.ReviewedAt = &reviewedAt.Time
+		}
+		if reviewedBy.Valid {
+			id, _ := uuid.Parse(reviewedBy.String)
+			v.ReviewedBy = &id
+		}
+
+		requests = append(requests, v)
+	}
+
+	return requests, rows.Err()
+}
+
 func (r *VerificationRepository) HasPendingRequest(userID uuid.UUID) (bool, error) {
 	var exists bool
 	err := r.db.QueryRow(`
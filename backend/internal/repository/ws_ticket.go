@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+)
+
+type WSTicketRepository struct {
+	db *sql.DB
+}
+
+func NewWSTicketRepository(db *sql.DB) *WSTicketRepository {
+	return &WSTicketRepository{db: db}
+}
+
+// Create stores a new ticket and clears out expired ones
+func (r *WSTicketRepository) Create(ticketHash string, userID, sessionID uuid.UUID, expiresAt time.Time) error {
+	now := time.Now().UTC()
+
+	if _, err := r.db.Exec(`DELETE FROM ws_tickets WHERE expires_at < $1`, now); err != nil {
+		return err
+	}
+
+	_, err := r.db.Exec(`
+		INSERT INTO ws_tickets (ticket_hash, user_id, session_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, ticketHash, userID, sessionID, expiresAt, now)
+	return err
+}
+
+// Consume deletes and returns the ticket so it can only be used once. It returns
+// nil if the ticket is unknown or expired.
+func (r *WSTicketRepository) Consume(ticketHash string) (*models.WSTicket, error) {
+	ticket := &models.WSTicket{}
+	err := r.db.QueryRow(`
+		DELETE FROM ws_tickets WHERE ticket_hash = $1 AND expires_at > $2
+		RETURNING user_id, session_id, expires_at
+	`, ticketHash, time.Now().UTC()).Scan(&ticket.UserID, &ticket.SessionID, &ticket.ExpiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ticket, nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"heyspoilme/internal/repository"
+)
+
+// AccountPurgeJobService permanently deletes accounts whose deletion grace period has ended
+type AccountPurgeJobService struct {
+	userRepo        *repository.UserRepository
+	deletionService *DeletionService
+	interval        time.Duration
+	batchSize       int
+	stopChan        chan struct{}
+}
+
+func NewAccountPurgeJobService(userRepo *repository.UserRepository, deletionService *DeletionService) *AccountPurgeJobService {
+	return &AccountPurgeJobService{
+		userRepo:        userRepo,
+		deletionService: deletionService,
+		interval:        1 * time.Hour,
+		batchSize:       50,
+		stopChan:        make(chan struct{}),
+	}
+}
+
+// Start begins the background job that purges accounts due for deletion
+func (s *AccountPurgeJobService) Start() {
+	log.Printf("[AccountPurgeJob] Starting account purge job (checking every %v)", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	// Run immediately on start
+	s.purgeDueAccounts()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.purgeDueAccounts()
+		case <-s.stopChan:
+			log.Printf("[AccountPurgeJob] Stopping account purge job")
+			return
+		}
+	}
+}
+
+// Stop stops the background job
+func (s *AccountPurgeJobService) Stop() {
+	close(s.stopChan)
+}
+
+func (s *AccountPurgeJobService) purgeDueAccounts() {
+	userIDs, err := s.userRepo.FindDueForDeletion(s.batchSize)
+	if err != nil {
+		log.Printf("[AccountPurgeJob] Error fetching accounts due for deletion: %v", err)
+		return
+	}
+
+	if len(userIDs) == 0 {
+		return
+	}
+
+	log.Printf("[AccountPurgeJob] Purging %d accounts", len(userIDs))
+
+	now := time.Now().UTC()
+	for _, userID := range userIDs {
+		// The user may have signed in and cancelled the deletion since the query
+		// ran, which DeleteUserIfDue checks under the row lock
+		if err := s.deletionService.DeleteUserIfDue(userID, now); err != nil {
+			log.Printf("[AccountPurgeJob] Failed to purge account %s: %v", userID, err)
+		}
+	}
+}
@@ -9,14 +9,16 @@ import (
 )
 
 type AdminService struct {
-	adminRepo *repository.AdminRepository
-	s3Client  *storage.S3Client
+	adminRepo       *repository.AdminRepository
+	deletionService *DeletionService
+	s3Client        *storage.S3Client
 }
 
-func NewAdminService(adminRepo *repository.AdminRepository, s3Client *storage.S3Client) *AdminService {
+func NewAdminService(adminRepo *repository.AdminRepository, deletionService *DeletionService, s3Client *storage.S3Client) *AdminService {
 	return &AdminService{
-		adminRepo: adminRepo,
-		s3Client:  s3Client,
+		adminRepo:       adminRepo,
+		deletionService: deletionService,
+		s3Client:        s3Client,
 	}
 }
 
@@ -75,9 +77,9 @@ func (s *AdminService) GetProfileImage(imageID uuid.UUID) (*models.ProfileImage,
 	return s.adminRepo.GetProfileImage(imageID)
 }
 
-// DeleteUser deletes a user and all related data
+// DeleteUser deletes a user and all related data, including their S3 objects
 func (s *AdminService) DeleteUser(userID uuid.UUID) error {
-	return s.adminRepo.DeleteUser(userID)
+	return s.deletionService.DeleteUser(userID)
 }
 
 // UpdateUserWealthStatus updates a user's wealth status
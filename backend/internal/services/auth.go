@@ -2,31 +2,110 @@ package services
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"errors"
+	"log"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 
 	"heyspoilme/internal/models"
 	"heyspoilme/internal/repository"
 	"heyspoilme/pkg/email"
+	"heyspoilme/pkg/token"
 )
 
+const (
+	accessTokenTTL   = 15 * time.Minute
+	refreshTokenTTL  = 30 * 24 * time.Hour
+	passwordResetTTL = 1 * time.Hour
+	wsTicketTTL      = 30 * time.Second
+	magicLinkTTL     = 15 * time.Minute
+	// At most this many magic links per address within magicLinkTTL
+	magicLinkLimit = 3
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrInvalidResetToken   = errors.New("invalid or expired reset token")
+	ErrInvalidWSTicket     = errors.New("invalid or expired websocket ticket")
+	ErrInvalidCredentials  = errors.New("invalid email or password")
+	ErrInvalidMagicLink    = errors.New("invalid or expired sign-in link")
+	ErrNoPassword          = errors.New("this account has no password, sign in with an emailed link or the provider you signed up with")
+)
+
+// authUserStore is the part of *repository.UserRepository signing in uses
+type authUserStore interface {
+	Create(email string, emailVerified bool) (*models.User, error)
+	CreateWithPassword(email, passwordHash, verificationToken string, tokenExpiresAt time.Time) (*models.User, error)
+	FindByID(id uuid.UUID) (*models.User, error)
+	FindByEmail(email string) (*models.User, error)
+	FindByVerificationToken(token string) (*models.User, error)
+	VerifyEmail(userID uuid.UUID) error
+	UpdateVerificationToken(userID uuid.UUID, token string, expiresAt time.Time) error
+	SetStatus(userID uuid.UUID, status models.AccountStatus) error
+	accountClaimer
+}
+
+// sessionStore keeps signed-in sessions and their refresh tokens. It is
+// satisfied by *repository.SessionRepository.
+type sessionStore interface {
+	Create(userID uuid.UUID, refreshTokenHash string, meta models.SessionMetadata, expiresAt time.Time) (*models.Session, error)
+	FindByRefreshTokenHash(hash string) (*models.Session, error)
+	FindByPreviousRefreshTokenHash(hash string) (*models.Session, error)
+	Rotate(sessionID uuid.UUID, oldHash, newHash string, expiresAt time.Time) (bool, error)
+	IsActive(sessionID uuid.UUID) (bool, error)
+}
+
+// magicLinkStore keeps sign-in links. It is satisfied by
+// *repository.MagicLinkRepository.
+type magicLinkStore interface {
+	Create(email, tokenHash string, expiresAt time.Time) error
+	CountSince(email string, since time.Time) (int, error)
+	Consume(tokenHash string) (string, error)
+}
+
+// authMailer sends the emails of the sign in flows. It is satisfied by
+// *email.ZeptoMailClient.
+type authMailer interface {
+	SendVerificationEmail(toEmail, token string) error
+	SendPasswordResetEmail(toEmail, token string) error
+	SendMagicLinkEmail(toEmail, token string) error
+}
+
 type AuthService struct {
-	userRepo    *repository.UserRepository
-	jwtSecret   string
-	emailClient *email.ZeptoMailClient
+	userRepo          authUserStore
+	sessionRepo       sessionStore
+	passwordResetRepo *repository.PasswordResetRepository
+	magicLinkRepo     magicLinkStore
+	wsTicketRepo      *repository.WSTicketRepository
+	sessionService    *SessionService
+	loginThrottle     *LoginThrottleService
+	keySet            *token.KeySet
+	emailClient       authMailer
 }
 
-func NewAuthService(userRepo *repository.UserRepository, jwtSecret string, emailClient *email.ZeptoMailClient) *AuthService {
-	return &AuthService{
-		userRepo:    userRepo,
-		jwtSecret:   jwtSecret,
-		emailClient: emailClient,
+func NewAuthService(userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, passwordResetRepo *repository.PasswordResetRepository, magicLinkRepo *repository.MagicLinkRepository, wsTicketRepo *repository.WSTicketRepository, sessionService *SessionService, loginThrottle *LoginThrottleService, keySet *token.KeySet, emailClient *email.ZeptoMailClient) *AuthService {
+	s := &AuthService{
+		userRepo:          userRepo,
+		sessionRepo:       sessionRepo,
+		passwordResetRepo: passwordResetRepo,
+		magicLinkRepo:     magicLinkRepo,
+		wsTicketRepo:      wsTicketRepo,
+		sessionService:    sessionService,
+		loginThrottle:     loginThrottle,
+		keySet:            keySet,
+	}
+	// A nil client stays a nil interface, so the checks for a configured
+	// email service still work
+	if emailClient != nil {
+		s.emailClient = emailClient
 	}
+	return s
 }
 
 func generateVerificationToken() (string, error) {
@@ -37,14 +116,10 @@ func generateVerificationToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
-	jwt.RegisteredClaims
-}
-
-func (s *AuthService) FindOrCreateUser(googleID, email string) (*models.User, bool, error) {
-	return s.userRepo.FindOrCreate(googleID, email)
+// hashToken returns the SHA-256 hex digest stored in place of a raw token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func (s *AuthService) GetUserByID(userID uuid.UUID) (*models.User, error) {
@@ -141,57 +216,349 @@ func (s *AuthService) ResendVerificationEmail(userID uuid.UUID) error {
 	return s.emailClient.SendVerificationEmail(user.Email, token)
 }
 
-func (s *AuthService) Signin(email, password string) (*models.User, error) {
+// RequestPasswordReset emails a single-use reset link. Unknown addresses are not
+// reported back so the endpoint can't be used to discover registered emails.
+func (s *AuthService) RequestPasswordReset(userEmail string) error {
+	if s.emailClient == nil {
+		return errors.New("email service not configured")
+	}
+
+	user, err := s.userRepo.FindByEmail(userEmail)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	token, err := generateVerificationToken()
+	if err != nil {
+		return errors.New("failed to generate reset token")
+	}
+
+	if err := s.passwordResetRepo.Create(user.ID, hashToken(token), time.Now().UTC().Add(passwordResetTTL)); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.emailClient.SendPasswordResetEmail(user.Email, token); err != nil {
+			log.Printf("[Auth] Failed to send password reset email: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// ResetPassword sets a new password using a reset token and signs the user out
+// everywhere. The token, the password and the sessions change together, so a
+// failure leaves the link usable and nothing half done.
+func (s *AuthService) ResetPassword(token, newPassword string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.New("failed to hash password")
+	}
+
+	userID, err := s.passwordResetRepo.ResetPassword(hashToken(token), string(hashedPassword))
+	if err != nil {
+		return err
+	}
+	if userID == uuid.Nil {
+		return ErrInvalidResetToken
+	}
+
+	s.sessionService.DisconnectUser(userID)
+	return nil
+}
+
+// RequestMagicLink emails a single-use sign-in link. It works for addresses
+// without an account too, which are signed up when the link is opened. Like
+// password resets, the response never says whether the address is registered.
+func (s *AuthService) RequestMagicLink(userEmail string) error {
+	if s.emailClient == nil {
+		return errors.New("email service not configured")
+	}
+
+	now := time.Now().UTC()
+	recent, err := s.magicLinkRepo.CountSince(userEmail, now.Add(-magicLinkTTL))
+	if err != nil {
+		return err
+	}
+	if recent >= magicLinkLimit {
+		// Quietly drop the request so the inbox can't be flooded
+		return nil
+	}
+
+	token, err := generateVerificationToken()
+	if err != nil {
+		return errors.New("failed to generate sign-in token")
+	}
+
+	if err := s.magicLinkRepo.Create(userEmail, hashToken(token), now.Add(magicLinkTTL)); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.emailClient.SendMagicLinkEmail(userEmail, token); err != nil {
+			log.Printf("[Auth] Failed to send magic link email: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// SigninWithMagicLink consumes a sign-in link and returns its user, creating the
+// account if needed. Opening the link proves the address, so the email is marked
+// verified. The caller still has to issue tokens (or a 2FA challenge).
+func (s *AuthService) SigninWithMagicLink(token string) (*models.User, bool, error) {
+	userEmail, err := s.magicLinkRepo.Consume(hashToken(token))
+	if err != nil {
+		return nil, false, err
+	}
+	if userEmail == "" {
+		return nil, false, ErrInvalidMagicLink
+	}
+
+	user, err := s.userRepo.FindByEmail(userEmail)
+	if err != nil {
+		return nil, false, err
+	}
+	if user == nil {
+		user, err = s.userRepo.Create(userEmail, true)
+		if err != nil {
+			return nil, false, err
+		}
+		return user, true, nil
+	}
+
+	if err := claimUnverifiedAccount(s.userRepo, s.sessionService, user); err != nil {
+		return nil, false, err
+	}
+
+	return user, false, nil
+}
+
+// accountClaimer marks an address verified and drops the password set by
+// whoever signed up with it. It is satisfied by *repository.UserRepository.
+type accountClaimer interface {
+	ClaimUnverified(userID uuid.UUID) error
+}
+
+// sessionRevoker signs a user out everywhere. It is satisfied by *SessionService.
+type sessionRevoker interface {
+	RevokeAllSessions(userID uuid.UUID) error
+}
+
+// claimUnverifiedAccount hands an account with an unverified address to the
+// person who just proved they own it, by email link or identity provider.
+// Whoever set the password never proved it, so they must not keep access: their
+// sessions are revoked first, so a failure part way leaves the account
+// unclaimed and the next sign in tries again.
+func claimUnverifiedAccount(users accountClaimer, sessions sessionRevoker, user *models.User) error {
+	if user.EmailVerified {
+		return nil
+	}
+
+	if user.PasswordHash.Valid {
+		if err := sessions.RevokeAllSessions(user.ID); err != nil {
+			return err
+		}
+	}
+	if err := users.ClaimUnverified(user.ID); err != nil {
+		return err
+	}
+
+	user.PasswordHash = sql.NullString{}
+	user.EmailVerified = true
+	return nil
+}
+
+// Signin checks an email and password. Failed attempts are throttled per
+// account and per client IP.
+func (s *AuthService) Signin(email, password, ip string) (*models.User, error) {
+	if err := s.loginThrottle.Check(email, ip); err != nil {
+		return nil, err
+	}
+
 	user, err := s.userRepo.FindByEmail(email)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
-		return nil, errors.New("invalid email or password")
+		return nil, s.failSignin(email, ip, ErrInvalidCredentials)
 	}
 
 	if !user.PasswordHash.Valid {
-		return nil, errors.New("please sign in with Google")
+		return nil, ErrNoPassword
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash.String), []byte(password))
 	if err != nil {
-		return nil, errors.New("invalid email or password")
+		return nil, s.failSignin(email, ip, ErrInvalidCredentials)
+	}
+
+	if err := s.loginThrottle.RecordSuccess(email); err != nil {
+		log.Printf("[Auth] Failed to reset login attempts: %v", err)
 	}
 
 	return user, nil
 }
 
-func (s *AuthService) GenerateToken(user *models.User) (string, error) {
-	claims := &Claims{
-		UserID: user.ID,
-		Email:  user.Email,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "heyspoilme",
-		},
+func (s *AuthService) failSignin(email, ip string, err error) error {
+	if recordErr := s.loginThrottle.RecordFailure(email, ip); recordErr != nil {
+		log.Printf("[Auth] Failed to record login attempt: %v", recordErr)
+	}
+	return err
+}
+
+// GenerateToken starts a new session for the user and returns a short-lived
+// access token together with the session's refresh token.
+//
+// Every way of signing in ends here, so this is also where a paused account, or
+// one waiting to be deleted, is restored.
+func (s *AuthService) GenerateToken(user *models.User, meta models.SessionMetadata) (*models.AuthTokens, error) {
+	if user.Status != "" && user.Status != models.AccountStatusActive {
+		if err := s.userRepo.SetStatus(user.ID, models.AccountStatusActive); err != nil {
+			return nil, err
+		}
+		log.Printf("[Auth] Restored %s account for user: %s", user.Status, user.ID)
+		user.Status = models.AccountStatusActive
+		user.DeletionScheduledAt = nil
+	}
+
+	refreshToken, err := generateVerificationToken()
+	if err != nil {
+		return nil, errors.New("failed to generate refresh token")
+	}
+
+	session, err := s.sessionRepo.Create(user.ID, hashToken(refreshToken), meta, time.Now().UTC().Add(refreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.signAccessToken(user, session.ID)
+	if err != nil {
+		return nil, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.jwtSecret))
+	return &models.AuthTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
 }
 
-func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
+// RefreshToken exchanges a refresh token for a new access token and rotates the
+// refresh token. Presenting an already-rotated token revokes the whole session,
+// since it means the token was copied.
+func (s *AuthService) RefreshToken(refreshToken string) (*models.AuthTokens, error) {
+	oldHash := hashToken(refreshToken)
+
+	session, err := s.sessionRepo.FindByRefreshTokenHash(oldHash)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		reused, err := s.sessionRepo.FindByPreviousRefreshTokenHash(oldHash)
+		if err != nil {
+			return nil, err
 		}
-		return []byte(s.jwtSecret), nil
-	})
+		if reused != nil {
+			log.Printf("[Auth] Refresh token reuse detected, revoking session %s for user %s", reused.ID, reused.UserID)
+			if err := s.sessionService.RevokeSession(reused.UserID, reused.ID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+				return nil, err
+			}
+		}
+		return nil, ErrInvalidRefreshToken
+	}
 
+	if session.RevokedAt != nil || session.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := s.userRepo.FindByID(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	newRefreshToken, err := generateVerificationToken()
+	if err != nil {
+		return nil, errors.New("failed to generate refresh token")
+	}
+
+	rotated, err := s.sessionRepo.Rotate(session.ID, oldHash, hashToken(newRefreshToken), time.Now().UTC().Add(refreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	accessToken, err := s.signAccessToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthTokens{
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// Logout revokes a single session
+func (s *AuthService) Logout(userID, sessionID uuid.UUID) error {
+	err := s.sessionService.RevokeSession(userID, sessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	return err
+}
+
+// LogoutAll revokes every session of the user
+func (s *AuthService) LogoutAll(userID uuid.UUID) error {
+	return s.sessionService.RevokeAllSessions(userID)
+}
+
+// IssueWSTicket mints a short-lived, single-use ticket for opening a WebSocket
+// with the given session
+func (s *AuthService) IssueWSTicket(userID, sessionID uuid.UUID) (string, int, error) {
+	ticket, err := generateVerificationToken()
+	if err != nil {
+		return "", 0, errors.New("failed to generate ticket")
+	}
+
+	if err := s.wsTicketRepo.Create(hashToken(ticket), userID, sessionID, time.Now().UTC().Add(wsTicketTTL)); err != nil {
+		return "", 0, err
+	}
+
+	return ticket, int(wsTicketTTL.Seconds()), nil
+}
+
+// RedeemWSTicket consumes a ticket. It fails if the ticket was already used,
+// has expired, or its session has since been revoked.
+func (s *AuthService) RedeemWSTicket(ticket string) (*models.WSTicket, error) {
+	wsTicket, err := s.wsTicketRepo.Consume(hashToken(ticket))
 	if err != nil {
 		return nil, err
 	}
+	if wsTicket == nil {
+		return nil, ErrInvalidWSTicket
+	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	active, err := s.sessionRepo.IsActive(wsTicket.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrInvalidWSTicket
 	}
 
-	return nil, errors.New("invalid token")
+	return wsTicket, nil
+}
+
+func (s *AuthService) signAccessToken(user *models.User, sessionID uuid.UUID) (string, error) {
+	return s.keySet.Sign(token.NewClaims(user.ID, user.Email, sessionID, accessTokenTTL))
 }
@@ -0,0 +1,494 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/internal/websocket"
+	"heyspoilme/pkg/token"
+)
+
+// The rest of authUserStore, on top of what provider sign in uses
+
+func (f *fakeUserStore) CreateWithPassword(email, passwordHash, verificationToken string, tokenExpiresAt time.Time) (*models.User, error) {
+	user := f.add(models.User{Email: email})
+	user.PasswordHash.String, user.PasswordHash.Valid = passwordHash, true
+	user.VerificationToken.String, user.VerificationToken.Valid = verificationToken, true
+	user.VerificationTokenExpiresAt.Time, user.VerificationTokenExpiresAt.Valid = tokenExpiresAt, true
+	copied := *user
+	return &copied, nil
+}
+
+func (f *fakeUserStore) FindByVerificationToken(token string) (*models.User, error) {
+	for _, user := range f.users {
+		if user.VerificationToken.Valid && user.VerificationToken.String == token {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUserStore) VerifyEmail(userID uuid.UUID) error {
+	f.users[userID].EmailVerified = true
+	return nil
+}
+
+func (f *fakeUserStore) UpdateVerificationToken(userID uuid.UUID, token string, expiresAt time.Time) error {
+	user := f.users[userID]
+	user.VerificationToken.String, user.VerificationToken.Valid = token, true
+	user.VerificationTokenExpiresAt.Time, user.VerificationTokenExpiresAt.Valid = expiresAt, true
+	return nil
+}
+
+func (f *fakeUserStore) SetStatus(userID uuid.UUID, status models.AccountStatus) error {
+	f.users[userID].Status = status
+	f.users[userID].DeletionScheduledAt = nil
+	return nil
+}
+
+// fakeSessionStore keeps sessions in memory with the same conditional updates
+// as the Postgres repository
+type fakeSessionStore struct {
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*models.Session
+	previous map[uuid.UUID]string
+	// beforeRotate, if set, runs as Rotate is called, before the swap
+	beforeRotate func()
+}
+
+func newFakeSessionStore() *fakeSessionStore {
+	return &fakeSessionStore{
+		sessions: make(map[uuid.UUID]*models.Session),
+		previous: make(map[uuid.UUID]string),
+	}
+}
+
+func (f *fakeSessionStore) Create(userID uuid.UUID, refreshTokenHash string, meta models.SessionMetadata, expiresAt time.Time) (*models.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session := &models.Session{
+		ID:               uuid.New(),
+		UserID:           userID,
+		RefreshTokenHash: refreshTokenHash,
+		AuthMethod:       meta.AuthMethod,
+		ExpiresAt:        expiresAt,
+	}
+	f.sessions[session.ID] = session
+	copied := *session
+	return &copied, nil
+}
+
+func (f *fakeSessionStore) find(match func(*models.Session) bool) (*models.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, session := range f.sessions {
+		if match(session) {
+			copied := *session
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeSessionStore) FindByRefreshTokenHash(hash string) (*models.Session, error) {
+	return f.find(func(session *models.Session) bool { return session.RefreshTokenHash == hash })
+}
+
+func (f *fakeSessionStore) FindByPreviousRefreshTokenHash(hash string) (*models.Session, error) {
+	return f.find(func(session *models.Session) bool { return f.previous[session.ID] == hash })
+}
+
+func (f *fakeSessionStore) Rotate(sessionID uuid.UUID, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	if f.beforeRotate != nil {
+		f.beforeRotate()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session, ok := f.sessions[sessionID]
+	if !ok || session.RefreshTokenHash != oldHash || session.RevokedAt != nil {
+		return false, nil
+	}
+	session.RefreshTokenHash = newHash
+	session.ExpiresAt = expiresAt
+	f.previous[sessionID] = oldHash
+	return true, nil
+}
+
+func (f *fakeSessionStore) IsActive(sessionID uuid.UUID) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session, ok := f.sessions[sessionID]
+	return ok && session.RevokedAt == nil && session.ExpiresAt.After(time.Now()), nil
+}
+
+func (f *fakeSessionStore) ListActiveForUser(userID uuid.UUID) ([]models.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var sessions []models.Session
+	for _, session := range f.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && session.ExpiresAt.After(time.Now()) {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions, nil
+}
+
+func (f *fakeSessionStore) RevokeForUser(sessionID, userID uuid.UUID) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session, ok := f.sessions[sessionID]
+	if !ok || session.UserID != userID || session.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	session.RevokedAt = &now
+	return true, nil
+}
+
+func (f *fakeSessionStore) RevokeAllForUser(userID uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for _, session := range f.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+type authTest struct {
+	service    *AuthService
+	users      *fakeUserStore
+	sessions   *fakeSessionStore
+	magicLinks *fakeMagicLinkStore
+	mailer     *fakeMailer
+	user       *models.User
+}
+
+func newAuthTest(t *testing.T) *authTest {
+	t.Helper()
+
+	keySet, err := token.NewEphemeralKeySet()
+	if err != nil {
+		t.Fatalf("NewEphemeralKeySet: %v", err)
+	}
+	hub := websocket.NewHub(websocket.NewMemoryBackplane())
+	go hub.Run()
+
+	tt := &authTest{
+		users:      &fakeUserStore{users: make(map[uuid.UUID]*models.User)},
+		sessions:   newFakeSessionStore(),
+		magicLinks: &fakeMagicLinkStore{},
+		mailer:     &fakeMailer{magicLinks: make(chan string, 8)},
+	}
+	tt.user = tt.users.add(models.User{Email: "member@example.com", EmailVerified: true, Status: models.AccountStatusActive})
+	tt.service = &AuthService{
+		userRepo:       tt.users,
+		sessionRepo:    tt.sessions,
+		magicLinkRepo:  tt.magicLinks,
+		sessionService: &SessionService{sessionRepo: tt.sessions, hub: hub},
+		keySet:         keySet,
+		emailClient:    tt.mailer,
+	}
+	return tt
+}
+
+// signIn starts a session and returns its tokens and ID
+func (tt *authTest) signIn(t *testing.T) (*models.AuthTokens, uuid.UUID) {
+	t.Helper()
+
+	tokens, err := tt.service.GenerateToken(tt.user, models.SessionMetadata{AuthMethod: models.AuthMethodPassword})
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	session, _ := tt.sessions.FindByRefreshTokenHash(hashToken(tokens.RefreshToken))
+	if session == nil {
+		t.Fatal("no session stored for the refresh token")
+	}
+	return tokens, session.ID
+}
+
+func (tt *authTest) isActive(t *testing.T, sessionID uuid.UUID) bool {
+	t.Helper()
+
+	active, err := tt.sessions.IsActive(sessionID)
+	if err != nil {
+		t.Fatalf("IsActive: %v", err)
+	}
+	return active
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	tt := newAuthTest(t)
+	tokens, sessionID := tt.signIn(t)
+
+	refreshed, err := tt.service.RefreshToken(tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+	if refreshed.RefreshToken == tokens.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+
+	claims, err := tt.service.keySet.Parse(refreshed.AccessToken)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if claims.SessionID != sessionID || claims.UserID != tt.user.ID {
+		t.Errorf("claims = %+v, want session %s of user %s", claims, sessionID, tt.user.ID)
+	}
+
+	// The new token keeps working
+	if _, err := tt.service.RefreshToken(refreshed.RefreshToken); err != nil {
+		t.Errorf("RefreshToken with the rotated token: %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	tt := newAuthTest(t)
+	tokens, sessionID := tt.signIn(t)
+
+	refreshed, err := tt.service.RefreshToken(tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+
+	// Someone replays the token that was just rotated out
+	if _, err := tt.service.RefreshToken(tokens.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("replayed token: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if tt.isActive(t, sessionID) {
+		t.Error("session still active after its refresh token was reused")
+	}
+
+	// The legitimate holder of the current token is signed out too
+	if _, err := tt.service.RefreshToken(refreshed.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("current token after reuse: err = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRefreshTokenConcurrentRotation(t *testing.T) {
+	tt := newAuthTest(t)
+	tokens, sessionID := tt.signIn(t)
+
+	// A second refresh with the same token wins the race: it finds the session
+	// and rotates it while the first is between its lookup and its swap
+	var second *models.AuthTokens
+	var secondErr error
+	tt.sessions.beforeRotate = func() {
+		tt.sessions.beforeRotate = nil
+		second, secondErr = tt.service.RefreshToken(tokens.RefreshToken)
+	}
+
+	_, firstErr := tt.service.RefreshToken(tokens.RefreshToken)
+	if secondErr != nil {
+		t.Fatalf("winning refresh: %v", secondErr)
+	}
+	if !errors.Is(firstErr, ErrInvalidRefreshToken) {
+		t.Fatalf("losing refresh: err = %v, want ErrInvalidRefreshToken", firstErr)
+	}
+
+	// Losing the race is not reuse: the session and the winner's token survive
+	if !tt.isActive(t, sessionID) {
+		t.Error("session revoked after a lost race")
+	}
+	if _, err := tt.service.RefreshToken(second.RefreshToken); err != nil {
+		t.Errorf("RefreshToken with the winner's token: %v", err)
+	}
+}
+
+func TestLogoutDeactivatesSession(t *testing.T) {
+	tt := newAuthTest(t)
+	tokens, sessionID := tt.signIn(t)
+	_, otherID := tt.signIn(t)
+
+	if !tt.isActive(t, sessionID) {
+		t.Fatal("new session is not active")
+	}
+
+	if err := tt.service.Logout(tt.user.ID, sessionID); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if tt.isActive(t, sessionID) {
+		t.Error("session still active after logout")
+	}
+	if !tt.isActive(t, otherID) {
+		t.Error("logout ended the user's other session")
+	}
+	if _, err := tt.service.RefreshToken(tokens.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refresh after logout: err = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	// Logging out twice is fine
+	if err := tt.service.Logout(tt.user.ID, sessionID); err != nil {
+		t.Errorf("second Logout: %v", err)
+	}
+
+	if err := tt.service.LogoutAll(tt.user.ID); err != nil {
+		t.Fatalf("LogoutAll: %v", err)
+	}
+	if tt.isActive(t, otherID) {
+		t.Error("session still active after logging out everywhere")
+	}
+}
+
+type magicLinkToken struct {
+	email     string
+	tokenHash string
+	expiresAt time.Time
+	createdAt time.Time
+	used      bool
+}
+
+// fakeMagicLinkStore keeps sign-in links in memory with the same conditions as
+// the Postgres repository
+type fakeMagicLinkStore struct {
+	tokens []*magicLinkToken
+}
+
+func (f *fakeMagicLinkStore) Create(email, tokenHash string, expiresAt time.Time) error {
+	for _, token := range f.tokens {
+		if token.email == email {
+			token.used = true
+		}
+	}
+	f.tokens = append(f.tokens, &magicLinkToken{email: email, tokenHash: tokenHash, expiresAt: expiresAt, createdAt: time.Now()})
+	return nil
+}
+
+func (f *fakeMagicLinkStore) CountSince(email string, since time.Time) (int, error) {
+	count := 0
+	for _, token := range f.tokens {
+		if token.email == email && token.createdAt.After(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeMagicLinkStore) Consume(tokenHash string) (string, error) {
+	for _, token := range f.tokens {
+		if token.tokenHash == tokenHash && !token.used && token.expiresAt.After(time.Now()) {
+			token.used = true
+			return token.email, nil
+		}
+	}
+	return "", nil
+}
+
+// fakeMailer hands the tokens it is asked to email to the test
+type fakeMailer struct {
+	magicLinks chan string
+}
+
+func (f *fakeMailer) SendVerificationEmail(toEmail, token string) error  { return nil }
+func (f *fakeMailer) SendPasswordResetEmail(toEmail, token string) error { return nil }
+
+func (f *fakeMailer) SendMagicLinkEmail(toEmail, token string) error {
+	f.magicLinks <- token
+	return nil
+}
+
+// requestMagicLink asks for a link and returns the token emailed for it
+func (tt *authTest) requestMagicLink(t *testing.T, email string) string {
+	t.Helper()
+
+	if err := tt.service.RequestMagicLink(email); err != nil {
+		t.Fatalf("RequestMagicLink: %v", err)
+	}
+	select {
+	case token := <-tt.mailer.magicLinks:
+		return token
+	case <-time.After(time.Second):
+		t.Fatal("no magic link emailed")
+		return ""
+	}
+}
+
+func TestMagicLinkSingleUse(t *testing.T) {
+	tt := newAuthTest(t)
+	token := tt.requestMagicLink(t, "new@example.com")
+
+	user, isNew, err := tt.service.SigninWithMagicLink(token)
+	if err != nil {
+		t.Fatalf("SigninWithMagicLink: %v", err)
+	}
+	if !isNew || user.Email != "new@example.com" || !user.EmailVerified {
+		t.Errorf("got user %+v (new: %v), want a new verified account", user, isNew)
+	}
+
+	if _, _, err := tt.service.SigninWithMagicLink(token); !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("reused link: err = %v, want ErrInvalidMagicLink", err)
+	}
+
+	// Only the most recent link for an address works
+	older := tt.requestMagicLink(t, tt.user.Email)
+	newer := tt.requestMagicLink(t, tt.user.Email)
+	if _, _, err := tt.service.SigninWithMagicLink(older); !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("superseded link: err = %v, want ErrInvalidMagicLink", err)
+	}
+	if user, isNew, err := tt.service.SigninWithMagicLink(newer); err != nil || isNew || user.ID != tt.user.ID {
+		t.Errorf("latest link: got %+v (new: %v), %v, want the existing account", user, isNew, err)
+	}
+}
+
+func TestMagicLinkRateLimit(t *testing.T) {
+	tt := newAuthTest(t)
+
+	for i := 0; i < magicLinkLimit; i++ {
+		tt.requestMagicLink(t, "member@example.com")
+	}
+
+	// Over the limit the request quietly succeeds without sending anything
+	if err := tt.service.RequestMagicLink("member@example.com"); err != nil {
+		t.Fatalf("RequestMagicLink over the limit: %v", err)
+	}
+	select {
+	case <-tt.mailer.magicLinks:
+		t.Error("link emailed over the per-address limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if count, _ := tt.magicLinks.CountSince("member@example.com", time.Time{}); count != magicLinkLimit {
+		t.Errorf("stored %d links, want %d", count, magicLinkLimit)
+	}
+
+	// The limit is per address
+	tt.requestMagicLink(t, "other@example.com")
+}
+
+func TestMagicLinkClaimsUnverifiedAccount(t *testing.T) {
+	tt := newAuthTest(t)
+	squatter := tt.users.add(models.User{Email: "claimed@example.com", Status: models.AccountStatusActive})
+	squatter.PasswordHash.String, squatter.PasswordHash.Valid = "hash", true
+	tt.user = squatter
+	_, sessionID := tt.signIn(t)
+
+	user, _, err := tt.service.SigninWithMagicLink(tt.requestMagicLink(t, "claimed@example.com"))
+	if err != nil {
+		t.Fatalf("SigninWithMagicLink: %v", err)
+	}
+	if user.ID != squatter.ID || !user.EmailVerified || user.PasswordHash.Valid {
+		t.Errorf("got user %+v, want the account verified without its password", user)
+	}
+	if stored := tt.users.users[squatter.ID]; !stored.EmailVerified || stored.PasswordHash.Valid {
+		t.Errorf("stored user %+v, want it verified without its password", stored)
+	}
+	if tt.isActive(t, sessionID) {
+		t.Error("the password holder's session survived the claim")
+	}
+}
@@ -1,7 +1,12 @@
 package services
 
 import (
+	"encoding/json"
 	"errors"
+	"log"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 
@@ -15,22 +20,76 @@ var (
 	ErrVerificationRequired   = errors.New("identity verification required to send messages")
 	ErrWealthStatusRequired   = errors.New("subscription required to send messages")
 	ErrMessageNotVisible      = errors.New("message not visible to recipient")
+	ErrRecipientUnavailable   = errors.New("this member is not available right now")
+	ErrInvalidMessageCursor   = errors.New("invalid message cursor")
+	ErrMessageNotFound        = errors.New("message not found")
+	ErrNotMessageSender       = errors.New("only the sender can change this message")
+	ErrMessageDeleted         = errors.New("message has been deleted")
+	ErrEditWindowExpired      = errors.New("messages can only be edited shortly after sending")
+	ErrInvalidReaction        = errors.New("reaction must be a single emoji")
+	ErrInvalidReplyTarget     = errors.New("can only reply to a message in the same conversation")
+	ErrNotParticipant         = errors.New("not a participant in this conversation")
 )
 
+const (
+	// messageEditWindow is how long after sending a message its sender may edit it
+	messageEditWindow = 15 * time.Minute
+	// maxReactionBytes fits the longest emoji sequences, like family ZWJ sequences
+	maxReactionBytes = 32
+)
+
+// messageStore keeps conversations and their messages. It is satisfied by
+// *repository.MessageRepository.
+type messageStore interface {
+	CreateConversation(initiatedBy uuid.UUID, participants []uuid.UUID) (*models.Conversation, error)
+	CreateMessage(conversationID, senderID uuid.UUID, content string, imageURL *string, replyTo *models.Message) (*models.Message, error)
+	DeleteMessage(messageID uuid.UUID) (*models.Message, error)
+	EditMessage(messageID uuid.UUID, content string) (*models.Message, error)
+	FindConversationBetweenUsers(userID1, userID2 uuid.UUID) (*models.Conversation, error)
+	FindMessage(messageID uuid.UUID) (*models.Message, error)
+	GetConversationParticipants(conversationID uuid.UUID) ([]uuid.UUID, error)
+	GetMessageEdits(messageID uuid.UUID) ([]models.MessageEdit, error)
+	GetMessages(conversationID uuid.UUID, before, after *models.MessageCursor, limit int) ([]models.Message, error)
+	GetMessagesSince(userID uuid.UUID, since models.MessageCursor, limit int) ([]models.Message, error)
+	GetUnreadMessageCount(userID uuid.UUID) (int, error)
+	GetUserConversations(userID uuid.UUID) ([]models.ConversationWithDetails, error)
+	IsUserInConversation(conversationID, userID uuid.UUID) (bool, error)
+	MarkDelivered(conversationID, recipientID uuid.UUID, deliveredAt time.Time) (int64, error)
+	MarkDeliveredToUser(recipientID uuid.UUID, deliveredAt time.Time) ([]uuid.UUID, error)
+	MarkReadUpTo(conversationID, readerID uuid.UUID, upTo models.MessageCursor, readAt time.Time) (int64, error)
+}
+
+// chatProfileStore is the part of *repository.ProfileRepository chat uses
+type chatProfileStore interface {
+	FindByUserID(userID uuid.UUID) (*models.Profile, error)
+	GetImages(userID uuid.UUID) ([]models.ProfileImage, error)
+	GetProfileWithDetails(profileUserID, requestingUserID uuid.UUID) (*models.ProfileWithImages, error)
+}
+
+// chatUserStore is the part of *repository.UserRepository chat uses
+type chatUserStore interface {
+	userFinder
+	ReadReceiptsEnabled(userIDs []uuid.UUID) (map[uuid.UUID]bool, error)
+}
+
 type ChatService struct {
-	messageRepo        *repository.MessageRepository
-	profileRepo        *repository.ProfileRepository
-	userRepo           *repository.UserRepository
-	hub                *websocket.Hub
+	messageRepo        messageStore
+	reactionRepo       *repository.ReactionRepository
+	profileRepo        chatProfileStore
+	userRepo           chatUserStore
+	presenceRepo       *repository.PresenceRepository
+	events             *EventService
 	featureFlagService *FeatureFlagService
 }
 
-func NewChatService(messageRepo *repository.MessageRepository, profileRepo *repository.ProfileRepository, userRepo *repository.UserRepository, hub *websocket.Hub, featureFlagService *FeatureFlagService) *ChatService {
+func NewChatService(messageRepo *repository.MessageRepository, reactionRepo *repository.ReactionRepository, profileRepo *repository.ProfileRepository, userRepo *repository.UserRepository, presenceRepo *repository.PresenceRepository, events *EventService, featureFlagService *FeatureFlagService) *ChatService {
 	return &ChatService{
 		messageRepo:        messageRepo,
+		reactionRepo:       reactionRepo,
 		profileRepo:        profileRepo,
 		userRepo:           userRepo,
-		hub:                hub,
+		presenceRepo:       presenceRepo,
+		events:             events,
 		featureFlagService: featureFlagService,
 	}
 }
@@ -77,12 +136,17 @@ func (s *ChatService) CreateConversation(senderID uuid.UUID, req *models.CreateC
 		return nil, errors.New("recipient not found")
 	}
 
+	// Paused accounts keep their existing conversations but can't be contacted anew
+	if recipientUser.Status != models.AccountStatusActive {
+		return nil, ErrRecipientUnavailable
+	}
+
 	conv, err := s.messageRepo.CreateConversation(senderID, []uuid.UUID{senderID, req.RecipientID})
 	if err != nil {
 		return nil, err
 	}
 
-	msg, err := s.messageRepo.CreateMessage(conv.ID, senderID, req.Message, nil)
+	msg, err := s.messageRepo.CreateMessage(conv.ID, senderID, req.Message, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -92,7 +156,7 @@ func (s *ChatService) CreateConversation(senderID uuid.UUID, req *models.CreateC
 	// (male with wealth_status != 'none', or female)
 	recipientCanView := !restrictionsEnabled || recipientProfile.Gender == models.GenderFemale || recipientUser.WealthStatus.CanViewMessages()
 	if recipientCanView {
-		s.hub.BroadcastToUser(req.RecipientID, &models.WSMessage{
+		s.events.SendToUser(req.RecipientID, &models.WSMessage{
 			Type:    models.WSTypeMessage,
 			Payload: msg,
 		})
@@ -122,6 +186,7 @@ func (s *ChatService) GetConversations(userID uuid.UUID) ([]models.ConversationW
 			}
 		}
 	}
+	s.hideReadReceipts(userID, lastMessages(conversations)...)
 
 	return conversations, nil
 }
@@ -165,6 +230,7 @@ func (s *ChatService) GetInbox(userID uuid.UUID) (*models.InboxResponse, error)
 				}
 			}
 		}
+		s.hideReadReceipts(userID, lastMessages(conversations)...)
 		response.Conversations = conversations
 		response.LockedCount = 0
 	} else {
@@ -206,7 +272,9 @@ func (s *ChatService) GetInbox(userID uuid.UUID) (*models.InboxResponse, error)
 			}
 
 			// Create blurred preview (first few words + "...")
-			if conv.LastMessage != nil && conv.LastMessage.Content != "" {
+			if conv.LastMessage != nil && conv.LastMessage.DeletedAt != nil {
+				lockedConv.BlurredPreview = "Message deleted"
+			} else if conv.LastMessage != nil && conv.LastMessage.Content != "" {
 				preview := conv.LastMessage.Content
 				if len(preview) > 30 {
 					preview = preview[:30]
@@ -228,7 +296,7 @@ func (s *ChatService) GetInbox(userID uuid.UUID) (*models.InboxResponse, error)
 	return response, nil
 }
 
-func (s *ChatService) SendMessage(conversationID, senderID uuid.UUID, content string, imageURL *string) (*models.Message, error) {
+func (s *ChatService) SendMessage(conversationID, senderID uuid.UUID, content string, imageURL *string, replyToID *uuid.UUID) (*models.Message, error) {
 	inConv, err := s.messageRepo.IsUserInConversation(conversationID, senderID)
 	if err != nil || !inConv {
 		return nil, errors.New("not authorized to send message in this conversation")
@@ -263,77 +331,582 @@ func (s *ChatService) SendMessage(conversationID, senderID uuid.UUID, content st
 		return nil, ErrWealthStatusRequired
 	}
 
-	msg, err := s.messageRepo.CreateMessage(conversationID, senderID, content, imageURL)
+	// A reply can only quote a message that is still visible in the same conversation
+	var replyTo *models.Message
+	if replyToID != nil {
+		replyTo, err = s.messageRepo.FindMessage(*replyToID)
+		if err != nil {
+			return nil, err
+		}
+		if replyTo == nil || replyTo.ConversationID != conversationID {
+			return nil, ErrInvalidReplyTarget
+		}
+		if replyTo.DeletedAt != nil {
+			return nil, ErrMessageDeleted
+		}
+	}
+
+	msg, err := s.messageRepo.CreateMessage(conversationID, senderID, content, imageURL, replyTo)
 	if err != nil {
 		return nil, err
 	}
 
+	recipients := s.broadcastToRecipients(conversationID, senderID, &models.WSMessage{
+		Type:    models.WSTypeMessage,
+		Payload: msg,
+	})
+
+	// Recipients connected to any server get the message right away. The rest
+	// have it marked delivered when they next fetch or sync.
+	connected, err := s.presenceRepo.ConnectedUsers(recipients)
+	if err != nil {
+		log.Printf("[Chat] Failed to look up connected recipients: %v", err)
+	}
+	for _, recipientID := range recipients {
+		if connected[recipientID] {
+			s.markDelivered(conversationID, recipientID)
+		}
+	}
+
+	return msg, nil
+}
+
+// broadcastToRecipients sends a chat event to every other participant who is
+// allowed to read the conversation's messages, and returns them
+func (s *ChatService) broadcastToRecipients(conversationID, senderID uuid.UUID, event *models.WSMessage) []uuid.UUID {
+	// Check if restrictions are enabled
+	restrictionsEnabled := s.featureFlagService.RestrictionsEnabled()
+
+	var recipients []uuid.UUID
 	participants, _ := s.messageRepo.GetConversationParticipants(conversationID)
 	for _, participantID := range participants {
-		if participantID != senderID {
-			// Get recipient info to check if they can view this message
-			recipientProfile, _ := s.profileRepo.FindByUserID(participantID)
-			recipientUser, _ := s.userRepo.FindByID(participantID)
-
-			// When restrictions disabled, everyone can view
-			// Otherwise: only broadcast if recipient can view (Females can always view, males need wealth_status)
-			recipientCanView := !restrictionsEnabled || (recipientProfile != nil && (
-				recipientProfile.Gender == models.GenderFemale ||
-				(recipientUser != nil && recipientUser.WealthStatus.CanViewMessages())))
-
-			if recipientCanView {
-				s.hub.BroadcastToUser(participantID, &models.WSMessage{
-					Type:    models.WSTypeMessage,
-					Payload: msg,
-				})
-			}
+		if participantID != senderID && s.canViewMessages(participantID, restrictionsEnabled) {
+			s.events.SendToUser(participantID, event)
+			recipients = append(recipients, participantID)
 		}
 	}
+	return recipients
+}
 
+// canViewMessages reports whether the user may read message contents
+func (s *ChatService) canViewMessages(userID uuid.UUID, restrictionsEnabled bool) bool {
+	// When restrictions disabled, everyone can view
+	if !restrictionsEnabled {
+		return true
+	}
+
+	// Otherwise: Females can always view, males need wealth_status
+	profile, _ := s.profileRepo.FindByUserID(userID)
+	if profile == nil {
+		return false
+	}
+	if profile.Gender == models.GenderFemale {
+		return true
+	}
+	user, _ := s.userRepo.FindByID(userID)
+	return user != nil && user.WealthStatus.CanViewMessages()
+}
+
+// findOwnMessage loads a message in the conversation and checks the user sent it
+func (s *ChatService) findOwnMessage(conversationID, messageID, userID uuid.UUID) (*models.Message, error) {
+	msg, err := s.messageRepo.FindMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil || msg.ConversationID != conversationID {
+		return nil, ErrMessageNotFound
+	}
+	if msg.SenderID != userID {
+		return nil, ErrNotMessageSender
+	}
+	if msg.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
 	return msg, nil
 }
 
-func (s *ChatService) GetMessages(conversationID, userID uuid.UUID, limit, offset int) ([]models.Message, error) {
+// EditMessage replaces the text of one of the user's messages. Edits are only
+// allowed for a short window after sending; earlier versions are kept.
+func (s *ChatService) EditMessage(conversationID, messageID, userID uuid.UUID, content string) (*models.Message, error) {
+	msg, err := s.findOwnMessage(conversationID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Since(msg.CreatedAt) > messageEditWindow {
+		return nil, ErrEditWindowExpired
+	}
+
+	// Same rule as sending: a message needs text or an image
+	if content == "" && (msg.ImageURL == nil || *msg.ImageURL == "") {
+		return nil, errors.New("message must contain text or an image")
+	}
+
+	if content == msg.Content {
+		return msg, nil
+	}
+
+	edited, err := s.messageRepo.EditMessage(messageID, content)
+	if err != nil {
+		return nil, err
+	}
+	if edited == nil {
+		return nil, ErrMessageDeleted
+	}
+
+	s.broadcastToRecipients(conversationID, userID, &models.WSMessage{
+		Type:    models.WSTypeMessageEdit,
+		Payload: edited,
+	})
+
+	s.hideReadReceipts(userID, edited)
+	return edited, nil
+}
+
+// DeleteMessage deletes one of the user's messages for everyone, leaving a
+// tombstone in its place
+func (s *ChatService) DeleteMessage(conversationID, messageID, userID uuid.UUID) (*models.Message, error) {
+	if _, err := s.findOwnMessage(conversationID, messageID, userID); err != nil {
+		return nil, err
+	}
+
+	deleted, err := s.messageRepo.DeleteMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if deleted == nil {
+		return nil, ErrMessageDeleted
+	}
+
+	s.broadcastToRecipients(conversationID, userID, &models.WSMessage{
+		Type:    models.WSTypeMessageDelete,
+		Payload: deleted,
+	})
+
+	s.hideReadReceipts(userID, deleted)
+	return deleted, nil
+}
+
+// GetMessageEdits returns the earlier versions of a message to participants
+// of its conversation
+func (s *ChatService) GetMessageEdits(conversationID, messageID, userID uuid.UUID) ([]models.MessageEdit, error) {
 	inConv, err := s.messageRepo.IsUserInConversation(conversationID, userID)
 	if err != nil || !inConv {
 		return nil, errors.New("not authorized to view this conversation")
 	}
 
-	s.messageRepo.MarkMessagesAsRead(conversationID, userID)
+	msg, err := s.messageRepo.FindMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil || msg.ConversationID != conversationID {
+		return nil, ErrMessageNotFound
+	}
+
+	return s.messageRepo.GetMessageEdits(messageID)
+}
+
+// AddReaction reacts to a message with an emoji. Reacting needs the same
+// access as reading the message, and only recipients allowed to read it are
+// told about the reaction.
+func (s *ChatService) AddReaction(conversationID, messageID, userID uuid.UUID, emoji string) error {
+	return s.setReaction(conversationID, messageID, userID, emoji, true)
+}
+
+// RemoveReaction takes back one of the user's reactions
+func (s *ChatService) RemoveReaction(conversationID, messageID, userID uuid.UUID, emoji string) error {
+	return s.setReaction(conversationID, messageID, userID, emoji, false)
+}
+
+func (s *ChatService) setReaction(conversationID, messageID, userID uuid.UUID, emoji string, add bool) error {
+	if !validReactionEmoji(emoji) {
+		return ErrInvalidReaction
+	}
+
+	inConv, err := s.messageRepo.IsUserInConversation(conversationID, userID)
+	if err != nil || !inConv {
+		return errors.New("not authorized to view this conversation")
+	}
+
+	if !s.canViewMessages(userID, s.featureFlagService.RestrictionsEnabled()) {
+		return ErrMessageNotVisible
+	}
+
+	msg, err := s.messageRepo.FindMessage(messageID)
+	if err != nil {
+		return err
+	}
+	if msg == nil || msg.ConversationID != conversationID {
+		return ErrMessageNotFound
+	}
+	if msg.DeletedAt != nil {
+		return ErrMessageDeleted
+	}
+
+	var changed bool
+	if add {
+		changed, err = s.reactionRepo.Add(messageID, userID, emoji)
+	} else {
+		changed, err = s.reactionRepo.Remove(messageID, userID, emoji)
+	}
+	if err != nil || !changed {
+		return err
+	}
+
+	s.broadcastToRecipients(conversationID, userID, &models.WSMessage{
+		Type: models.WSTypeReaction,
+		Payload: models.WSReactionPayload{
+			ConversationID: conversationID,
+			MessageID:      messageID,
+			UserID:         userID,
+			Emoji:          emoji,
+			Added:          add,
+		},
+	})
+
+	return nil
+}
+
+// validReactionEmoji accepts short strings made only of non-ASCII characters,
+// which covers emoji including skin tones and ZWJ sequences but keeps out text
+func validReactionEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxReactionBytes || !utf8.ValidString(emoji) {
+		return false
+	}
+	for _, r := range emoji {
+		if r < utf8.RuneSelf || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// attachReactions fills in the reaction counts on each message as seen by the viewer
+func (s *ChatService) attachReactions(messages []models.Message, viewerID uuid.UUID) error {
+	ids := make([]uuid.UUID, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+
+	counts, err := s.reactionRepo.CountsForMessages(ids, viewerID)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Reactions = counts[messages[i].ID]
+	}
+	return nil
+}
+
+// GetMessages returns a page of the conversation, newest first. before and
+// after are optional cursors taken from messages the client already has.
+func (s *ChatService) GetMessages(conversationID, userID uuid.UUID, before, after string, limit int) (*models.MessagePage, error) {
+	inConv, err := s.messageRepo.IsUserInConversation(conversationID, userID)
+	if err != nil || !inConv {
+		return nil, errors.New("not authorized to view this conversation")
+	}
+
+	var beforeCursor, afterCursor *models.MessageCursor
+	if before != "" {
+		cursor, ok := models.ParseMessageCursor(before)
+		if !ok {
+			return nil, ErrInvalidMessageCursor
+		}
+		beforeCursor = &cursor
+	}
+	if after != "" {
+		cursor, ok := models.ParseMessageCursor(after)
+		if !ok {
+			return nil, ErrInvalidMessageCursor
+		}
+		afterCursor = &cursor
+	}
+	if beforeCursor != nil && afterCursor != nil {
+		return nil, ErrInvalidMessageCursor
+	}
+
+	// Fetching the thread puts everything in it on the reader's device, but
+	// reading is only recorded when the client says so with mark_read. Users
+	// who can't read the messages yet don't count as having them.
+	if s.canViewMessages(userID, s.featureFlagService.RestrictionsEnabled()) {
+		s.markDelivered(conversationID, userID)
+	}
 
 	if limit < 1 || limit > 100 {
 		limit = 50
 	}
 
-	return s.messageRepo.GetMessages(conversationID, limit, offset)
+	// Fetch one extra row to learn whether another page exists
+	messages, err := s.messageRepo.GetMessages(conversationID, beforeCursor, afterCursor, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &models.MessagePage{Messages: messages}
+	if len(messages) > limit {
+		page.HasMore = true
+		if afterCursor != nil {
+			// Forward pages are flipped to newest first, so the extra row is at the front
+			page.Messages = messages[1:]
+		} else {
+			page.Messages = messages[:limit]
+		}
+	}
+
+	if err := s.attachReactions(page.Messages, userID); err != nil {
+		return nil, err
+	}
+	s.hideReadReceipts(userID, messagePointers(page.Messages)...)
+
+	return page, nil
+}
+
+// SyncMessages returns what changed in all of the user's conversations after
+// the since cursor: new messages, and earlier ones that were edited, deleted or
+// reacted to. Clients call it after the WebSocket reconnects and keep calling
+// with the returned cursor while has_more is set.
+func (s *ChatService) SyncMessages(userID uuid.UUID, since string, limit int) (*models.MessageSync, error) {
+	cursor, ok := models.ParseMessageCursor(since)
+	if !ok {
+		return nil, ErrInvalidMessageCursor
+	}
+
+	if limit < 1 || limit > 500 {
+		limit = 200
+	}
+
+	// Messages the user can't read yet stay undelivered, as they do when
+	// broadcastToRecipients skips them
+	if s.canViewMessages(userID, s.featureFlagService.RestrictionsEnabled()) {
+		now := time.Now().UTC()
+		delivered, err := s.messageRepo.MarkDeliveredToUser(userID, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, conversationID := range delivered {
+			s.sendDeliveryReceipt(conversationID, userID, now)
+		}
+	}
+
+	messages, err := s.messageRepo.GetMessagesSince(userID, cursor, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.attachReactions(messages, userID); err != nil {
+		return nil, err
+	}
+	s.hideReadReceipts(userID, messagePointers(messages)...)
+
+	return newMessageSync(messages, cursor, since, limit), nil
 }
 
+// newMessageSync splits up to limit messages in (updated_at, id) order into
+// those sent after the cursor and earlier ones changed since. The next cursor
+// points at the last one returned.
+func newMessageSync(messages []models.Message, cursor models.MessageCursor, since string, limit int) *models.MessageSync {
+	sync := &models.MessageSync{Messages: []models.Message{}, Updated: []models.Message{}, Cursor: since}
+	if len(messages) > limit {
+		sync.HasMore = true
+		messages = messages[:limit]
+	}
+
+	for _, msg := range messages {
+		if models.CursorFor(&msg).After(cursor) {
+			sync.Messages = append(sync.Messages, msg)
+		} else {
+			sync.Updated = append(sync.Updated, msg)
+		}
+	}
+	if len(messages) > 0 {
+		sync.Cursor = models.SyncCursorFor(&messages[len(messages)-1]).Encode()
+	}
+
+	return sync
+}
+
+// BroadcastTyping shows or hides the user's typing indicator to the other
+// participants who can read the conversation
 func (s *ChatService) BroadcastTyping(conversationID, userID uuid.UUID, isTyping bool) error {
-	participants, err := s.messageRepo.GetConversationParticipants(conversationID)
+	inConv, err := s.messageRepo.IsUserInConversation(conversationID, userID)
 	if err != nil {
 		return err
 	}
+	if !inConv {
+		return ErrNotParticipant
+	}
 
 	msgType := models.WSTypeTyping
 	if !isTyping {
 		msgType = models.WSTypeStopTyping
 	}
 
+	s.broadcastToRecipients(conversationID, userID, &models.WSMessage{
+		Type: msgType,
+		Payload: models.WSTypingPayload{
+			ConversationID: conversationID,
+			UserID:         userID,
+		},
+	})
+
+	return nil
+}
+
+func (s *ChatService) GetUnreadCount(userID uuid.UUID) (int, error) {
+	return s.messageRepo.GetUnreadMessageCount(userID)
+}
+
+// RegisterCommands routes the chat commands clients send over WebSocket
+func (s *ChatService) RegisterCommands(d *websocket.Dispatcher) {
+	d.Register(models.WSTypeTyping, func(client *websocket.Client, payload json.RawMessage) error {
+		var cmd models.WSTypingCommandPayload
+		if err := websocket.DecodePayload(payload, &cmd); err != nil {
+			return err
+		}
+		return s.BroadcastTyping(cmd.ConversationID, client.UserID, true)
+	})
+	d.Register(models.WSTypeStopTyping, func(client *websocket.Client, payload json.RawMessage) error {
+		var cmd models.WSTypingCommandPayload
+		if err := websocket.DecodePayload(payload, &cmd); err != nil {
+			return err
+		}
+		return s.BroadcastTyping(cmd.ConversationID, client.UserID, false)
+	})
+	d.Register(models.WSTypeMarkRead, func(client *websocket.Client, payload json.RawMessage) error {
+		var cmd models.WSMarkReadPayload
+		if err := websocket.DecodePayload(payload, &cmd); err != nil {
+			return err
+		}
+		return s.MarkRead(cmd.ConversationID, client.UserID, cmd.MessageID)
+	})
+}
+
+// MarkRead records that the user has read everything the other side sent in
+// the conversation up to and including messageID, and tells the senders unless
+// either side has turned read receipts off
+func (s *ChatService) MarkRead(conversationID, userID, messageID uuid.UUID) error {
+	inConv, err := s.messageRepo.IsUserInConversation(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if !inConv {
+		return ErrNotParticipant
+	}
+
+	msg, err := s.messageRepo.FindMessage(messageID)
+	if err != nil {
+		return err
+	}
+	if msg == nil || msg.ConversationID != conversationID {
+		return ErrMessageNotFound
+	}
+
+	readAt := time.Now().UTC()
+	changed, err := s.messageRepo.MarkReadUpTo(conversationID, userID, models.CursorFor(msg), readAt)
+	if err != nil || changed == 0 {
+		return err
+	}
+
+	participants, err := s.messageRepo.GetConversationParticipants(conversationID)
+	if err != nil {
+		return err
+	}
+	enabled, err := s.userRepo.ReadReceiptsEnabled(participants)
+	if err != nil {
+		return err
+	}
+	if !enabled[userID] {
+		return nil
+	}
+
 	for _, participantID := range participants {
-		if participantID != userID {
-			s.hub.BroadcastToUser(participantID, &models.WSMessage{
-				Type: msgType,
-				Payload: models.WSTypingPayload{
+		if participantID != userID && enabled[participantID] {
+			s.events.SendToUser(participantID, &models.WSMessage{
+				Type: models.WSTypeReadReceipt,
+				Payload: models.WSReadReceiptPayload{
 					ConversationID: conversationID,
 					UserID:         userID,
+					MessageID:      messageID,
+					ReadAt:         readAt,
 				},
 			})
 		}
 	}
-
 	return nil
 }
 
-func (s *ChatService) GetUnreadCount(userID uuid.UUID) (int, error) {
-	return s.messageRepo.GetUnreadMessageCount(userID)
+// markDelivered records that the recipient has the conversation's messages
+// and tells the senders
+func (s *ChatService) markDelivered(conversationID, recipientID uuid.UUID) {
+	now := time.Now().UTC()
+	changed, err := s.messageRepo.MarkDelivered(conversationID, recipientID, now)
+	if err != nil {
+		log.Printf("[Chat] Failed to mark messages delivered in %s: %v", conversationID, err)
+		return
+	}
+	if changed > 0 {
+		s.sendDeliveryReceipt(conversationID, recipientID, now)
+	}
+}
+
+func (s *ChatService) sendDeliveryReceipt(conversationID, recipientID uuid.UUID, deliveredAt time.Time) {
+	participants, _ := s.messageRepo.GetConversationParticipants(conversationID)
+	for _, participantID := range participants {
+		if participantID != recipientID {
+			s.events.SendToUser(participantID, &models.WSMessage{
+				Type: models.WSTypeDelivery,
+				Payload: models.WSDeliveryReceiptPayload{
+					ConversationID: conversationID,
+					UserID:         recipientID,
+					DeliveredAt:    deliveredAt,
+				},
+			})
+		}
+	}
+}
+
+// hideReadReceipts clears read_at on the viewer's own messages in
+// conversations where anyone has turned read receipts off
+func (s *ChatService) hideReadReceipts(viewerID uuid.UUID, messages ...*models.Message) {
+	hidden := make(map[uuid.UUID]bool)
+	for _, msg := range messages {
+		if msg == nil || msg.SenderID != viewerID || msg.ReadAt == nil {
+			continue
+		}
+		hide, checked := hidden[msg.ConversationID]
+		if !checked {
+			hide = true
+			participants, err := s.messageRepo.GetConversationParticipants(msg.ConversationID)
+			if err == nil {
+				if enabled, err := s.userRepo.ReadReceiptsEnabled(participants); err == nil {
+					hide = false
+					for _, participantID := range participants {
+						if !enabled[participantID] {
+							hide = true
+						}
+					}
+				}
+			}
+			hidden[msg.ConversationID] = hide
+		}
+		if hide {
+			msg.ReadAt = nil
+		}
+	}
+}
+
+func lastMessages(conversations []models.ConversationWithDetails) []*models.Message {
+	messages := make([]*models.Message, len(conversations))
+	for i := range conversations {
+		messages[i] = conversations[i].LastMessage
+	}
+	return messages
+}
+
+func messagePointers(messages []models.Message) []*models.Message {
+	pointers := make([]*models.Message, len(messages))
+	for i := range messages {
+		pointers[i] = &messages[i]
+	}
+	return pointers
 }
@@ -0,0 +1,352 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+)
+
+func TestNewMessageSync(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	message := func(created, updated time.Duration) models.Message {
+		return models.Message{ID: uuid.New(), CreatedAt: base.Add(created), UpdatedAt: base.Add(updated)}
+	}
+
+	// The client last saw a message sent at +10s
+	seen := message(10*time.Second, 10*time.Second)
+	cursor := models.CursorFor(&seen)
+	since := cursor.Encode()
+
+	// In (updated_at, id) order, as the repository returns them
+	editedEarlier := message(0, 20*time.Second) // sent before, edited while offline
+	sentWhileAway := message(30*time.Second, 30*time.Second)
+	sentThenDeleted := message(35*time.Second, 40*time.Second)
+	reactedEarlier := message(5*time.Second, 50*time.Second)
+	messages := []models.Message{editedEarlier, sentWhileAway, sentThenDeleted, reactedEarlier}
+
+	sync := newMessageSync(messages, cursor, since, 10)
+	if sync.HasMore {
+		t.Error("HasMore = true, want false")
+	}
+	assertIDs(t, "messages", sync.Messages, sentWhileAway, sentThenDeleted)
+	assertIDs(t, "updated", sync.Updated, editedEarlier, reactedEarlier)
+	if want := models.SyncCursorFor(&reactedEarlier).Encode(); sync.Cursor != want {
+		t.Errorf("cursor = %q, want the last change %q", sync.Cursor, want)
+	}
+
+	// A full page stops at the limit and picks up from its last change
+	sync = newMessageSync(messages, cursor, since, 2)
+	if !sync.HasMore {
+		t.Error("HasMore = false, want true")
+	}
+	assertIDs(t, "messages", sync.Messages, sentWhileAway)
+	assertIDs(t, "updated", sync.Updated, editedEarlier)
+	next, ok := models.ParseMessageCursor(sync.Cursor)
+	if !ok || !next.CreatedAt.Equal(sentWhileAway.UpdatedAt) || next.ID != sentWhileAway.ID {
+		t.Errorf("cursor = %+v, want one pointing at the second message", next)
+	}
+
+	// Nothing changed: the cursor stays put
+	sync = newMessageSync(nil, cursor, since, 10)
+	if sync.Cursor != since || len(sync.Messages) != 0 || len(sync.Updated) != 0 {
+		t.Errorf("empty sync = %+v, want no messages and the same cursor", sync)
+	}
+}
+
+func TestSyncCursorMatchesLiveCursor(t *testing.T) {
+	// A message nobody changed has the same position in both orderings, so a
+	// client can sync from the cursor of the last message it got live
+	created := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	msg := models.Message{ID: uuid.New(), CreatedAt: created, UpdatedAt: created}
+	if models.CursorFor(&msg) != models.SyncCursorFor(&msg) {
+		t.Error("cursor of an unchanged message differs between pagination and sync")
+	}
+}
+
+func assertIDs(t *testing.T, name string, got []models.Message, want ...models.Message) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d messages, want %d", name, len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID {
+			t.Errorf("%s[%d] = %s, want %s", name, i, got[i].ID, want[i].ID)
+		}
+	}
+}
+
+// fakeMessageStore keeps messages in memory. Methods the tests don't reach
+// are left to the embedded nil interface.
+type fakeMessageStore struct {
+	messageStore
+	messages     map[uuid.UUID]*models.Message
+	participants map[uuid.UUID][]uuid.UUID
+}
+
+func (f *fakeMessageStore) FindMessage(messageID uuid.UUID) (*models.Message, error) {
+	if msg, ok := f.messages[messageID]; ok {
+		copied := *msg
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeMessageStore) EditMessage(messageID uuid.UUID, content string) (*models.Message, error) {
+	msg := f.messages[messageID]
+	if msg == nil || msg.DeletedAt != nil {
+		return nil, nil
+	}
+	msg.Content = content
+	return f.FindMessage(messageID)
+}
+
+func (f *fakeMessageStore) DeleteMessage(messageID uuid.UUID) (*models.Message, error) {
+	msg := f.messages[messageID]
+	if msg == nil || msg.DeletedAt != nil {
+		return nil, nil
+	}
+	now := time.Now()
+	msg.Content, msg.DeletedAt = "", &now
+	return f.FindMessage(messageID)
+}
+
+func (f *fakeMessageStore) GetConversationParticipants(conversationID uuid.UUID) ([]uuid.UUID, error) {
+	return f.participants[conversationID], nil
+}
+
+func (f *fakeMessageStore) add(msg models.Message) *models.Message {
+	msg.ID = uuid.New()
+	f.messages[msg.ID] = &msg
+	return &msg
+}
+
+func (f *fakeMessageStore) IsUserInConversation(conversationID, userID uuid.UUID) (bool, error) {
+	for _, participantID := range f.participants[conversationID] {
+		if participantID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fakeChatProfiles has a profile for every user
+type fakeChatProfiles struct {
+	chatProfileStore
+}
+
+func (fakeChatProfiles) FindByUserID(userID uuid.UUID) (*models.Profile, error) {
+	return &models.Profile{UserID: userID, Gender: models.GenderFemale}, nil
+}
+
+// fakeChatUsers has an account for every user, with read receipts on unless
+// they are listed in receiptsOff
+type fakeChatUsers struct {
+	receiptsOff map[uuid.UUID]bool
+}
+
+func (f *fakeChatUsers) FindByID(id uuid.UUID) (*models.User, error) {
+	return &models.User{ID: id}, nil
+}
+
+func (f *fakeChatUsers) ReadReceiptsEnabled(userIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	enabled := make(map[uuid.UUID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		enabled[userID] = !f.receiptsOff[userID]
+	}
+	return enabled, nil
+}
+
+func newTestChatService(messages *fakeMessageStore, users *fakeChatUsers) *ChatService {
+	return &ChatService{
+		messageRepo:        messages,
+		profileRepo:        fakeChatProfiles{},
+		userRepo:           users,
+		featureFlagService: &FeatureFlagService{cache: map[string]bool{models.FlagRestrictionsEnabled: false}},
+	}
+}
+
+func TestEditMessageWindow(t *testing.T) {
+	senderID, conversationID := uuid.New(), uuid.New()
+	// Only the sender is in the conversation, so an edit has nobody to reach
+	messages := &fakeMessageStore{
+		messages:     make(map[uuid.UUID]*models.Message),
+		participants: map[uuid.UUID][]uuid.UUID{conversationID: {senderID}},
+	}
+	service := newTestChatService(messages, &fakeChatUsers{})
+
+	tests := []struct {
+		name string
+		age  time.Duration
+		err  error
+	}{
+		{"just sent", 0, nil},
+		{"inside the window", messageEditWindow - time.Minute, nil},
+		{"past the window", messageEditWindow + time.Minute, ErrEditWindowExpired},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := messages.add(models.Message{
+				ConversationID: conversationID,
+				SenderID:       senderID,
+				Content:        "hello",
+				CreatedAt:      time.Now().Add(-tc.age),
+			})
+
+			edited, err := service.EditMessage(conversationID, msg.ID, senderID, "hello again")
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("err = %v, want %v", err, tc.err)
+			}
+			if tc.err == nil && edited.Content != "hello again" {
+				t.Errorf("content = %q, want the edit", edited.Content)
+			}
+			if tc.err != nil && messages.messages[msg.ID].Content != "hello" {
+				t.Errorf("content = %q, want it unchanged", messages.messages[msg.ID].Content)
+			}
+		})
+	}
+}
+
+func TestChangeSomeoneElsesMessage(t *testing.T) {
+	senderID, otherID, conversationID := uuid.New(), uuid.New(), uuid.New()
+	messages := &fakeMessageStore{
+		messages:     make(map[uuid.UUID]*models.Message),
+		participants: map[uuid.UUID][]uuid.UUID{conversationID: {senderID, otherID}},
+	}
+	service := newTestChatService(messages, &fakeChatUsers{})
+	msg := messages.add(models.Message{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Content:        "hello",
+		CreatedAt:      time.Now(),
+	})
+
+	if _, err := service.EditMessage(conversationID, msg.ID, otherID, "changed"); !errors.Is(err, ErrNotMessageSender) {
+		t.Errorf("edit: err = %v, want ErrNotMessageSender", err)
+	}
+	if _, err := service.DeleteMessage(conversationID, msg.ID, otherID); !errors.Is(err, ErrNotMessageSender) {
+		t.Errorf("delete: err = %v, want ErrNotMessageSender", err)
+	}
+	if stored := messages.messages[msg.ID]; stored.Content != "hello" || stored.DeletedAt != nil {
+		t.Errorf("message = %+v, want it untouched", stored)
+	}
+
+	// Outside its own conversation the message doesn't exist
+	if _, err := service.DeleteMessage(uuid.New(), msg.ID, senderID); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("delete from another conversation: err = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestValidReactionEmoji(t *testing.T) {
+	tests := []struct {
+		emoji string
+		valid bool
+	}{
+		{"👍", true},
+		{"❤️", true},
+		{"👍🏽", true},      // skin tone modifier
+		{"👨‍👩‍👧‍👦", true}, // ZWJ family sequence
+		{"🇳🇱", true},      // flag
+		{"", false},
+		{"a", false},
+		{"ok", false},
+		{"1", false},
+		{":)", false},
+		{"👍 ", false}, // trailing space
+		{"👍a", false},
+		{"é", false},        // a letter, though not ASCII
+		{"٣", false},        // a digit, though not ASCII
+		{"\u00a0", false},   // non-breaking space
+		{"\xff\xfe", false}, // invalid UTF-8
+		{"👍👍👍👍👍👍👍👍👍", false}, // over maxReactionBytes
+	}
+
+	for _, tc := range tests {
+		if got := validReactionEmoji(tc.emoji); got != tc.valid {
+			t.Errorf("validReactionEmoji(%q) = %v, want %v", tc.emoji, got, tc.valid)
+		}
+	}
+}
+
+func TestSendMessageReplyTarget(t *testing.T) {
+	senderID, recipientID := uuid.New(), uuid.New()
+	conversationID, otherConversationID := uuid.New(), uuid.New()
+	messages := &fakeMessageStore{
+		messages: make(map[uuid.UUID]*models.Message),
+		participants: map[uuid.UUID][]uuid.UUID{
+			conversationID:      {senderID, recipientID},
+			otherConversationID: {senderID, uuid.New()},
+		},
+	}
+	service := newTestChatService(messages, &fakeChatUsers{})
+
+	deletedAt := time.Now()
+	elsewhere := messages.add(models.Message{ConversationID: otherConversationID, SenderID: senderID, Content: "elsewhere"})
+	deleted := messages.add(models.Message{ConversationID: conversationID, SenderID: recipientID, DeletedAt: &deletedAt})
+	missing := uuid.New()
+
+	tests := []struct {
+		name    string
+		replyTo uuid.UUID
+		err     error
+	}{
+		{"message in another conversation", elsewhere.ID, ErrInvalidReplyTarget},
+		{"unknown message", missing, ErrInvalidReplyTarget},
+		{"deleted message", deleted.ID, ErrMessageDeleted},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := service.SendMessage(conversationID, senderID, "quoting", nil, &tc.replyTo); !errors.Is(err, tc.err) {
+				t.Errorf("err = %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestHideReadReceipts(t *testing.T) {
+	viewerID, partnerID := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name        string
+		receiptsOff []uuid.UUID
+		hidden      bool
+	}{
+		{"both have receipts on", nil, false},
+		{"viewer turned them off", []uuid.UUID{viewerID}, true},
+		{"partner turned them off", []uuid.UUID{partnerID}, true},
+		{"both turned them off", []uuid.UUID{viewerID, partnerID}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conversationID := uuid.New()
+			messages := &fakeMessageStore{
+				participants: map[uuid.UUID][]uuid.UUID{conversationID: {viewerID, partnerID}},
+			}
+			users := &fakeChatUsers{receiptsOff: make(map[uuid.UUID]bool)}
+			for _, userID := range tc.receiptsOff {
+				users.receiptsOff[userID] = true
+			}
+			service := newTestChatService(messages, users)
+
+			readAt := time.Now()
+			own := &models.Message{ConversationID: conversationID, SenderID: viewerID, ReadAt: &readAt}
+			theirs := &models.Message{ConversationID: conversationID, SenderID: partnerID, ReadAt: &readAt}
+			service.hideReadReceipts(viewerID, own, theirs)
+
+			if hidden := own.ReadAt == nil; hidden != tc.hidden {
+				t.Errorf("own message read_at hidden = %v, want %v", hidden, tc.hidden)
+			}
+			// What the viewer read is theirs to know either way
+			if theirs.ReadAt == nil {
+				t.Error("read_at cleared on the partner's message")
+			}
+		})
+	}
+}
@@ -0,0 +1,464 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/internal/repository"
+	"heyspoilme/pkg/email"
+	"heyspoilme/pkg/storage"
+)
+
+const (
+	// How long a finished archive can be downloaded before it is deleted
+	dataExportTTL = 72 * time.Hour
+	// Users can request a new archive this often
+	dataExportCooldown = 24 * time.Hour
+	// A processing export older than this is assumed abandoned and retried
+	dataExportStaleAfter = 1 * time.Hour
+	exportPageSize       = 500
+)
+
+var (
+	ErrExportUnavailable = errors.New("data export is not available")
+	ErrExportInProgress  = errors.New("an export is already being prepared")
+	ErrExportTooSoon     = errors.New("you can request one export per day")
+)
+
+// DataExportService builds ZIP archives of everything stored about a user. Requests
+// are queued in the database and picked up by a background worker, which uploads
+// the archive to S3 and emails a time-limited download link.
+type DataExportService struct {
+	exportRepo       *repository.DataExportRepository
+	userRepo         *repository.UserRepository
+	profileRepo      *repository.ProfileRepository
+	likeRepo         *repository.LikeRepository
+	messageRepo      *repository.MessageRepository
+	notificationRepo *repository.NotificationRepository
+	verificationRepo *repository.VerificationRepository
+	presenceRepo     *repository.PresenceRepository
+	sessionRepo      *repository.SessionRepository
+	s3Client         *storage.S3Client
+	emailClient      *email.ZeptoMailClient
+	interval         time.Duration
+	stopChan         chan struct{}
+}
+
+func NewDataExportService(
+	exportRepo *repository.DataExportRepository,
+	userRepo *repository.UserRepository,
+	profileRepo *repository.ProfileRepository,
+	likeRepo *repository.LikeRepository,
+	messageRepo *repository.MessageRepository,
+	notificationRepo *repository.NotificationRepository,
+	verificationRepo *repository.VerificationRepository,
+	presenceRepo *repository.PresenceRepository,
+	sessionRepo *repository.SessionRepository,
+	s3Client *storage.S3Client,
+	emailClient *email.ZeptoMailClient,
+) *DataExportService {
+	return &DataExportService{
+		exportRepo:       exportRepo,
+		userRepo:         userRepo,
+		profileRepo:      profileRepo,
+		likeRepo:         likeRepo,
+		messageRepo:      messageRepo,
+		notificationRepo: notificationRepo,
+		verificationRepo: verificationRepo,
+		presenceRepo:     presenceRepo,
+		sessionRepo:      sessionRepo,
+		s3Client:         s3Client,
+		emailClient:      emailClient,
+		interval:         30 * time.Second,
+		stopChan:         make(chan struct{}),
+	}
+}
+
+// RequestExport queues a new archive for the user
+func (s *DataExportService) RequestExport(userID uuid.UUID) (*models.DataExport, error) {
+	if s.s3Client == nil {
+		return nil, ErrExportUnavailable
+	}
+
+	latest, err := s.exportRepo.FindLatestForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil {
+		switch {
+		case latest.Status == models.DataExportStatusPending || latest.Status == models.DataExportStatusProcessing:
+			return latest, ErrExportInProgress
+		case latest.Status != models.DataExportStatusFailed && time.Since(latest.CreatedAt) < dataExportCooldown:
+			return latest, ErrExportTooSoon
+		}
+	}
+
+	return s.exportRepo.Create(userID)
+}
+
+// GetLatestExport returns the user's most recent export, with a fresh download
+// link if the archive is still available
+func (s *DataExportService) GetLatestExport(userID uuid.UUID) (*models.DataExport, error) {
+	export, err := s.exportRepo.FindLatestForUser(userID)
+	if err != nil || export == nil {
+		return export, err
+	}
+
+	if export.Status == models.DataExportStatusReady && export.ExpiresAt != nil && s.s3Client != nil {
+		remaining := time.Until(*export.ExpiresAt)
+		if remaining <= 0 {
+			export.Status = models.DataExportStatusExpired
+			return export, nil
+		}
+		export.DownloadURL, err = s.s3Client.GetPresignedDownloadURL(export.S3Key, exportFilename(export), remaining)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return export, nil
+}
+
+// Start begins the background worker that builds queued archives and removes expired ones
+func (s *DataExportService) Start() {
+	if s.s3Client == nil {
+		log.Printf("[DataExport] S3 not configured, skipping data export worker")
+		return
+	}
+
+	log.Printf("[DataExport] Starting data export worker (checking every %v)", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.processPending()
+			s.deleteExpired()
+		case <-s.stopChan:
+			log.Printf("[DataExport] Stopping data export worker")
+			return
+		}
+	}
+}
+
+// Stop stops the background worker
+func (s *DataExportService) Stop() {
+	close(s.stopChan)
+}
+
+func (s *DataExportService) processPending() {
+	for {
+		export, err := s.exportRepo.ClaimNext(dataExportStaleAfter)
+		if err != nil {
+			log.Printf("[DataExport] Error claiming export: %v", err)
+			return
+		}
+		if export == nil {
+			return
+		}
+
+		if err := s.buildExport(export); err != nil {
+			log.Printf("[DataExport] Export %s for user %s failed: %v", export.ID, export.UserID, err)
+			if err := s.exportRepo.MarkFailed(export.ID, err.Error()); err != nil {
+				log.Printf("[DataExport] Failed to mark export %s as failed: %v", export.ID, err)
+			}
+		}
+	}
+}
+
+func (s *DataExportService) deleteExpired() {
+	exports, err := s.exportRepo.FindExpired(100)
+	if err != nil {
+		log.Printf("[DataExport] Error fetching expired exports: %v", err)
+		return
+	}
+
+	for _, export := range exports {
+		if err := s.s3Client.DeleteObject(export.S3Key); err != nil {
+			log.Printf("[DataExport] Failed to delete archive %s: %v", export.S3Key, err)
+			continue
+		}
+		if err := s.exportRepo.MarkExpired(export.ID); err != nil {
+			log.Printf("[DataExport] Failed to mark export %s as expired: %v", export.ID, err)
+		}
+	}
+}
+
+func exportFilename(export *models.DataExport) string {
+	return fmt.Sprintf("heyspoilme-export-%s.zip", export.CreatedAt.Format("2006-01-02"))
+}
+
+func (s *DataExportService) buildExport(export *models.DataExport) error {
+	user, err := s.userRepo.FindByID(export.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	file, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := s.writeArchive(file, user); err != nil {
+		return err
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("exports/%s/%s.zip", user.ID, export.ID)
+	if err := s.s3Client.PutObject(key, "application/zip", file); err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().UTC().Add(dataExportTTL)
+	if err := s.exportRepo.MarkReady(export.ID, key, size, expiresAt); err != nil {
+		return err
+	}
+
+	log.Printf("[DataExport] Export %s ready for user %s (%d bytes)", export.ID, user.ID, size)
+
+	if s.emailClient != nil {
+		downloadURL, err := s.s3Client.GetPresignedDownloadURL(key, exportFilename(export), dataExportTTL)
+		if err != nil {
+			log.Printf("[DataExport] Failed to create download link for export %s: %v", export.ID, err)
+			return nil
+		}
+		if err := s.emailClient.SendDataExportReadyEmail(user.Email, downloadURL, int(dataExportTTL.Hours())); err != nil {
+			log.Printf("[DataExport] Failed to send export email for %s: %v", export.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// exportConversation is a conversation with its full message history
+type exportConversation struct {
+	models.Conversation
+	Participants []uuid.UUID      `json:"participants"`
+	Messages     []models.Message `json:"messages"`
+}
+
+// exportManifest describes the archive's contents
+type exportManifest struct {
+	UserID       uuid.UUID `json:"user_id"`
+	GeneratedAt  time.Time `json:"generated_at"`
+	Files        []string  `json:"files"`
+	MissingMedia []string  `json:"missing_media,omitempty"`
+}
+
+func (s *DataExportService) writeArchive(w io.Writer, user *models.User) error {
+	zw := zip.NewWriter(w)
+	manifest := exportManifest{UserID: user.ID, GeneratedAt: time.Now().UTC()}
+
+	writeJSON := func(name string, v interface{}) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, name)
+		return nil
+	}
+
+	if err := writeJSON("account.json", user); err != nil {
+		return err
+	}
+
+	sessions, err := s.sessionRepo.ListActiveForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("sessions.json", sessions); err != nil {
+		return err
+	}
+
+	profile, err := s.profileRepo.FindByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	images, err := s.profileRepo.GetImages(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("profile.json", map[string]interface{}{"profile": profile, "images": images}); err != nil {
+		return err
+	}
+
+	likesGiven, err := collectPages(func(limit, offset int) ([]models.Like, error) {
+		likes, _, err := s.likeRepo.GetGivenLikes(user.ID, limit, offset)
+		return likes, err
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("likes_given.json", likesGiven); err != nil {
+		return err
+	}
+
+	likesReceived, err := collectPages(func(limit, offset int) ([]models.Like, error) {
+		likes, _, err := s.likeRepo.GetReceivedLikes(user.ID, limit, offset)
+		return likes, err
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("likes_received.json", likesReceived); err != nil {
+		return err
+	}
+
+	conversations, err := s.messageRepo.GetUserConversations(user.ID)
+	if err != nil {
+		return err
+	}
+	exported := make([]exportConversation, 0, len(conversations))
+	for _, conv := range conversations {
+		messages, err := s.collectMessages(conv.ID)
+		if err != nil {
+			return err
+		}
+		exported = append(exported, exportConversation{
+			Conversation: conv.Conversation,
+			Participants: conv.Participants,
+			Messages:     messages,
+		})
+	}
+	if err := writeJSON("conversations.json", exported); err != nil {
+		return err
+	}
+
+	notifications, err := collectPages(func(limit, offset int) ([]models.Notification, error) {
+		notifications, _, err := s.notificationRepo.GetByUserID(user.ID, limit, offset)
+		return notifications, err
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("notifications.json", notifications); err != nil {
+		return err
+	}
+
+	verifications, err := s.verificationRepo.ListByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("verification_requests.json", verifications); err != nil {
+		return err
+	}
+
+	presence, err := s.presenceRepo.GetPresence(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("presence.json", presence); err != nil {
+		return err
+	}
+
+	// Media: profile photos and images the user sent in chats
+	var mediaKeys []string
+	for _, image := range images {
+		mediaKeys = append(mediaKeys, image.S3Key)
+	}
+	messageImageURLs, err := s.messageRepo.GetUserMessageImageURLs(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, url := range messageImageURLs {
+		if key := storage.KeyFromURL(url); key != "" {
+			mediaKeys = append(mediaKeys, key)
+		}
+	}
+
+	for _, key := range mediaKeys {
+		body, err := s.s3Client.GetObject(key)
+		if err != nil {
+			log.Printf("[DataExport] Skipping media %s: %v", key, err)
+			manifest.MissingMedia = append(manifest.MissingMedia, key)
+			continue
+		}
+
+		name := path.Join("media", key)
+		err = copyToArchive(zw, name, body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, name)
+	}
+
+	if err := writeJSON("manifest.json", manifest); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func copyToArchive(zw *zip.Writer, name string, r io.Reader) error {
+	// Images are already compressed, so store them as-is
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// collectMessages reads a whole conversation, newest first, walking the
+// before cursor so messages sent during the export don't shift the pages
+func (s *DataExportService) collectMessages(conversationID uuid.UUID) ([]models.Message, error) {
+	all := []models.Message{}
+	var before *models.MessageCursor
+	for {
+		page, err := s.messageRepo.GetMessages(conversationID, before, nil, exportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if len(page) < exportPageSize {
+			return all, nil
+		}
+		cursor := models.CursorFor(&page[len(page)-1])
+		before = &cursor
+	}
+}
+
+// collectPages reads every page of a limit/offset query
+func collectPages[T any](fetch func(limit, offset int) ([]T, error)) ([]T, error) {
+	all := []T{}
+	for offset := 0; ; offset += exportPageSize {
+		page, err := fetch(exportPageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if len(page) < exportPageSize {
+			return all, nil
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/repository"
+	"heyspoilme/internal/websocket"
+)
+
+// DeletionService permanently deletes accounts. It is shared by self-service
+// deletion (after the grace period) and by admins.
+type DeletionService struct {
+	deletionRepo *repository.DeletionRepository
+	hub          *websocket.Hub
+}
+
+func NewDeletionService(deletionRepo *repository.DeletionRepository, hub *websocket.Hub) *DeletionService {
+	return &DeletionService{
+		deletionRepo: deletionRepo,
+		hub:          hub,
+	}
+}
+
+// DeleteUser removes the user's data in a single transaction and queues their
+// S3 objects for the cleanup worker. Deleting an already deleted user is a no-op.
+func (s *DeletionService) DeleteUser(userID uuid.UUID) error {
+	deleted, err := s.deletionRepo.DeleteUser(userID)
+	return s.finishDeletion(userID, deleted, err)
+}
+
+// DeleteUserIfDue deletes the user only if they are still pending deletion and
+// their grace period ended by now. An account restored in the meantime is kept.
+func (s *DeletionService) DeleteUserIfDue(userID uuid.UUID, now time.Time) error {
+	deleted, err := s.deletionRepo.DeleteUserIfDue(userID, now)
+	return s.finishDeletion(userID, deleted, err)
+}
+
+func (s *DeletionService) finishDeletion(userID uuid.UUID, deleted bool, err error) error {
+	if err != nil {
+		log.Printf("[Deletion] Failed to delete user %s: %v", userID, err)
+		return err
+	}
+	if !deleted {
+		log.Printf("[Deletion] User %s already deleted or no longer due", userID)
+		return nil
+	}
+
+	s.hub.DisconnectUser(userID)
+
+	log.Printf("[Deletion] Deleted user %s", userID)
+	return nil
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/internal/repository"
+	"heyspoilme/pkg/email"
+)
+
+const (
+	emailChangeTTL       = 24 * time.Hour
+	emailChangeCancelTTL = 7 * 24 * time.Hour
+	// A provider sign in counts as re-authentication for this long
+	freshLoginWindow = 10 * time.Minute
+)
+
+var (
+	ErrReauthenticationRequired = errors.New("please confirm your password or sign in again")
+	ErrEmailTaken               = errors.New("email already registered")
+	ErrEmailUnchanged           = errors.New("new email is the same as the current one")
+	ErrInvalidEmailChangeToken  = errors.New("invalid or expired email change link")
+	ErrPreviousEmailTaken       = errors.New("your previous email now belongs to another account, so the change can't be undone")
+)
+
+// emailChangeStore is the part of *repository.UserRepository email changes use
+type emailChangeStore interface {
+	FindByID(id uuid.UUID) (*models.User, error)
+	EmailInUse(email string) (bool, error)
+	SetPendingEmail(userID uuid.UUID, pendingEmail, tokenHash string, tokenExpiresAt time.Time, cancelTokenHash string, cancelExpiresAt time.Time) error
+	ConfirmEmailChange(tokenHash string) (*models.EmailChange, error)
+	CancelEmailChange(cancelTokenHash string) (*models.EmailChange, error)
+}
+
+// sessionFinder looks sessions up by ID. It is satisfied by
+// *repository.SessionRepository.
+type sessionFinder interface {
+	FindByID(id uuid.UUID) (*models.Session, error)
+}
+
+// EmailChangeService moves an account to a new address. The change only takes
+// effect once the new address is confirmed, and the old address can undo it.
+type EmailChangeService struct {
+	userRepo       emailChangeStore
+	sessionRepo    sessionFinder
+	sessionService sessionRevoker
+	loginThrottle  *LoginThrottleService
+	emailClient    *email.ZeptoMailClient
+	now            func() time.Time
+}
+
+func NewEmailChangeService(userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, sessionService *SessionService, loginThrottle *LoginThrottleService, emailClient *email.ZeptoMailClient) *EmailChangeService {
+	return &EmailChangeService{
+		userRepo:       userRepo,
+		sessionRepo:    sessionRepo,
+		sessionService: sessionService,
+		loginThrottle:  loginThrottle,
+		emailClient:    emailClient,
+		now:            func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// RequestChange re-authenticates the user and emails a confirmation link to the
+// new address and a cancel link to the current one. Password accounts must send
+// their password; provider-only accounts need a sign in from the last few minutes.
+func (s *EmailChangeService) RequestChange(userID, sessionID uuid.UUID, newEmail, password, ip string) error {
+	if s.emailClient == nil {
+		return errors.New("email service not configured")
+	}
+
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	newEmail = strings.ToLower(strings.TrimSpace(newEmail))
+	if strings.EqualFold(newEmail, user.Email) {
+		return ErrEmailUnchanged
+	}
+
+	if err := s.reauthenticate(user, sessionID, password, ip); err != nil {
+		return err
+	}
+
+	taken, err := s.userRepo.EmailInUse(newEmail)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+
+	token, err := generateVerificationToken()
+	if err != nil {
+		return errors.New("failed to generate verification token")
+	}
+	cancelToken, err := generateVerificationToken()
+	if err != nil {
+		return errors.New("failed to generate verification token")
+	}
+
+	now := s.now()
+	if err := s.userRepo.SetPendingEmail(userID, newEmail, hashToken(token), now.Add(emailChangeTTL),
+		hashToken(cancelToken), now.Add(emailChangeCancelTTL)); err != nil {
+		return err
+	}
+
+	if err := s.emailClient.SendEmailChangeConfirmation(newEmail, token); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.emailClient.SendEmailChangeNotice(user.Email, newEmail, cancelToken); err != nil {
+			log.Printf("[EmailChange] Failed to send change notice: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *EmailChangeService) reauthenticate(user *models.User, sessionID uuid.UUID, password, ip string) error {
+	if password != "" {
+		if !user.PasswordHash.Valid {
+			return ErrReauthenticationRequired
+		}
+		if err := s.loginThrottle.Check(user.Email, ip); err != nil {
+			return err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash.String), []byte(password)) != nil {
+			if err := s.loginThrottle.RecordFailure(user.Email, ip); err != nil {
+				log.Printf("[EmailChange] Failed to record login attempt: %v", err)
+			}
+			return ErrReauthenticationRequired
+		}
+		return nil
+	}
+
+	session, err := s.sessionRepo.FindByID(sessionID)
+	if err != nil {
+		return err
+	}
+	if session == nil || session.AuthMethod == models.AuthMethodPassword || s.now().Sub(session.CreatedAt) > freshLoginWindow {
+		return ErrReauthenticationRequired
+	}
+
+	return nil
+}
+
+// ConfirmChange applies a pending change using the link sent to the new address
+func (s *EmailChangeService) ConfirmChange(token string) (*models.EmailChange, error) {
+	change, err := s.userRepo.ConfirmEmailChange(hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if change == nil {
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	return change, nil
+}
+
+// CancelChange drops a pending change using the link sent to the old address. If
+// the change was already confirmed the old address is restored and every session
+// is signed out, since someone else may have made the change.
+func (s *EmailChangeService) CancelChange(cancelToken string) (*models.EmailChange, error) {
+	change, err := s.userRepo.CancelEmailChange(hashToken(cancelToken))
+	if errors.Is(err, repository.ErrPreviousEmailTaken) {
+		return nil, ErrPreviousEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if change == nil {
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	if change.Reverted {
+		if err := s.sessionService.RevokeAllSessions(change.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	return change, nil
+}
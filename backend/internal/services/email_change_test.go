@@ -0,0 +1,260 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/internal/repository"
+)
+
+// pendingEmailChange is the email change state the repository keeps on users
+type pendingEmailChange struct {
+	pendingEmail  string
+	previousEmail string
+	tokenHash     string
+	tokenExpires  time.Time
+	cancelHash    string
+	cancelExpires time.Time
+}
+
+// fakeEmailChangeStore keeps email changes in memory with the same conditions
+// as the Postgres repository
+type fakeEmailChangeStore struct {
+	*fakeUserStore
+	changes map[uuid.UUID]*pendingEmailChange
+}
+
+func (f *fakeEmailChangeStore) EmailInUse(email string) (bool, error) {
+	return f.emailInUse(email, uuid.Nil), nil
+}
+
+func (f *fakeEmailChangeStore) emailInUse(email string, except uuid.UUID) bool {
+	for _, user := range f.users {
+		if user.ID != except && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeEmailChangeStore) SetPendingEmail(userID uuid.UUID, pendingEmail, tokenHash string, tokenExpiresAt time.Time, cancelTokenHash string, cancelExpiresAt time.Time) error {
+	f.changes[userID] = &pendingEmailChange{
+		pendingEmail:  pendingEmail,
+		tokenHash:     tokenHash,
+		tokenExpires:  tokenExpiresAt,
+		cancelHash:    cancelTokenHash,
+		cancelExpires: cancelExpiresAt,
+	}
+	return nil
+}
+
+func (f *fakeEmailChangeStore) ConfirmEmailChange(tokenHash string) (*models.EmailChange, error) {
+	for userID, change := range f.changes {
+		if change.tokenHash == "" || change.tokenHash != tokenHash || !change.tokenExpires.After(time.Now()) {
+			continue
+		}
+		if f.emailInUse(change.pendingEmail, uuid.Nil) {
+			return nil, nil
+		}
+
+		user := f.users[userID]
+		change.previousEmail = user.Email
+		user.Email, user.EmailVerified = change.pendingEmail, true
+		change.pendingEmail, change.tokenHash = "", ""
+		return &models.EmailChange{UserID: userID, OldEmail: change.previousEmail, NewEmail: user.Email}, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeEmailChangeStore) CancelEmailChange(cancelTokenHash string) (*models.EmailChange, error) {
+	for userID, change := range f.changes {
+		if change.cancelHash != cancelTokenHash || !change.cancelExpires.After(time.Now()) {
+			continue
+		}
+
+		user := f.users[userID]
+		result := &models.EmailChange{UserID: userID, OldEmail: user.Email, NewEmail: change.pendingEmail}
+		if change.previousEmail != "" {
+			if f.emailInUse(change.previousEmail, userID) {
+				return nil, repository.ErrPreviousEmailTaken
+			}
+			result.OldEmail, result.NewEmail, result.Reverted = change.previousEmail, user.Email, true
+		}
+
+		user.Email = result.OldEmail
+		delete(f.changes, userID)
+		return result, nil
+	}
+	return nil, nil
+}
+
+type emailChangeTest struct {
+	service  *EmailChangeService
+	store    *fakeEmailChangeStore
+	sessions *fakeSessionRevoker
+	user     *models.User
+}
+
+func newEmailChangeTest() *emailChangeTest {
+	tt := &emailChangeTest{
+		store: &fakeEmailChangeStore{
+			fakeUserStore: &fakeUserStore{users: make(map[uuid.UUID]*models.User)},
+			changes:       make(map[uuid.UUID]*pendingEmailChange),
+		},
+		sessions: &fakeSessionRevoker{},
+	}
+	tt.user = tt.store.add(models.User{Email: "old@example.com", EmailVerified: true})
+	tt.service = &EmailChangeService{userRepo: tt.store, sessionService: tt.sessions, now: time.Now}
+	return tt
+}
+
+// request starts a change to newEmail and returns the confirm and cancel tokens.
+// A negative ttl starts one that has already expired.
+func (tt *emailChangeTest) request(newEmail string, ttl time.Duration) (string, string) {
+	token, cancelToken := uuid.NewString(), uuid.NewString()
+	expiresAt := time.Now().Add(ttl)
+	tt.store.SetPendingEmail(tt.user.ID, newEmail, hashToken(token), expiresAt, hashToken(cancelToken), expiresAt)
+	return token, cancelToken
+}
+
+func (tt *emailChangeTest) email() string {
+	return tt.store.users[tt.user.ID].Email
+}
+
+func TestEmailChangeConfirm(t *testing.T) {
+	tt := newEmailChangeTest()
+	token, _ := tt.request("new@example.com", time.Hour)
+
+	change, err := tt.service.ConfirmChange(token)
+	if err != nil {
+		t.Fatalf("ConfirmChange: %v", err)
+	}
+	if change.OldEmail != "old@example.com" || change.NewEmail != "new@example.com" || tt.email() != "new@example.com" {
+		t.Errorf("change = %+v, email = %q, want a move to new@example.com", change, tt.email())
+	}
+
+	if _, err := tt.service.ConfirmChange(token); !errors.Is(err, ErrInvalidEmailChangeToken) {
+		t.Errorf("reused token: err = %v, want ErrInvalidEmailChangeToken", err)
+	}
+}
+
+func TestEmailChangeConfirmRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(tt *emailChangeTest) string
+	}{
+		{
+			name: "expired token",
+			setup: func(tt *emailChangeTest) string {
+				token, _ := tt.request("new@example.com", -time.Minute)
+				return token
+			},
+		},
+		{
+			name: "unknown token",
+			setup: func(tt *emailChangeTest) string {
+				tt.request("new@example.com", time.Hour)
+				return "not-a-token"
+			},
+		},
+		{
+			name: "address taken since the request",
+			setup: func(tt *emailChangeTest) string {
+				token, _ := tt.request("new@example.com", time.Hour)
+				tt.store.add(models.User{Email: "New@Example.com"})
+				return token
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := newEmailChangeTest()
+			token := tc.setup(tt)
+
+			if _, err := tt.service.ConfirmChange(token); !errors.Is(err, ErrInvalidEmailChangeToken) {
+				t.Errorf("err = %v, want ErrInvalidEmailChangeToken", err)
+			}
+			if tt.email() != "old@example.com" {
+				t.Errorf("email = %q, want it unchanged", tt.email())
+			}
+		})
+	}
+}
+
+func TestEmailChangeCancelPending(t *testing.T) {
+	tt := newEmailChangeTest()
+	token, cancelToken := tt.request("new@example.com", time.Hour)
+
+	change, err := tt.service.CancelChange(cancelToken)
+	if err != nil {
+		t.Fatalf("CancelChange: %v", err)
+	}
+	if change.Reverted || tt.email() != "old@example.com" {
+		t.Errorf("change = %+v, email = %q, want the pending change dropped", change, tt.email())
+	}
+	if len(tt.sessions.revoked) != 0 {
+		t.Error("sessions revoked for a change that never happened")
+	}
+
+	if _, err := tt.service.CancelChange(cancelToken); !errors.Is(err, ErrInvalidEmailChangeToken) {
+		t.Errorf("reused cancel token: err = %v, want ErrInvalidEmailChangeToken", err)
+	}
+	if _, err := tt.service.ConfirmChange(token); !errors.Is(err, ErrInvalidEmailChangeToken) {
+		t.Errorf("confirming a cancelled change: err = %v, want ErrInvalidEmailChangeToken", err)
+	}
+}
+
+func TestEmailChangeCancelReverts(t *testing.T) {
+	tt := newEmailChangeTest()
+	token, cancelToken := tt.request("new@example.com", time.Hour)
+	if _, err := tt.service.ConfirmChange(token); err != nil {
+		t.Fatalf("ConfirmChange: %v", err)
+	}
+
+	change, err := tt.service.CancelChange(cancelToken)
+	if err != nil {
+		t.Fatalf("CancelChange: %v", err)
+	}
+	if !change.Reverted || tt.email() != "old@example.com" {
+		t.Errorf("change = %+v, email = %q, want the old address back", change, tt.email())
+	}
+	if len(tt.sessions.revoked) != 1 || tt.sessions.revoked[0] != tt.user.ID {
+		t.Errorf("revoked = %v, want every session of %s", tt.sessions.revoked, tt.user.ID)
+	}
+}
+
+func TestEmailChangeCancelRejects(t *testing.T) {
+	t.Run("expired cancel token", func(t *testing.T) {
+		tt := newEmailChangeTest()
+		_, cancelToken := tt.request("new@example.com", -time.Minute)
+
+		if _, err := tt.service.CancelChange(cancelToken); !errors.Is(err, ErrInvalidEmailChangeToken) {
+			t.Errorf("err = %v, want ErrInvalidEmailChangeToken", err)
+		}
+	})
+
+	t.Run("previous address claimed by another account", func(t *testing.T) {
+		tt := newEmailChangeTest()
+		token, cancelToken := tt.request("new@example.com", time.Hour)
+		if _, err := tt.service.ConfirmChange(token); err != nil {
+			t.Fatalf("ConfirmChange: %v", err)
+		}
+		tt.store.add(models.User{Email: "OLD@example.com"})
+
+		if _, err := tt.service.CancelChange(cancelToken); !errors.Is(err, ErrPreviousEmailTaken) {
+			t.Errorf("err = %v, want ErrPreviousEmailTaken", err)
+		}
+		if tt.email() != "new@example.com" {
+			t.Errorf("email = %q, want the new address kept", tt.email())
+		}
+		if len(tt.sessions.revoked) != 0 {
+			t.Error("sessions revoked although nothing was reverted")
+		}
+	})
+}
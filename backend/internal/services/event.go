@@ -0,0 +1,207 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/internal/repository"
+	"heyspoilme/internal/websocket"
+)
+
+const (
+	// maxReplayEvents caps how many missed events a connection is sent when it
+	// resumes. Clients further behind reload their state instead.
+	maxReplayEvents = 100
+	// userEventRetention is how long events are kept for sessions that stop
+	// acknowledging them, such as a device that was never opened again
+	userEventRetention     = 7 * 24 * time.Hour
+	userEventPruneInterval = time.Hour
+)
+
+var (
+	ErrInvalidEventSeq = errors.New("event sequence number is out of range")
+)
+
+// EventService delivers realtime events to users. Everything but transient
+// events is stored in the user's outbox with a sequence number first, so a
+// connection that misses some, by being offline or too slow, is sent them
+// when it resumes.
+type EventService struct {
+	eventRepo *repository.UserEventRepository
+	hub       *websocket.Hub
+	stopChan  chan struct{}
+}
+
+func NewEventService(eventRepo *repository.UserEventRepository, hub *websocket.Hub) *EventService {
+	return &EventService{
+		eventRepo: eventRepo,
+		hub:       hub,
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// SendToUser stores the event and sends it to every connection the user has
+// open. If storing fails the event is still sent, just without a sequence
+// number.
+func (s *EventService) SendToUser(userID uuid.UUID, message *models.WSMessage) {
+	if !message.Type.Transient() {
+		seq, err := s.append(userID, message)
+		if err != nil {
+			log.Printf("[Events] Failed to store %s event for user %s: %v", message.Type, userID, err)
+		} else {
+			// The same message may go to several users, each numbered separately
+			numbered := *message
+			numbered.Seq = seq
+			message = &numbered
+		}
+	}
+
+	s.hub.BroadcastToUser(userID, message)
+}
+
+func (s *EventService) append(userID uuid.UUID, message *models.WSMessage) (int64, error) {
+	payload, err := json.Marshal(message.Payload)
+	if err != nil {
+		return 0, err
+	}
+	return s.eventRepo.Append(userID, message.Type, payload)
+}
+
+// Resume sends the connection the user's events after since, then a resume
+// message with the latest sequence number. A negative since means the client
+// has nothing to catch up on and only needs that number.
+func (s *EventService) Resume(client *websocket.Client, since int64) {
+	resume, err := s.replay(client, since)
+	if err != nil {
+		log.Printf("[Events] Failed to replay events for user %s: %v", client.UserID, err)
+		resume = &models.WSResumePayload{Reset: true}
+	}
+	if resume == nil {
+		return
+	}
+
+	s.hub.SendToClient(client, &models.WSMessage{
+		Type:    models.WSTypeResume,
+		Payload: resume,
+	})
+}
+
+// replay queues the missed events for the connection. It returns nil if they
+// didn't fit in its buffer, in which case the connection is closed and the
+// client resumes again once it reconnects.
+func (s *EventService) replay(client *websocket.Client, since int64) (*models.WSResumePayload, error) {
+	current, err := s.eventRepo.CurrentSeq(client.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	resume := &models.WSResumePayload{Seq: current}
+	if since < 0 || since == current {
+		return resume, nil
+	}
+	if since > current {
+		// The client saw events that no longer exist, say after a restore
+		resume.Reset = true
+		return resume, nil
+	}
+
+	events, err := s.eventRepo.ListSince(client.UserID, since, maxReplayEvents+1)
+	if err != nil {
+		return nil, err
+	}
+	// The events right after since are gone if the first one stored is later,
+	// having been pruned by age
+	if len(events) == 0 || events[0].Seq != since+1 || len(events) > maxReplayEvents {
+		resume.Reset = true
+		return resume, nil
+	}
+
+	for _, event := range events {
+		queued := s.hub.SendToClient(client, &models.WSMessage{
+			Type:    event.Type,
+			Payload: event.Payload,
+			Seq:     event.Seq,
+		})
+		if !queued {
+			client.Conn.Close()
+			return nil, nil
+		}
+		// Events stored after current was read are sent too
+		resume.Seq = max(resume.Seq, event.Seq)
+	}
+	return resume, nil
+}
+
+// Ack records that the connection's session has seen the user's events up to
+// seq. Events every live session has seen are pruned.
+func (s *EventService) Ack(client *websocket.Client, seq int64) error {
+	current, err := s.eventRepo.CurrentSeq(client.UserID)
+	if err != nil {
+		return err
+	}
+	if seq < 0 || seq > current {
+		return ErrInvalidEventSeq
+	}
+	return s.eventRepo.Ack(client.SessionID, client.UserID, seq)
+}
+
+// RegisterCommands routes the event stream commands clients send over WebSocket
+func (s *EventService) RegisterCommands(d *websocket.Dispatcher) {
+	d.Register(models.WSTypeAckEvents, func(client *websocket.Client, payload json.RawMessage) error {
+		var cmd models.WSAckEventsPayload
+		if err := websocket.DecodePayload(payload, &cmd); err != nil {
+			return err
+		}
+		return s.Ack(client, cmd.Seq)
+	})
+	// Clients that notice a gap in sequence numbers ask for the missing events
+	// without reconnecting
+	d.Register(models.WSTypeResume, func(client *websocket.Client, payload json.RawMessage) error {
+		var cmd models.WSResumeCommandPayload
+		if err := websocket.DecodePayload(payload, &cmd); err != nil {
+			return err
+		}
+		if cmd.Since < 0 {
+			return ErrInvalidEventSeq
+		}
+		s.Resume(client, cmd.Since)
+		return nil
+	})
+}
+
+// Start prunes events that were never acknowledged once they pass the
+// retention period
+func (s *EventService) Start() {
+	ticker := time.NewTicker(userEventPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.prune()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// Stop stops the pruning job
+func (s *EventService) Stop() {
+	close(s.stopChan)
+}
+
+func (s *EventService) prune() {
+	removed, err := s.eventRepo.DeleteOlderThan(time.Now().UTC().Add(-userEventRetention))
+	if err != nil {
+		log.Printf("[Events] Failed to prune old events: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("[Events] Pruned %d events past retention", removed)
+	}
+}
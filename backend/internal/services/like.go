@@ -7,7 +7,6 @@ import (
 
 	"heyspoilme/internal/models"
 	"heyspoilme/internal/repository"
-	"heyspoilme/internal/websocket"
 )
 
 var (
@@ -18,16 +17,16 @@ type LikeService struct {
 	likeRepo           *repository.LikeRepository
 	notificationRepo   *repository.NotificationRepository
 	profileRepo        *repository.ProfileRepository
-	hub                *websocket.Hub
+	events             *EventService
 	featureFlagService *FeatureFlagService
 }
 
-func NewLikeService(likeRepo *repository.LikeRepository, notificationRepo *repository.NotificationRepository, profileRepo *repository.ProfileRepository, hub *websocket.Hub, featureFlagService *FeatureFlagService) *LikeService {
+func NewLikeService(likeRepo *repository.LikeRepository, notificationRepo *repository.NotificationRepository, profileRepo *repository.ProfileRepository, events *EventService, featureFlagService *FeatureFlagService) *LikeService {
 	return &LikeService{
 		likeRepo:           likeRepo,
 		notificationRepo:   notificationRepo,
 		profileRepo:        profileRepo,
-		hub:                hub,
+		events:             events,
 		featureFlagService: featureFlagService,
 	}
 }
@@ -59,7 +58,7 @@ func (s *LikeService) LikeProfile(likerID, likedID uuid.UUID, likerName, likerIm
 	}
 	notification, err := s.notificationRepo.Create(likedID, models.NotificationTypeLike, notifData)
 	if err == nil && notification != nil {
-		s.hub.BroadcastToUser(likedID, &models.WSMessage{
+		s.events.SendToUser(likedID, &models.WSMessage{
 			Type:    models.WSTypeNotification,
 			Payload: notification,
 		})
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/repository"
+	"heyspoilme/pkg/email"
+)
+
+// throttlePolicy decides how long a key has to wait after repeated failures.
+// The first freeAttempts failures cost nothing, after that the wait doubles
+// with every failure up to maxDelay, and lockoutAfter failures lock the key.
+type throttlePolicy struct {
+	freeAttempts int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	lockoutAfter int
+	lockout      time.Duration
+	window       time.Duration // failures older than this are forgotten
+}
+
+var (
+	accountThrottlePolicy = throttlePolicy{
+		freeAttempts: 3,
+		baseDelay:    time.Second,
+		maxDelay:     5 * time.Minute,
+		lockoutAfter: 10,
+		lockout:      15 * time.Minute,
+		window:       time.Hour,
+	}
+	// IPs get more room since several people can share one (offices, carrier NAT)
+	ipThrottlePolicy = throttlePolicy{
+		freeAttempts: 10,
+		baseDelay:    time.Second,
+		maxDelay:     15 * time.Minute,
+		lockoutAfter: 50,
+		lockout:      time.Hour,
+		window:       time.Hour,
+	}
+)
+
+func (p throttlePolicy) delay(failures int) time.Duration {
+	if failures <= p.freeAttempts {
+		return 0
+	}
+
+	delay := p.baseDelay
+	for i := p.freeAttempts + 1; i < failures && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	return delay
+}
+
+// LoginThrottledError is returned while an account or IP has to wait before
+// trying another password
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return "too many failed sign in attempts, sign in is temporarily locked"
+	}
+	return "too many failed sign in attempts, please wait before trying again"
+}
+
+// RetryAfterSeconds rounds the wait up to whole seconds for the Retry-After header
+func (e *LoginThrottledError) RetryAfterSeconds() string {
+	seconds := int(e.RetryAfter / time.Second)
+	if e.RetryAfter%time.Second != 0 {
+		seconds++
+	}
+	return fmt.Sprint(seconds)
+}
+
+// LoginThrottleService slows down password guessing per account and per IP
+type LoginThrottleService struct {
+	store       repository.LoginAttemptStore
+	userRepo    *repository.UserRepository
+	emailClient *email.ZeptoMailClient
+	now         func() time.Time
+	stopChan    chan struct{}
+}
+
+func NewLoginThrottleService(store repository.LoginAttemptStore, userRepo *repository.UserRepository, emailClient *email.ZeptoMailClient) *LoginThrottleService {
+	return &LoginThrottleService{
+		store:       store,
+		userRepo:    userRepo,
+		emailClient: emailClient,
+		now:         func() time.Time { return time.Now().UTC() },
+		stopChan:    make(chan struct{}),
+	}
+}
+
+func accountThrottleKey(userEmail string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(userEmail))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a *LoginThrottledError if either the account or the IP must wait
+func (s *LoginThrottleService) Check(userEmail, ip string) error {
+	now := s.now()
+	var worst *LoginThrottledError
+
+	checks := []struct {
+		key    string
+		policy throttlePolicy
+	}{
+		{accountThrottleKey(userEmail), accountThrottlePolicy},
+		{ipThrottleKey(ip), ipThrottlePolicy},
+	}
+	for _, check := range checks {
+		attempt, err := s.store.Get(check.key)
+		if err != nil {
+			return err
+		}
+		if attempt == nil {
+			continue
+		}
+
+		var throttled *LoginThrottledError
+		if attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+			throttled = &LoginThrottledError{RetryAfter: attempt.LockedUntil.Sub(now), Locked: true}
+		} else if next := attempt.LastFailedAt.Add(check.policy.delay(attempt.Failures)); next.After(now) {
+			throttled = &LoginThrottledError{RetryAfter: next.Sub(now)}
+		}
+
+		if throttled != nil && (worst == nil || throttled.RetryAfter > worst.RetryAfter) {
+			worst = throttled
+		}
+	}
+
+	if worst != nil {
+		return worst
+	}
+	return nil
+}
+
+// RecordFailure counts a wrong password against the account and the IP, locking
+// either once it crosses its lockout threshold
+func (s *LoginThrottleService) RecordFailure(userEmail, ip string) error {
+	now := s.now()
+
+	accountAttempt, err := s.store.RecordFailure(accountThrottleKey(userEmail), now, accountThrottlePolicy.window)
+	if err != nil {
+		return err
+	}
+	if accountAttempt.Failures >= accountThrottlePolicy.lockoutAfter {
+		if err := s.store.Lock(accountAttempt.Key, now.Add(accountThrottlePolicy.lockout)); err != nil {
+			return err
+		}
+		log.Printf("[LoginThrottle] Locked %s after %d failed attempts", accountAttempt.Key, accountAttempt.Failures)
+		s.sendLockoutNotice(userEmail)
+	}
+
+	ipAttempt, err := s.store.RecordFailure(ipThrottleKey(ip), now, ipThrottlePolicy.window)
+	if err != nil {
+		return err
+	}
+	if ipAttempt.Failures >= ipThrottlePolicy.lockoutAfter {
+		if err := s.store.Lock(ipAttempt.Key, now.Add(ipThrottlePolicy.lockout)); err != nil {
+			return err
+		}
+		log.Printf("[LoginThrottle] Locked %s after %d failed attempts", ipAttempt.Key, ipAttempt.Failures)
+	}
+
+	return nil
+}
+
+// RecordSuccess clears the account's counter. The IP counter is kept so one
+// working account can't be used to reset guessing against others.
+func (s *LoginThrottleService) RecordSuccess(userEmail string) error {
+	return s.store.Reset(accountThrottleKey(userEmail))
+}
+
+// Unlock lifts a lockout on the user's account (used by admins)
+func (s *LoginThrottleService) Unlock(userID uuid.UUID) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	return s.store.Reset(accountThrottleKey(user.Email))
+}
+
+func (s *LoginThrottleService) sendLockoutNotice(userEmail string) {
+	if s.emailClient == nil {
+		return
+	}
+
+	user, err := s.userRepo.FindByEmail(userEmail)
+	if err != nil || user == nil {
+		return
+	}
+
+	go func() {
+		if err := s.emailClient.SendAccountLockedEmail(user.Email, int(accountThrottlePolicy.lockout.Minutes())); err != nil {
+			log.Printf("[LoginThrottle] Failed to send lockout email: %v", err)
+		}
+	}()
+}
+
+// Start periodically removes stale counters
+func (s *LoginThrottleService) Start() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cutoff := s.now().Add(-ipThrottlePolicy.window)
+			if err := s.store.Prune(cutoff); err != nil {
+				log.Printf("[LoginThrottle] Failed to prune login attempts: %v", err)
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// Stop stops the cleanup job
+func (s *LoginThrottleService) Stop() {
+	close(s.stopChan)
+}
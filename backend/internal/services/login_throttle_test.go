@@ -0,0 +1,177 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"heyspoilme/internal/repository"
+)
+
+func TestThrottlePolicyDelay(t *testing.T) {
+	policy := throttlePolicy{
+		freeAttempts: 3,
+		baseDelay:    time.Second,
+		maxDelay:     10 * time.Second,
+	}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := policy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottledErrorRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		want       string
+	}{
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{time.Minute, "60"},
+	}
+
+	for _, tt := range tests {
+		err := &LoginThrottledError{RetryAfter: tt.retryAfter}
+		if got := err.RetryAfterSeconds(); got != tt.want {
+			t.Errorf("RetryAfterSeconds(%v) = %q, want %q", tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+// newTestLoginThrottle returns a throttle on an in-memory store with a clock
+// the test moves by hand
+func newTestLoginThrottle() (*LoginThrottleService, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewLoginThrottleService(repository.NewMemoryLoginAttemptStore(), nil, nil)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func throttledError(t *testing.T, err error) *LoginThrottledError {
+	t.Helper()
+
+	var throttled *LoginThrottledError
+	if !errors.As(err, &throttled) {
+		t.Fatalf("err = %v, want a *LoginThrottledError", err)
+	}
+	return throttled
+}
+
+func TestLoginThrottleAccountDelay(t *testing.T) {
+	s, now := newTestLoginThrottle()
+	const email, ip = "someone@example.com", "203.0.113.1"
+
+	for i := 0; i < accountThrottlePolicy.freeAttempts; i++ {
+		if err := s.Check(email, ip); err != nil {
+			t.Fatalf("attempt %d: Check = %v, want no delay", i+1, err)
+		}
+		if err := s.RecordFailure(email, ip); err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+	}
+	if err := s.Check(email, ip); err != nil {
+		t.Fatalf("after the free attempts: Check = %v, want no delay", err)
+	}
+
+	if err := s.RecordFailure(email, ip); err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+	throttled := throttledError(t, s.Check(email, ip))
+	if throttled.Locked || throttled.RetryAfter != accountThrottlePolicy.baseDelay {
+		t.Errorf("throttled = %+v, want a %v delay", throttled, accountThrottlePolicy.baseDelay)
+	}
+
+	// The account key is normalised like the address
+	throttledError(t, s.Check("  SomeOne@Example.com ", "198.51.100.1"))
+
+	*now = now.Add(accountThrottlePolicy.baseDelay)
+	if err := s.Check(email, ip); err != nil {
+		t.Errorf("after waiting: Check = %v, want no delay", err)
+	}
+
+	// A success clears the account counter
+	if err := s.RecordSuccess(email); err != nil {
+		t.Fatalf("RecordSuccess: %v", err)
+	}
+	if err := s.RecordFailure(email, ip); err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+	if err := s.Check(email, ip); err != nil {
+		t.Errorf("after a success: Check = %v, want no delay", err)
+	}
+}
+
+func TestLoginThrottleAccountLockout(t *testing.T) {
+	s, now := newTestLoginThrottle()
+	const email = "someone@example.com"
+
+	// Spread the failures over several IPs so only the account locks
+	for i := 0; i < accountThrottlePolicy.lockoutAfter; i++ {
+		if err := s.RecordFailure(email, fmt.Sprintf("203.0.113.%d", i)); err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+	}
+
+	throttled := throttledError(t, s.Check(email, "198.51.100.1"))
+	if !throttled.Locked || throttled.RetryAfter != accountThrottlePolicy.lockout {
+		t.Errorf("throttled = %+v, want locked for %v", throttled, accountThrottlePolicy.lockout)
+	}
+	if err := s.Check("other@example.com", "198.51.100.1"); err != nil {
+		t.Errorf("another account: Check = %v, want no delay", err)
+	}
+
+	*now = now.Add(accountThrottlePolicy.lockout)
+	if err := s.Check(email, "198.51.100.1"); err != nil {
+		t.Errorf("after the lockout: Check = %v, want no delay", err)
+	}
+
+	// Failures older than the window are forgotten
+	*now = now.Add(accountThrottlePolicy.window)
+	if err := s.RecordFailure(email, "198.51.100.1"); err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+	if err := s.Check(email, "198.51.100.1"); err != nil {
+		t.Errorf("after the window: Check = %v, want no delay", err)
+	}
+}
+
+func TestLoginThrottleIPAcrossAccounts(t *testing.T) {
+	s, _ := newTestLoginThrottle()
+	const ip = "203.0.113.1"
+
+	// One failure per account never delays an account, but adds up on the IP
+	for i := 0; i < ipThrottlePolicy.lockoutAfter; i++ {
+		email := fmt.Sprintf("user%d@example.com", i)
+		if err := s.RecordFailure(email, ip); err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+		if err := s.RecordSuccess(email); err != nil {
+			t.Fatalf("RecordSuccess: %v", err)
+		}
+	}
+
+	throttled := throttledError(t, s.Check("fresh@example.com", ip))
+	if !throttled.Locked || throttled.RetryAfter != ipThrottlePolicy.lockout {
+		t.Errorf("throttled = %+v, want the IP locked for %v", throttled, ipThrottlePolicy.lockout)
+	}
+	if err := s.Check("fresh@example.com", "198.51.100.1"); err != nil {
+		t.Errorf("another IP: Check = %v, want no delay", err)
+	}
+}
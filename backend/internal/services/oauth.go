@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/pkg/auth"
+)
+
+const (
+	oauthStateTTL     = 10 * time.Minute
+	oauthLoginCodeTTL = 1 * time.Minute
+)
+
+var (
+	ErrUnknownProvider    = errors.New("unknown identity provider")
+	ErrInvalidOAuthState  = errors.New("invalid or expired login state")
+	ErrUnverifiedIdentity = errors.New("identity provider has not verified this email address")
+	ErrInvalidLoginCode   = errors.New("invalid or expired login code")
+)
+
+// oauthStore keeps in-progress provider logins, login codes and linked
+// identities. It is satisfied by *repository.OAuthRepository.
+type oauthStore interface {
+	CreateState(state *models.OAuthState) error
+	ConsumeState(stateHash, provider string) (*models.OAuthState, error)
+	CreateLoginCode(codeHash string, userID uuid.UUID, provider string, isNew bool, expiresAt time.Time) error
+	ConsumeLoginCode(codeHash string) (*models.OAuthLoginCode, error)
+	FindUserIDByIdentity(provider, subject string) (uuid.UUID, error)
+	LinkIdentity(userID uuid.UUID, provider, subject, email string) error
+}
+
+// oauthUserStore is the part of *repository.UserRepository provider sign in uses
+type oauthUserStore interface {
+	Create(email string, emailVerified bool) (*models.User, error)
+	FindByID(id uuid.UUID) (*models.User, error)
+	FindByEmail(email string) (*models.User, error)
+	accountClaimer
+}
+
+
+// OAuthService runs sign in through external identity providers. The provider
+// callback never sees tokens: it ends with a one-time login code the frontend
+// exchanges for a session.
+type OAuthService struct {
+	providers      auth.Providers
+	oauthRepo      oauthStore
+	userRepo       oauthUserStore
+	sessionService sessionRevoker
+}
+
+func NewOAuthService(providers auth.Providers, oauthRepo oauthStore, userRepo oauthUserStore, sessionService sessionRevoker) *OAuthService {
+	return &OAuthService{
+		providers:      providers,
+		oauthRepo:      oauthRepo,
+		userRepo:       userRepo,
+		sessionService: sessionService,
+	}
+}
+
+// BeginLogin returns the provider URL to redirect to, along with the state the
+// callback must present. The caller should also bind the state to the browser.
+func (s *OAuthService) BeginLogin(ctx context.Context, providerName string) (string, string, error) {
+	provider, ok := s.providers.Get(providerName)
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	state, err := auth.RandomToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := auth.RandomToken()
+	if err != nil {
+		return "", "", err
+	}
+	codeVerifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = s.oauthRepo.CreateState(&models.OAuthState{
+		StateHash:    hashToken(state),
+		Provider:     providerName,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().UTC().Add(oauthStateTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return authURL, state, nil
+}
+
+// CompleteLogin handles the provider callback and returns a one-time login code
+func (s *OAuthService) CompleteLogin(ctx context.Context, providerName, state, code string) (string, error) {
+	provider, ok := s.providers.Get(providerName)
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+
+	savedState, err := s.oauthRepo.ConsumeState(hashToken(state), providerName)
+	if err != nil {
+		return "", err
+	}
+	if savedState == nil {
+		return "", ErrInvalidOAuthState
+	}
+
+	identity, err := provider.Exchange(ctx, code, savedState.CodeVerifier, savedState.Nonce)
+	if err != nil {
+		return "", err
+	}
+
+	user, isNew, err := s.findOrCreateUser(identity)
+	if err != nil {
+		return "", err
+	}
+
+	loginCode, err := auth.RandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.oauthRepo.CreateLoginCode(hashToken(loginCode), user.ID, providerName, isNew, time.Now().UTC().Add(oauthLoginCodeTTL)); err != nil {
+		return "", err
+	}
+
+	return loginCode, nil
+}
+
+// ExchangeLoginCode redeems a login code. It can only be used once.
+func (s *OAuthService) ExchangeLoginCode(code string) (*models.User, *models.OAuthLoginCode, error) {
+	loginCode, err := s.oauthRepo.ConsumeLoginCode(hashToken(code))
+	if err != nil {
+		return nil, nil, err
+	}
+	if loginCode == nil {
+		return nil, nil, ErrInvalidLoginCode
+	}
+
+	user, err := s.userRepo.FindByID(loginCode.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, ErrInvalidLoginCode
+	}
+
+	return user, loginCode, nil
+}
+
+// findOrCreateUser resolves the provider identity to a user. Unknown identities
+// are linked to an existing account with the same email, or get a new account.
+func (s *OAuthService) findOrCreateUser(identity *auth.Identity) (*models.User, bool, error) {
+	userID, err := s.oauthRepo.FindUserIDByIdentity(identity.Provider, identity.Subject)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if userID != uuid.Nil {
+		user, err := s.userRepo.FindByID(userID)
+		if err != nil {
+			return nil, false, err
+		}
+		if user != nil {
+			return user, false, nil
+		}
+	}
+
+	// Linking by email is only safe if the provider vouches for the address
+	if !identity.EmailVerified || identity.Email == "" {
+		return nil, false, ErrUnverifiedIdentity
+	}
+
+	user, err := s.userRepo.FindByEmail(identity.Email)
+	if err != nil {
+		return nil, false, err
+	}
+
+	isNew := false
+	if user != nil {
+		// Signing in through the provider proves ownership of the address
+		if err := claimUnverifiedAccount(s.userRepo, s.sessionService, user); err != nil {
+			return nil, false, err
+		}
+	} else {
+		user, err = s.userRepo.Create(identity.Email, true)
+		if err != nil {
+			return nil, false, err
+		}
+		isNew = true
+	}
+
+	if err := s.oauthRepo.LinkIdentity(user.ID, identity.Provider, identity.Subject, identity.Email); err != nil {
+		return nil, false, err
+	}
+
+	return user, isNew, nil
+}
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/pkg/auth"
+	"heyspoilme/pkg/auth/authtest"
+)
+
+type fakeOAuthStore struct {
+	states     map[string]*models.OAuthState
+	loginCodes map[string]*models.OAuthLoginCode
+	identities map[string]uuid.UUID
+}
+
+func newFakeOAuthStore() *fakeOAuthStore {
+	return &fakeOAuthStore{
+		states:     make(map[string]*models.OAuthState),
+		loginCodes: make(map[string]*models.OAuthLoginCode),
+		identities: make(map[string]uuid.UUID),
+	}
+}
+
+func (f *fakeOAuthStore) CreateState(state *models.OAuthState) error {
+	f.states[state.StateHash] = state
+	return nil
+}
+
+func (f *fakeOAuthStore) ConsumeState(stateHash, provider string) (*models.OAuthState, error) {
+	state, ok := f.states[stateHash]
+	if !ok || state.Provider != provider || state.ExpiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+	delete(f.states, stateHash)
+	return state, nil
+}
+
+func (f *fakeOAuthStore) CreateLoginCode(codeHash string, userID uuid.UUID, provider string, isNew bool, expiresAt time.Time) error {
+	f.loginCodes[codeHash] = &models.OAuthLoginCode{UserID: userID, Provider: provider, IsNew: isNew}
+	return nil
+}
+
+func (f *fakeOAuthStore) ConsumeLoginCode(codeHash string) (*models.OAuthLoginCode, error) {
+	code, ok := f.loginCodes[codeHash]
+	if !ok {
+		return nil, nil
+	}
+	delete(f.loginCodes, codeHash)
+	return code, nil
+}
+
+func (f *fakeOAuthStore) FindUserIDByIdentity(provider, subject string) (uuid.UUID, error) {
+	return f.identities[provider+"/"+subject], nil
+}
+
+func (f *fakeOAuthStore) LinkIdentity(userID uuid.UUID, provider, subject, email string) error {
+	f.identities[provider+"/"+subject] = userID
+	return nil
+}
+
+type fakeUserStore struct {
+	users map[uuid.UUID]*models.User
+}
+
+func (f *fakeUserStore) add(user models.User) *models.User {
+	user.ID = uuid.New()
+	f.users[user.ID] = &user
+	return &user
+}
+
+func (f *fakeUserStore) Create(email string, emailVerified bool) (*models.User, error) {
+	user := f.add(models.User{Email: email, EmailVerified: emailVerified})
+	copied := *user
+	return &copied, nil
+}
+
+func (f *fakeUserStore) FindByID(id uuid.UUID) (*models.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (f *fakeUserStore) FindByEmail(email string) (*models.User, error) {
+	for _, user := range f.users {
+		if user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUserStore) ClaimUnverified(userID uuid.UUID) error {
+	f.users[userID].EmailVerified = true
+	f.users[userID].PasswordHash = sql.NullString{}
+	return nil
+}
+
+type fakeSessionRevoker struct {
+	revoked []uuid.UUID
+}
+
+func (f *fakeSessionRevoker) RevokeAllSessions(userID uuid.UUID) error {
+	f.revoked = append(f.revoked, userID)
+	return nil
+}
+
+type oauthTest struct {
+	issuer   *authtest.Issuer
+	service  *OAuthService
+	oauth    *fakeOAuthStore
+	users    *fakeUserStore
+	sessions *fakeSessionRevoker
+}
+
+func newOAuthTest(t *testing.T) *oauthTest {
+	issuer := authtest.NewIssuer(t)
+	provider := auth.NewOIDCProvider(auth.OIDCConfig{
+		Name:         "test",
+		IssuerURL:    issuer.URL,
+		ClientID:     authtest.ClientID,
+		ClientSecret: authtest.ClientSecret,
+		RedirectURL:  "http://localhost/callback",
+	})
+
+	tt := &oauthTest{
+		issuer:   issuer,
+		oauth:    newFakeOAuthStore(),
+		users:    &fakeUserStore{users: make(map[uuid.UUID]*models.User)},
+		sessions: &fakeSessionRevoker{},
+	}
+	tt.service = NewOAuthService(auth.NewProviders(provider), tt.oauth, tt.users, tt.sessions)
+	return tt
+}
+
+// signIn runs the whole flow: redirect to the provider, the user approving,
+// the callback and the frontend exchanging its login code
+func (tt *oauthTest) signIn(t *testing.T, user authtest.User) (*models.User, *models.OAuthLoginCode, error) {
+	t.Helper()
+
+	ctx := context.Background()
+	authURL, state, err := tt.service.BeginLogin(ctx, "test")
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	code, err := tt.issuer.Authorize(authURL, user)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	loginCode, err := tt.service.CompleteLogin(ctx, "test", state, code)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tt.service.ExchangeLoginCode(loginCode)
+}
+
+func TestOAuthSignInCreatesUser(t *testing.T) {
+	tt := newOAuthTest(t)
+
+	user, loginCode, err := tt.signIn(t, authtest.User{Subject: "subject-1", Email: "new@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("sign in: %v", err)
+	}
+	if !loginCode.IsNew || user.Email != "new@example.com" || !user.EmailVerified {
+		t.Errorf("got user %+v with login code %+v, want a new verified user", user, loginCode)
+	}
+
+	// Signing in again finds the same account through the linked identity
+	again, loginCode, err := tt.signIn(t, authtest.User{Subject: "subject-1", Email: "new@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("second sign in: %v", err)
+	}
+	if again.ID != user.ID || loginCode.IsNew {
+		t.Errorf("second sign in got user %s (new: %v), want existing user %s", again.ID, loginCode.IsNew, user.ID)
+	}
+}
+
+func TestOAuthSignInLinksExistingUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    models.User
+		wantRevoked bool
+	}{
+		{
+			name: "verified account keeps its password",
+			existing: models.User{
+				Email:         "member@example.com",
+				EmailVerified: true,
+				PasswordHash:  sql.NullString{String: "hash", Valid: true},
+			},
+		},
+		{
+			name: "unverified account loses its password and sessions",
+			existing: models.User{
+				Email:        "member@example.com",
+				PasswordHash: sql.NullString{String: "hash", Valid: true},
+			},
+			wantRevoked: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := newOAuthTest(t)
+			existing := tt.users.add(tc.existing)
+
+			user, loginCode, err := tt.signIn(t, authtest.User{Subject: "subject-1", Email: "member@example.com", EmailVerified: true})
+			if err != nil {
+				t.Fatalf("sign in: %v", err)
+			}
+			if user.ID != existing.ID || loginCode.IsNew {
+				t.Fatalf("signed in as %s (new: %v), want existing user %s", user.ID, loginCode.IsNew, existing.ID)
+			}
+			if tt.oauth.identities["test/subject-1"] != existing.ID {
+				t.Error("identity was not linked to the existing user")
+			}
+
+			stored := tt.users.users[existing.ID]
+			if !stored.EmailVerified {
+				t.Error("email is not marked verified")
+			}
+			if stored.PasswordHash.Valid == tc.wantRevoked {
+				t.Errorf("password kept = %v, want %v", stored.PasswordHash.Valid, !tc.wantRevoked)
+			}
+			if revoked := len(tt.sessions.revoked) == 1 && tt.sessions.revoked[0] == existing.ID; revoked != tc.wantRevoked {
+				t.Errorf("sessions revoked = %v, want %v", tt.sessions.revoked, tc.wantRevoked)
+			}
+		})
+	}
+}
+
+func TestOAuthSignInRejectsUnverifiedEmail(t *testing.T) {
+	tt := newOAuthTest(t)
+	existing := tt.users.add(models.User{Email: "member@example.com", EmailVerified: true})
+
+	_, _, err := tt.signIn(t, authtest.User{Subject: "subject-1", Email: "member@example.com", EmailVerified: false})
+	if !errors.Is(err, ErrUnverifiedIdentity) {
+		t.Fatalf("err = %v, want ErrUnverifiedIdentity", err)
+	}
+	if len(tt.oauth.identities) != 0 {
+		t.Errorf("identity was linked to %s", existing.ID)
+	}
+}
+
+func TestOAuthCompleteLoginChecksState(t *testing.T) {
+	tt := newOAuthTest(t)
+	ctx := context.Background()
+	user := authtest.User{Subject: "subject-1", Email: "member@example.com", EmailVerified: true}
+
+	authURL, state, err := tt.service.BeginLogin(ctx, "test")
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	parsed, _ := url.Parse(authURL)
+	if parsed.Query().Get("state") != state {
+		t.Fatalf("authorization URL carries state %q, want %q", parsed.Query().Get("state"), state)
+	}
+
+	code, err := tt.issuer.Authorize(authURL, user)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if _, err := tt.service.CompleteLogin(ctx, "test", "forged-state", code); !errors.Is(err, ErrInvalidOAuthState) {
+		t.Errorf("forged state: err = %v, want ErrInvalidOAuthState", err)
+	}
+
+	if _, err := tt.service.CompleteLogin(ctx, "test", state, code); err != nil {
+		t.Fatalf("CompleteLogin: %v", err)
+	}
+
+	// States are single use, so a replayed callback fails
+	code, err = tt.issuer.Authorize(authURL, user)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if _, err := tt.service.CompleteLogin(ctx, "test", state, code); !errors.Is(err, ErrInvalidOAuthState) {
+		t.Errorf("replayed state: err = %v, want ErrInvalidOAuthState", err)
+	}
+}
+
+func TestOAuthLoginCodeIsSingleUse(t *testing.T) {
+	tt := newOAuthTest(t)
+	ctx := context.Background()
+
+	authURL, state, err := tt.service.BeginLogin(ctx, "test")
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	code, err := tt.issuer.Authorize(authURL, authtest.User{Subject: "subject-1", Email: "member@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	loginCode, err := tt.service.CompleteLogin(ctx, "test", state, code)
+	if err != nil {
+		t.Fatalf("CompleteLogin: %v", err)
+	}
+
+	if _, _, err := tt.service.ExchangeLoginCode(loginCode); err != nil {
+		t.Fatalf("ExchangeLoginCode: %v", err)
+	}
+	if _, _, err := tt.service.ExchangeLoginCode(loginCode); !errors.Is(err, ErrInvalidLoginCode) {
+		t.Errorf("second exchange: err = %v, want ErrInvalidLoginCode", err)
+	}
+}
@@ -1,6 +1,10 @@
 package services
 
 import (
+	"encoding/json"
+	"log"
+	"time"
+
 	"github.com/google/uuid"
 
 	"heyspoilme/internal/models"
@@ -8,52 +12,235 @@ import (
 	"heyspoilme/internal/websocket"
 )
 
+const (
+	// presenceTTL is how long a server's record of a user's connection lasts
+	// without a heartbeat. Clients send one every 30 seconds, but browsers can
+	// slow background tabs down to once a minute.
+	presenceTTL           = 2 * time.Minute
+	presenceSweepInterval = 30 * time.Second
+)
+
+// visibilityFinder tells which users someone may follow the presence of. It is
+// satisfied by *repository.PresenceRepository.
+type visibilityFinder interface {
+	VisibleUsers(viewerID uuid.UUID, candidates []uuid.UUID) ([]uuid.UUID, error)
+}
+
 type PresenceService struct {
 	presenceRepo *repository.PresenceRepository
+	visibility   visibilityFinder
+	userRepo     *repository.UserRepository
 	hub          *websocket.Hub
+	stopChan     chan struct{}
 }
 
-func NewPresenceService(presenceRepo *repository.PresenceRepository, hub *websocket.Hub) *PresenceService {
+func NewPresenceService(presenceRepo *repository.PresenceRepository, userRepo *repository.UserRepository, hub *websocket.Hub) *PresenceService {
 	return &PresenceService{
 		presenceRepo: presenceRepo,
+		visibility:   presenceRepo,
+		userRepo:     userRepo,
 		hub:          hub,
+		stopChan:     make(chan struct{}),
 	}
 }
 
+// SetOnline records the user's connections on this server and tells their
+// audience if they just came online
 func (s *PresenceService) SetOnline(userID uuid.UUID) error {
-	err := s.presenceRepo.SetOnline(userID)
+	changed, err := s.presenceRepo.Connect(userID, s.hub.NodeID())
 	if err != nil {
 		return err
 	}
 
-	s.hub.BroadcastToAll(&models.WSMessage{
-		Type: models.WSTypePresence,
-		Payload: models.WSPresencePayload{
-			UserID:   userID,
-			IsOnline: true,
-		},
-	})
-
+	if changed {
+		s.broadcastPresence(userID, true)
+	}
 	return nil
 }
 
+// SetOffline records that this server has no connections of the user left. The
+// user only goes offline if no other server has any either.
 func (s *PresenceService) SetOffline(userID uuid.UUID) error {
-	err := s.presenceRepo.SetOffline(userID)
+	changed, err := s.presenceRepo.Disconnect(userID, s.hub.NodeID())
 	if err != nil {
 		return err
 	}
 
-	s.hub.BroadcastToAll(&models.WSMessage{
+	if changed {
+		s.broadcastPresence(userID, false)
+	}
+	return nil
+}
+
+// broadcastPresence tells the user's conversation partners and mutual likes,
+// plus anyone with their profile on screen, that they came online or went
+// offline. Nobody else learns about it, and nobody at all in invisible mode.
+func (s *PresenceService) broadcastPresence(userID uuid.UUID, online bool) {
+	settings, err := s.userRepo.GetPrivacySettings(userID)
+	if err != nil {
+		log.Printf("[Presence] Failed to load privacy settings for user %s: %v", userID, err)
+		return
+	}
+	if settings == nil || settings.HideOnlineStatus {
+		return
+	}
+
+	s.sendPresence(userID, online)
+}
+
+// OnlineStatusHidden is called when the user turns invisible mode on or off.
+// If they are online, their audience sees them go offline or come back.
+func (s *PresenceService) OnlineStatusHidden(userID uuid.UUID, hidden bool) error {
+	presence, err := s.presenceRepo.GetPresence(userID)
+	if err != nil {
+		return err
+	}
+
+	if presence != nil && presence.IsOnline {
+		s.sendPresence(userID, !hidden)
+	}
+	return nil
+}
+
+func (s *PresenceService) sendPresence(userID uuid.UUID, online bool) {
+	audience, err := s.presenceRepo.ConnectedAudience(userID)
+	if err != nil {
+		// Subscribers are still told
+		log.Printf("[Presence] Failed to load audience for user %s: %v", userID, err)
+	}
+
+	s.hub.BroadcastToAudience(userID, audience, &models.WSMessage{
 		Type: models.WSTypePresence,
 		Payload: models.WSPresencePayload{
 			UserID:   userID,
-			IsOnline: false,
+			IsOnline: online,
 		},
 	})
+}
+
+// UpdatePresence is called by the hub when the user's first connection on this
+// server opens or their last one closes
+func (s *PresenceService) UpdatePresence(userID uuid.UUID, online bool) {
+	var err error
+	if online {
+		err = s.SetOnline(userID)
+	} else {
+		err = s.SetOffline(userID)
+	}
+	if err != nil {
+		log.Printf("[Presence] Failed to update presence for user %s: %v", userID, err)
+	}
+}
 
+// UpdateConnection is called by the hub when any connection opens or closes,
+// to keep the list of connections shown with each session current
+func (s *PresenceService) UpdateConnection(client *websocket.Client, open bool) {
+	var err error
+	if open {
+		err = s.presenceRepo.SaveConnection(client.UserID, s.hub.NodeID(), client.Connection())
+	} else {
+		err = s.presenceRepo.DeleteConnection(client.ID)
+	}
+	if err != nil {
+		log.Printf("[Presence] Failed to update connection %s of user %s: %v", client.ID, client.UserID, err)
+	}
+}
+
+// Heartbeat records that a connected client is still there. A user the sweeper
+// already dropped, say after the database was briefly unreachable, is brought
+// back online.
+func (s *PresenceService) Heartbeat(client *websocket.Client) error {
+	if err := s.presenceRepo.SaveConnection(client.UserID, s.hub.NodeID(), client.Connection()); err != nil {
+		return err
+	}
+
+	found, err := s.presenceRepo.Heartbeat(client.UserID, s.hub.NodeID())
+	if err != nil {
+		return err
+	}
+	if !found {
+		return s.SetOnline(client.UserID)
+	}
 	return nil
 }
 
+// Start reconciles presence left behind by earlier runs, then sweeps users
+// whose heartbeats stopped, such as those connected to a server that crashed
+func (s *PresenceService) Start() {
+	offline, err := s.presenceRepo.Reconcile()
+	if err != nil {
+		log.Printf("[Presence] Failed to reconcile presence: %v", err)
+	}
+	s.announceOffline(offline)
+
+	ticker := time.NewTicker(presenceSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.sweep()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// Stop stops the sweeper
+func (s *PresenceService) Stop() {
+	close(s.stopChan)
+}
+
+func (s *PresenceService) sweep() {
+	offline, err := s.presenceRepo.SweepStale(time.Now().UTC().Add(-presenceTTL))
+	if err != nil {
+		log.Printf("[Presence] Failed to sweep stale presence: %v", err)
+	}
+	s.announceOffline(offline)
+}
+
+func (s *PresenceService) announceOffline(userIDs []uuid.UUID) {
+	if len(userIDs) > 0 {
+		log.Printf("[Presence] Marked %d users without heartbeats offline", len(userIDs))
+	}
+	for _, userID := range userIDs {
+		s.broadcastPresence(userID, false)
+	}
+}
+
+// RegisterCommands routes the presence commands clients send over WebSocket
+func (s *PresenceService) RegisterCommands(d *websocket.Dispatcher) {
+	d.Register(models.WSTypeHeartbeat, func(client *websocket.Client, _ json.RawMessage) error {
+		return s.Heartbeat(client)
+	})
+	d.Register(models.WSTypePresenceSubscribe, func(client *websocket.Client, payload json.RawMessage) error {
+		var cmd models.WSPresenceSubscribePayload
+		if err := websocket.DecodePayload(payload, &cmd); err != nil {
+			return err
+		}
+		return s.Subscribe(client, cmd.UserIDs)
+	})
+	d.Register(models.WSTypePresenceUnsubscribe, func(client *websocket.Client, payload json.RawMessage) error {
+		var cmd models.WSPresenceSubscribePayload
+		if err := websocket.DecodePayload(payload, &cmd); err != nil {
+			return err
+		}
+		s.hub.Unsubscribe(client, cmd.UserIDs)
+		return nil
+	})
+}
+
+// Subscribe has the connection follow the presence of profiles it has on
+// screen. Accounts that aren't active and members in invisible mode are left
+// out.
+func (s *PresenceService) Subscribe(client *websocket.Client, userIDs []uuid.UUID) error {
+	visible, err := s.visibility.VisibleUsers(client.UserID, userIDs)
+	if err != nil {
+		return err
+	}
+	return s.hub.Subscribe(client, visible)
+}
+
 func (s *PresenceService) GetPresence(userID uuid.UUID) (*models.UserPresence, error) {
 	return s.presenceRepo.GetPresence(userID)
 }
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/internal/websocket"
+)
+
+// fakeVisibility lets anyone follow the users in the set
+type fakeVisibility map[uuid.UUID]bool
+
+func (f fakeVisibility) VisibleUsers(viewerID uuid.UUID, candidates []uuid.UUID) ([]uuid.UUID, error) {
+	var visible []uuid.UUID
+	for _, candidate := range candidates {
+		if f[candidate] && candidate != viewerID {
+			visible = append(visible, candidate)
+		}
+	}
+	return visible, nil
+}
+
+func TestPresenceSubscribeOnlyVisibleUsers(t *testing.T) {
+	hub := websocket.NewHub(websocket.NewMemoryBackplane())
+	go hub.Run()
+
+	// The stranger has no conversation or like with the viewer, but their
+	// profile can be opened. The invisible member's can't be followed.
+	viewerID, strangerID, invisibleID := uuid.New(), uuid.New(), uuid.New()
+	s := &PresenceService{
+		visibility: fakeVisibility{strangerID: true},
+		hub:        hub,
+	}
+
+	viewer := &websocket.Client{ID: uuid.New(), UserID: viewerID, Send: make(chan *models.WSMessage, 8)}
+	hub.Register(viewer)
+
+	if err := s.Subscribe(viewer, []uuid.UUID{strangerID, invisibleID}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// Events go through the backplane in order, so the invisible member's would
+	// arrive before the stranger's if the subscription had been kept
+	for _, userID := range []uuid.UUID{invisibleID, strangerID} {
+		hub.BroadcastToAudience(userID, nil, &models.WSMessage{
+			Type:    models.WSTypePresence,
+			Payload: models.WSPresencePayload{UserID: userID, IsOnline: true},
+		})
+	}
+
+	select {
+	case message := <-viewer.Send:
+		payload := message.Payload.(models.WSPresencePayload)
+		if payload.UserID != strangerID {
+			t.Errorf("got presence of %s, want only the visible profile %s", payload.UserID, strangerID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no presence update for the visible profile")
+	}
+
+	select {
+	case message := <-viewer.Send:
+		t.Errorf("unexpected message %+v", message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
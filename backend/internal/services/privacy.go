@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"log"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/internal/repository"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+type PrivacyService struct {
+	userRepo        *repository.UserRepository
+	presenceService *PresenceService
+}
+
+func NewPrivacyService(userRepo *repository.UserRepository, presenceService *PresenceService) *PrivacyService {
+	return &PrivacyService{
+		userRepo:        userRepo,
+		presenceService: presenceService,
+	}
+}
+
+func (s *PrivacyService) GetSettings(userID uuid.UUID) (*models.PrivacySettings, error) {
+	settings, err := s.userRepo.GetPrivacySettings(userID)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, ErrUserNotFound
+	}
+	return settings, nil
+}
+
+// UpdateSettings applies the fields present in the request and returns the result
+func (s *PrivacyService) UpdateSettings(userID uuid.UUID, req *models.UpdatePrivacySettingsRequest) (*models.PrivacySettings, error) {
+	settings, err := s.GetSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	wasHidden := settings.HideOnlineStatus
+	if req.ReadReceipts != nil {
+		settings.ReadReceipts = *req.ReadReceipts
+	}
+	if req.HideOnlineStatus != nil {
+		settings.HideOnlineStatus = *req.HideOnlineStatus
+	}
+	if req.LastSeen != nil {
+		settings.LastSeen = *req.LastSeen
+	}
+
+	if err := s.userRepo.UpdatePrivacySettings(userID, settings); err != nil {
+		return nil, err
+	}
+
+	if settings.HideOnlineStatus != wasHidden {
+		if err := s.presenceService.OnlineStatusHidden(userID, settings.HideOnlineStatus); err != nil {
+			log.Printf("[Privacy] Failed to update presence for user %s: %v", userID, err)
+		}
+	}
+	return settings, nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"heyspoilme/internal/repository"
+	"heyspoilme/pkg/storage"
+)
+
+const (
+	s3CleanupBatchSize = 100
+	// Claimed items are hidden from other workers for this long
+	s3CleanupLease = 5 * time.Minute
+	// Retries back off exponentially from the base delay up to the max
+	s3CleanupBaseDelay = 1 * time.Minute
+	s3CleanupMaxDelay  = 24 * time.Hour
+)
+
+// S3CleanupJobService drains the S3 cleanup queue, retrying failed deletes with backoff
+type S3CleanupJobService struct {
+	cleanupRepo *repository.S3CleanupRepository
+	s3Client    *storage.S3Client
+	interval    time.Duration
+	stopChan    chan struct{}
+}
+
+func NewS3CleanupJobService(cleanupRepo *repository.S3CleanupRepository, s3Client *storage.S3Client) *S3CleanupJobService {
+	return &S3CleanupJobService{
+		cleanupRepo: cleanupRepo,
+		s3Client:    s3Client,
+		interval:    1 * time.Minute,
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Start begins the background job. Without S3 the queue is left untouched
+// until a server with S3 configured picks it up.
+func (s *S3CleanupJobService) Start() {
+	if s.s3Client == nil {
+		log.Printf("[S3Cleanup] S3 not configured, skipping cleanup job")
+		return
+	}
+
+	log.Printf("[S3Cleanup] Starting S3 cleanup job (checking every %v)", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	// Run immediately on start
+	s.drain()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.drain()
+		case <-s.stopChan:
+			log.Printf("[S3Cleanup] Stopping S3 cleanup job")
+			return
+		}
+	}
+}
+
+// Stop stops the background job
+func (s *S3CleanupJobService) Stop() {
+	close(s.stopChan)
+}
+
+func (s *S3CleanupJobService) drain() {
+	for {
+		items, err := s.cleanupRepo.ClaimDue(s3CleanupBatchSize, s3CleanupLease)
+		if err != nil {
+			log.Printf("[S3Cleanup] Error claiming queue items: %v", err)
+			return
+		}
+
+		for _, item := range items {
+			// Deleting a missing object succeeds, so retries are safe
+			if err := s.s3Client.DeleteObject(item.S3Key); err != nil {
+				next := time.Now().UTC().Add(s3CleanupBackoff(item.Attempts + 1))
+				log.Printf("[S3Cleanup] Failed to delete %s (attempt %d), retrying at %s: %v",
+					item.S3Key, item.Attempts+1, next.Format(time.RFC3339), err)
+				if err := s.cleanupRepo.Fail(item.ID, err.Error(), next); err != nil {
+					log.Printf("[S3Cleanup] Failed to record attempt for %s: %v", item.S3Key, err)
+				}
+				continue
+			}
+
+			if err := s.cleanupRepo.Complete(item.ID); err != nil {
+				log.Printf("[S3Cleanup] Failed to remove %s from queue: %v", item.S3Key, err)
+			}
+		}
+
+		if len(items) < s3CleanupBatchSize {
+			return
+		}
+	}
+}
+
+// s3CleanupBackoff returns the delay before the given attempt number
+func s3CleanupBackoff(attempts int) time.Duration {
+	delay := s3CleanupBaseDelay
+	for i := 1; i < attempts && delay < s3CleanupMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > s3CleanupMaxDelay {
+		delay = s3CleanupMaxDelay
+	}
+	return delay
+}
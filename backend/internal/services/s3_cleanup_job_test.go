@@ -0,0 +1,27 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestS3CleanupBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, s3CleanupBaseDelay},
+		{1, s3CleanupBaseDelay},
+		{2, 2 * s3CleanupBaseDelay},
+		{3, 4 * s3CleanupBaseDelay},
+		{11, 1024 * s3CleanupBaseDelay},
+		{12, s3CleanupMaxDelay},
+		{1000, s3CleanupMaxDelay},
+	}
+
+	for _, tt := range tests {
+		if got := s3CleanupBackoff(tt.attempts); got != tt.want {
+			t.Errorf("s3CleanupBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/internal/repository"
+	"heyspoilme/internal/websocket"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// userSessionStore lists and revokes a user's sessions. It is satisfied by
+// *repository.SessionRepository.
+type userSessionStore interface {
+	ListActiveForUser(userID uuid.UUID) ([]models.Session, error)
+	RevokeForUser(sessionID, userID uuid.UUID) (bool, error)
+	RevokeAllForUser(userID uuid.UUID) error
+}
+
+// SessionService manages a user's signed-in devices. Revoking a session also
+// closes any WebSocket connections that were opened with it.
+type SessionService struct {
+	sessionRepo  userSessionStore
+	presenceRepo *repository.PresenceRepository
+	hub          *websocket.Hub
+}
+
+func NewSessionService(sessionRepo *repository.SessionRepository, presenceRepo *repository.PresenceRepository, hub *websocket.Hub) *SessionService {
+	return &SessionService{
+		sessionRepo:  sessionRepo,
+		presenceRepo: presenceRepo,
+		hub:          hub,
+	}
+}
+
+// ListSessions returns the user's active sessions with their live WebSocket
+// connections on every server
+func (s *SessionService) ListSessions(userID, currentSessionID uuid.UUID) ([]models.SessionWithConnections, error) {
+	sessions, err := s.sessionRepo.ListActiveForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := s.presenceRepo.ListConnections(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	connectionsBySession := make(map[uuid.UUID][]models.WSConnection)
+	for _, conn := range live {
+		connectionsBySession[conn.SessionID] = append(connectionsBySession[conn.SessionID], conn)
+	}
+
+	result := make([]models.SessionWithConnections, 0, len(sessions))
+	for _, session := range sessions {
+		connections := connectionsBySession[session.ID]
+		if connections == nil {
+			connections = []models.WSConnection{}
+		}
+		result = append(result, models.SessionWithConnections{
+			Session:     session,
+			Current:     session.ID == currentSessionID,
+			Connections: connections,
+		})
+	}
+
+	return result, nil
+}
+
+// RevokeSession signs out one of the user's devices
+func (s *SessionService) RevokeSession(userID, sessionID uuid.UUID) error {
+	revoked, err := s.sessionRepo.RevokeForUser(sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrSessionNotFound
+	}
+
+	s.hub.DisconnectSession(userID, sessionID)
+	return nil
+}
+
+// RevokeAllSessions signs the user out on every device
+func (s *SessionService) RevokeAllSessions(userID uuid.UUID) error {
+	if err := s.sessionRepo.RevokeAllForUser(userID); err != nil {
+		return err
+	}
+
+	s.hub.DisconnectUser(userID)
+	return nil
+}
+
+// DisconnectUser closes the user's open connections once their sessions have
+// been revoked some other way, such as by a password reset
+func (s *SessionService) DisconnectUser(userID uuid.UUID) {
+	s.hub.DisconnectUser(userID)
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/pkg/totp"
+)
+
+const (
+	twoFactorIssuer          = "HeySpoilMe"
+	twoFactorChallengeTTL    = 5 * time.Minute
+	maxTwoFactorAttempts     = 5
+	recoveryCodeCount        = 10
+	recoveryCodeLength       = 10
+	recoveryCodeGroupLength  = 5
+	recoveryCodeRandomLength = 8
+)
+
+var (
+	ErrTwoFactorUnavailable     = errors.New("two-factor authentication is only available for password accounts")
+	ErrTwoFactorAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled      = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorSetupNotStarted = errors.New("two-factor setup has not been started")
+	ErrInvalidTwoFactorCode     = errors.New("invalid authentication code")
+	ErrInvalidTwoFactorPassword = errors.New("invalid password")
+	ErrInvalidChallenge         = errors.New("invalid or expired sign in challenge")
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// twoFactorStore keeps enrollments, recovery codes and signin challenges. It
+// is satisfied by *repository.TwoFactorRepository.
+type twoFactorStore interface {
+	Find(userID uuid.UUID) (*models.TwoFactor, error)
+	SavePending(userID uuid.UUID, secret string, now time.Time) error
+	Enable(userID uuid.UUID, step int64, recoveryCodeHashes []string, now time.Time) (bool, error)
+	UseStep(userID uuid.UUID, step int64, now time.Time) (bool, error)
+	Disable(userID uuid.UUID) error
+	ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string, now time.Time) error
+	UseRecoveryCode(userID uuid.UUID, codeHash string, now time.Time) (bool, error)
+	CreateChallenge(userID uuid.UUID, method models.AuthMethod, tokenHash string, expiresAt, now time.Time) error
+	FindActiveChallenge(tokenHash string, now time.Time) (*models.TwoFactorChallenge, error)
+	UseChallengeAttempt(challengeID uuid.UUID, maxAttempts int) (bool, error)
+	CompleteChallenge(challengeID uuid.UUID, now time.Time) (bool, error)
+}
+
+// userFinder looks users up by ID. It is satisfied by *repository.UserRepository.
+type userFinder interface {
+	FindByID(id uuid.UUID) (*models.User, error)
+}
+
+// TwoFactorService handles TOTP enrollment and the second signin step.
+// All time checks go through now so tests can drive the clock.
+type TwoFactorService struct {
+	twoFactorRepo twoFactorStore
+	userRepo      userFinder
+	now           func() time.Time
+}
+
+func NewTwoFactorService(twoFactorRepo twoFactorStore, userRepo userFinder) *TwoFactorService {
+	return &TwoFactorService{
+		twoFactorRepo: twoFactorRepo,
+		userRepo:      userRepo,
+		now:           func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// IsEnabled reports whether signin requires a second factor for the user
+func (s *TwoFactorService) IsEnabled(userID uuid.UUID) (bool, error) {
+	tf, err := s.twoFactorRepo.Find(userID)
+	if err != nil {
+		return false, err
+	}
+	return tf != nil && tf.EnabledAt != nil, nil
+}
+
+// BeginSetup creates a new pending secret. Enrollment only takes effect once
+// ConfirmSetup is called with a code from the authenticator app.
+func (s *TwoFactorService) BeginSetup(userID uuid.UUID) (*models.TwoFactorSetup, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	if !user.PasswordHash.Valid {
+		return nil, ErrTwoFactorUnavailable
+	}
+
+	enabled, err := s.IsEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, errors.New("failed to generate secret")
+	}
+
+	if err := s.twoFactorRepo.SavePending(userID, secret, s.now()); err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(twoFactorIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmSetup enables two-factor authentication and returns the recovery codes.
+// The codes are only ever shown here; just their hashes are stored.
+func (s *TwoFactorService) ConfirmSetup(userID uuid.UUID, code string) ([]string, error) {
+	tf, err := s.twoFactorRepo.Find(userID)
+	if err != nil {
+		return nil, err
+	}
+	if tf == nil {
+		return nil, ErrTwoFactorSetupNotStarted
+	}
+	if tf.EnabledAt != nil {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	now := s.now()
+	step, ok := totp.Validate(tf.Secret, code, now)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	enabled, err := s.twoFactorRepo.Enable(userID, step, hashes, now)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off. It requires both the password
+// and a current code (or recovery code).
+func (s *TwoFactorService) Disable(userID uuid.UUID, password, code string) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+	if !user.PasswordHash.Valid || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash.String), []byte(password)) != nil {
+		return ErrInvalidTwoFactorPassword
+	}
+
+	if err := s.verifyCode(userID, code, true); err != nil {
+		return err
+	}
+
+	return s.twoFactorRepo.Disable(userID)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes. It requires a
+// code from the authenticator app.
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID uuid.UUID, code string) ([]string, error) {
+	if err := s.verifyCode(userID, code, false); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.twoFactorRepo.ReplaceRecoveryCodes(userID, hashes, s.now()); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// CreateChallenge issues the token signin returns in place of a JWT while the
+// second factor is pending. The method the first factor was passed with is kept
+// for the session started once the challenge succeeds.
+func (s *TwoFactorService) CreateChallenge(userID uuid.UUID, method models.AuthMethod) (string, int, error) {
+	token, err := generateVerificationToken()
+	if err != nil {
+		return "", 0, errors.New("failed to generate challenge token")
+	}
+
+	now := s.now()
+	if err := s.twoFactorRepo.CreateChallenge(userID, method, hashToken(token), now.Add(twoFactorChallengeTTL), now); err != nil {
+		return "", 0, err
+	}
+
+	return token, int(twoFactorChallengeTTL.Seconds()), nil
+}
+
+// VerifyChallenge completes signin with a TOTP or recovery code and returns the
+// user along with how they passed the first factor. Each challenge allows a
+// handful of attempts and can only succeed once.
+func (s *TwoFactorService) VerifyChallenge(token, code string) (*models.User, models.AuthMethod, error) {
+	now := s.now()
+
+	challenge, err := s.twoFactorRepo.FindActiveChallenge(hashToken(token), now)
+	if err != nil {
+		return nil, "", err
+	}
+	if challenge == nil {
+		return nil, "", ErrInvalidChallenge
+	}
+
+	// Claim an attempt before looking at the code, so parallel requests can't
+	// each check the count and guess past the limit
+	allowed, err := s.twoFactorRepo.UseChallengeAttempt(challenge.ID, maxTwoFactorAttempts)
+	if err != nil {
+		return nil, "", err
+	}
+	if !allowed {
+		return nil, "", ErrInvalidChallenge
+	}
+
+	if err := s.verifyCode(challenge.UserID, code, true); err != nil {
+		return nil, "", err
+	}
+
+	completed, err := s.twoFactorRepo.CompleteChallenge(challenge.ID, now)
+	if err != nil {
+		return nil, "", err
+	}
+	if !completed {
+		return nil, "", ErrInvalidChallenge
+	}
+
+	user, err := s.userRepo.FindByID(challenge.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	if user == nil {
+		return nil, "", ErrInvalidChallenge
+	}
+
+	return user, challenge.AuthMethod, nil
+}
+
+// verifyCode accepts a TOTP code, or a recovery code when allowRecovery is set.
+// Accepted codes are consumed so they can't be used again.
+func (s *TwoFactorService) verifyCode(userID uuid.UUID, code string, allowRecovery bool) error {
+	tf, err := s.twoFactorRepo.Find(userID)
+	if err != nil {
+		return err
+	}
+	if tf == nil || tf.EnabledAt == nil {
+		return ErrTwoFactorNotEnabled
+	}
+
+	now := s.now()
+	code = strings.TrimSpace(code)
+
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(tf.Secret, code, now)
+		if !ok || step <= tf.LastUsedStep {
+			return ErrInvalidTwoFactorCode
+		}
+		used, err := s.twoFactorRepo.UseStep(userID, step, now)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	if !allowRecovery {
+		return ErrInvalidTwoFactorCode
+	}
+
+	used, err := s.twoFactorRepo.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)), now)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// generateRecoveryCodes returns codes formatted for display (xxxxx-xxxxx)
+// together with the hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		bytes := make([]byte, recoveryCodeRandomLength)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, nil, errors.New("failed to generate recovery codes")
+		}
+
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(bytes))[:recoveryCodeLength]
+		codes = append(codes, raw[:recoveryCodeGroupLength]+"-"+raw[recoveryCodeGroupLength:])
+		hashes = append(hashes, hashToken(raw))
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode strips the formatting users may or may not type back in
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
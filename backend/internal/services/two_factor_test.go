@@ -0,0 +1,382 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+	"heyspoilme/pkg/totp"
+)
+
+// fakeTwoFactorStore keeps enrollments in memory with the same conditional
+// updates as the Postgres repository
+type fakeTwoFactorStore struct {
+	enrollments map[uuid.UUID]*models.TwoFactor
+	recovery    map[uuid.UUID]map[string]bool
+	challenges  map[string]*models.TwoFactorChallenge
+}
+
+func newFakeTwoFactorStore() *fakeTwoFactorStore {
+	return &fakeTwoFactorStore{
+		enrollments: make(map[uuid.UUID]*models.TwoFactor),
+		recovery:    make(map[uuid.UUID]map[string]bool),
+		challenges:  make(map[string]*models.TwoFactorChallenge),
+	}
+}
+
+func (f *fakeTwoFactorStore) Find(userID uuid.UUID) (*models.TwoFactor, error) {
+	tf, ok := f.enrollments[userID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *tf
+	return &copied, nil
+}
+
+func (f *fakeTwoFactorStore) SavePending(userID uuid.UUID, secret string, now time.Time) error {
+	if tf, ok := f.enrollments[userID]; ok && tf.EnabledAt != nil {
+		return nil
+	}
+	f.enrollments[userID] = &models.TwoFactor{UserID: userID, Secret: secret, CreatedAt: now}
+	return nil
+}
+
+func (f *fakeTwoFactorStore) Enable(userID uuid.UUID, step int64, recoveryCodeHashes []string, now time.Time) (bool, error) {
+	tf, ok := f.enrollments[userID]
+	if !ok || tf.EnabledAt != nil {
+		return false, nil
+	}
+	tf.EnabledAt = &now
+	tf.LastUsedStep = step
+	return true, f.ReplaceRecoveryCodes(userID, recoveryCodeHashes, now)
+}
+
+func (f *fakeTwoFactorStore) UseStep(userID uuid.UUID, step int64, now time.Time) (bool, error) {
+	tf, ok := f.enrollments[userID]
+	if !ok || tf.EnabledAt == nil || tf.LastUsedStep >= step {
+		return false, nil
+	}
+	tf.LastUsedStep = step
+	return true, nil
+}
+
+func (f *fakeTwoFactorStore) Disable(userID uuid.UUID) error {
+	delete(f.enrollments, userID)
+	delete(f.recovery, userID)
+	return nil
+}
+
+func (f *fakeTwoFactorStore) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string, now time.Time) error {
+	codes := make(map[string]bool, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes[hash] = false
+	}
+	f.recovery[userID] = codes
+	return nil
+}
+
+func (f *fakeTwoFactorStore) UseRecoveryCode(userID uuid.UUID, codeHash string, now time.Time) (bool, error) {
+	used, ok := f.recovery[userID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	f.recovery[userID][codeHash] = true
+	return true, nil
+}
+
+func (f *fakeTwoFactorStore) CreateChallenge(userID uuid.UUID, method models.AuthMethod, tokenHash string, expiresAt, now time.Time) error {
+	f.challenges[tokenHash] = &models.TwoFactorChallenge{
+		ID:         uuid.New(),
+		UserID:     userID,
+		AuthMethod: method,
+		ExpiresAt:  expiresAt,
+	}
+	return nil
+}
+
+func (f *fakeTwoFactorStore) FindActiveChallenge(tokenHash string, now time.Time) (*models.TwoFactorChallenge, error) {
+	challenge, ok := f.challenges[tokenHash]
+	if !ok || challenge.UsedAt != nil || !challenge.ExpiresAt.After(now) {
+		return nil, nil
+	}
+	copied := *challenge
+	return &copied, nil
+}
+
+func (f *fakeTwoFactorStore) challenge(id uuid.UUID) *models.TwoFactorChallenge {
+	for _, challenge := range f.challenges {
+		if challenge.ID == id {
+			return challenge
+		}
+	}
+	return nil
+}
+
+func (f *fakeTwoFactorStore) UseChallengeAttempt(challengeID uuid.UUID, maxAttempts int) (bool, error) {
+	challenge := f.challenge(challengeID)
+	if challenge == nil || challenge.Attempts >= maxAttempts {
+		return false, nil
+	}
+	challenge.Attempts++
+	return true, nil
+}
+
+func (f *fakeTwoFactorStore) CompleteChallenge(challengeID uuid.UUID, now time.Time) (bool, error) {
+	challenge := f.challenge(challengeID)
+	if challenge == nil || challenge.UsedAt != nil {
+		return false, nil
+	}
+	challenge.UsedAt = &now
+	return true, nil
+}
+
+type twoFactorTest struct {
+	service *TwoFactorService
+	store   *fakeTwoFactorStore
+	clock   time.Time
+	userID  uuid.UUID
+	secret  string
+	// Recovery codes as shown to the user
+	recoveryCodes []string
+}
+
+// newTwoFactorTest enrolls a password user. The clock then moves on so the
+// step used to confirm enrollment doesn't get in the way.
+func newTwoFactorTest(t *testing.T) *twoFactorTest {
+	t.Helper()
+
+	users := &fakeUserStore{users: make(map[uuid.UUID]*models.User)}
+	user := users.add(models.User{
+		Email:         "member@example.com",
+		EmailVerified: true,
+		PasswordHash:  sql.NullString{String: "hash", Valid: true},
+	})
+
+	tt := &twoFactorTest{
+		store: newFakeTwoFactorStore(),
+		// Halfway through a time step, so moving 30 seconds either way crosses
+		// exactly one step boundary
+		clock:  time.Date(2026, 3, 1, 12, 0, 15, 0, time.UTC),
+		userID: user.ID,
+	}
+	tt.service = NewTwoFactorService(tt.store, users)
+	tt.service.now = func() time.Time { return tt.clock }
+
+	if _, err := tt.service.BeginSetup(user.ID); err != nil {
+		t.Fatalf("BeginSetup: %v", err)
+	}
+	// A fixed secret along with the fixed clock keeps the codes the same on
+	// every run, so a wrong code can't collide with a valid one by chance
+	tt.secret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	tt.store.enrollments[user.ID].Secret = tt.secret
+
+	codes, err := tt.service.ConfirmSetup(user.ID, tt.codeAt(t, 0))
+	if err != nil {
+		t.Fatalf("ConfirmSetup: %v", err)
+	}
+	tt.recoveryCodes = codes
+
+	tt.clock = tt.clock.Add(10 * time.Minute)
+	return tt
+}
+
+// codeAt returns the authenticator app's code at the given offset from the clock
+func (tt *twoFactorTest) codeAt(t *testing.T, offset time.Duration) string {
+	t.Helper()
+
+	code, err := totp.Code(tt.secret, totp.Step(tt.clock.Add(offset)))
+	if err != nil {
+		t.Fatalf("totp.Code: %v", err)
+	}
+	return code
+}
+
+func (tt *twoFactorTest) challenge(t *testing.T) string {
+	t.Helper()
+
+	token, _, err := tt.service.CreateChallenge(tt.userID, models.AuthMethodMagicLink)
+	if err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	return token
+}
+
+func TestTwoFactorCodeSkew(t *testing.T) {
+	tests := []struct {
+		name    string
+		offset  time.Duration
+		wantErr error
+	}{
+		{"two steps behind", -60 * time.Second, ErrInvalidTwoFactorCode},
+		{"one step behind", -30 * time.Second, nil},
+		{"current step", 0, nil},
+		{"one step ahead", 30 * time.Second, nil},
+		{"two steps ahead", 60 * time.Second, ErrInvalidTwoFactorCode},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := newTwoFactorTest(t)
+
+			user, method, err := tt.service.VerifyChallenge(tt.challenge(t), tt.codeAt(t, tc.offset))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && (user.ID != tt.userID || method != models.AuthMethodMagicLink) {
+				t.Errorf("got user %s signed in with %q, want %s with %q", user.ID, method, tt.userID, models.AuthMethodMagicLink)
+			}
+		})
+	}
+}
+
+func TestTwoFactorCodeReplay(t *testing.T) {
+	tests := []struct {
+		name         string
+		first        time.Duration
+		second       time.Duration
+		secondOffset time.Duration // how far the clock moves between the two
+		wantErr      error
+	}{
+		{"same code twice", 0, 0, 0, ErrInvalidTwoFactorCode},
+		{"same code in the next step", 0, -30 * time.Second, 30 * time.Second, ErrInvalidTwoFactorCode},
+		{"earlier step after a later one", 30 * time.Second, 0, 0, ErrInvalidTwoFactorCode},
+		{"later step after an earlier one", -30 * time.Second, 0, 0, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := newTwoFactorTest(t)
+
+			if _, _, err := tt.service.VerifyChallenge(tt.challenge(t), tt.codeAt(t, tc.first)); err != nil {
+				t.Fatalf("first code: %v", err)
+			}
+
+			tt.clock = tt.clock.Add(tc.secondOffset)
+			if _, _, err := tt.service.VerifyChallenge(tt.challenge(t), tt.codeAt(t, tc.second)); !errors.Is(err, tc.wantErr) {
+				t.Errorf("second code: err = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTwoFactorRecoveryCodeSingleUse(t *testing.T) {
+	tests := []struct {
+		name   string
+		format func(code string) string
+	}{
+		{"as shown", func(code string) string { return code }},
+		{"upper case", strings.ToUpper},
+		{"without dash", func(code string) string { return strings.ReplaceAll(code, "-", "") }},
+		{"with spaces", func(code string) string { return " " + strings.ReplaceAll(code, "-", " ") + " " }},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := newTwoFactorTest(t)
+			code := tt.recoveryCodes[0]
+
+			if _, _, err := tt.service.VerifyChallenge(tt.challenge(t), tc.format(code)); err != nil {
+				t.Fatalf("first use: %v", err)
+			}
+			if _, _, err := tt.service.VerifyChallenge(tt.challenge(t), code); !errors.Is(err, ErrInvalidTwoFactorCode) {
+				t.Errorf("second use: err = %v, want ErrInvalidTwoFactorCode", err)
+			}
+			if _, _, err := tt.service.VerifyChallenge(tt.challenge(t), tt.recoveryCodes[1]); err != nil {
+				t.Errorf("another code: %v", err)
+			}
+		})
+	}
+}
+
+func TestTwoFactorRecoveryCodesNotAcceptedForRegeneration(t *testing.T) {
+	tt := newTwoFactorTest(t)
+
+	if _, err := tt.service.RegenerateRecoveryCodes(tt.userID, tt.recoveryCodes[0]); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("err = %v, want ErrInvalidTwoFactorCode", err)
+	}
+
+	codes, err := tt.service.RegenerateRecoveryCodes(tt.userID, tt.codeAt(t, 0))
+	if err != nil {
+		t.Fatalf("RegenerateRecoveryCodes: %v", err)
+	}
+	if _, _, err := tt.service.VerifyChallenge(tt.challenge(t), tt.recoveryCodes[0]); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("old code: err = %v, want ErrInvalidTwoFactorCode", err)
+	}
+	if _, _, err := tt.service.VerifyChallenge(tt.challenge(t), codes[0]); err != nil {
+		t.Errorf("new code: %v", err)
+	}
+}
+
+func TestTwoFactorChallengeExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		wait    time.Duration
+		wantErr error
+	}{
+		{"right away", 0, nil},
+		{"just before expiry", twoFactorChallengeTTL - time.Second, nil},
+		{"at expiry", twoFactorChallengeTTL, ErrInvalidChallenge},
+		{"long after", time.Hour, ErrInvalidChallenge},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := newTwoFactorTest(t)
+			token := tt.challenge(t)
+
+			tt.clock = tt.clock.Add(tc.wait)
+			if _, _, err := tt.service.VerifyChallenge(token, tt.codeAt(t, 0)); !errors.Is(err, tc.wantErr) {
+				t.Errorf("err = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTwoFactorChallengeSingleUse(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	token := tt.challenge(t)
+
+	if _, _, err := tt.service.VerifyChallenge(token, tt.codeAt(t, 0)); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	tt.clock = tt.clock.Add(30 * time.Second)
+	if _, _, err := tt.service.VerifyChallenge(token, tt.codeAt(t, 0)); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("second use: err = %v, want ErrInvalidChallenge", err)
+	}
+}
+
+func TestTwoFactorChallengeAttempts(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	token := tt.challenge(t)
+
+	for i := 0; i < maxTwoFactorAttempts; i++ {
+		if _, _, err := tt.service.VerifyChallenge(token, "000000"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("attempt %d: err = %v, want ErrInvalidTwoFactorCode", i+1, err)
+		}
+	}
+
+	if _, _, err := tt.service.VerifyChallenge(token, tt.codeAt(t, 0)); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("correct code after too many attempts: err = %v, want ErrInvalidChallenge", err)
+	}
+}
+
+func TestTwoFactorChallengeLastAttempt(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	token := tt.challenge(t)
+
+	for i := 0; i < maxTwoFactorAttempts-1; i++ {
+		if _, _, err := tt.service.VerifyChallenge(token, "000000"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("attempt %d: err = %v, want ErrInvalidTwoFactorCode", i+1, err)
+		}
+	}
+
+	if _, _, err := tt.service.VerifyChallenge(token, tt.codeAt(t, 0)); err != nil {
+		t.Errorf("correct code on the last attempt: %v", err)
+	}
+}
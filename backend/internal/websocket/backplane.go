@@ -0,0 +1,191 @@
+package websocket
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"heyspoilme/internal/models"
+)
+
+// EventKind says what a hub event asks every server to do
+type EventKind string
+
+const (
+	// EventMessage sends Message to UserID's connections, or to everyone when
+	// UserID is uuid.Nil
+	EventMessage EventKind = "message"
+	// EventAudience sends Message to the users in Audience and to connections
+	// subscribed to UserID
+	EventAudience EventKind = "audience"
+	// EventDisconnect closes UserID's connections opened with SessionID, or all
+	// of them when SessionID is uuid.Nil
+	EventDisconnect EventKind = "disconnect"
+)
+
+// Event is published by one server and carried out by every server on the
+// connections it holds
+type Event struct {
+	Kind      EventKind         `json:"kind"`
+	UserID    uuid.UUID         `json:"user_id"`
+	SessionID uuid.UUID         `json:"session_id"`
+	Audience  []uuid.UUID       `json:"audience,omitempty"`
+	Message   *models.WSMessage `json:"message,omitempty"`
+}
+
+// Backplane carries hub events between servers, so a user receives them no
+// matter which server their connections landed on. The in-memory backplane
+// suits a single instance or local development.
+type Backplane interface {
+	// Publish sends the event to every server, this one included
+	Publish(event *Event) error
+	// Events returns the events published by any server
+	Events() <-chan *Event
+	Close() error
+}
+
+const backplaneBuffer = 256
+
+type MemoryBackplane struct {
+	events chan *Event
+}
+
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{events: make(chan *Event, backplaneBuffer)}
+}
+
+func (b *MemoryBackplane) Publish(event *Event) error {
+	b.events <- event
+	return nil
+}
+
+func (b *MemoryBackplane) Events() <-chan *Event {
+	return b.events
+}
+
+func (b *MemoryBackplane) Close() error {
+	return nil
+}
+
+const (
+	backplaneChannel = "hub_events"
+	// NOTIFY payloads must stay under 8000 bytes. Bigger events are stored in
+	// backplane_events and only their id is sent.
+	maxNotifyPayload = 7900
+	// Stored events only need to outlive the round trip to the listeners
+	backplaneEventTTL = 5 * time.Minute
+	// Notifications sent by reference start with this prefix
+	backplaneRefPrefix = "ref:"
+)
+
+// PostgresBackplane relays events with LISTEN/NOTIFY on the main database
+type PostgresBackplane struct {
+	db       *sql.DB
+	listener *pq.Listener
+	events   chan *Event
+}
+
+func NewPostgresBackplane(db *sql.DB, databaseURL string) (*PostgresBackplane, error) {
+	listener := pq.NewListener(databaseURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("[Backplane] Listener error: %v", err)
+		}
+		if ev == pq.ListenerEventReconnected {
+			// Anything published while the connection was down is lost live.
+			// Clients that notice a gap in their event sequence resume from it.
+			log.Printf("[Backplane] Listener reconnected")
+		}
+	})
+	if err := listener.Listen(backplaneChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	b := &PostgresBackplane{
+		db:       db,
+		listener: listener,
+		events:   make(chan *Event, backplaneBuffer),
+	}
+	go b.listen()
+	return b, nil
+}
+
+func (b *PostgresBackplane) Publish(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	payload := string(data)
+	if len(data) > maxNotifyPayload {
+		var id int64
+		err := b.db.QueryRow(`
+			INSERT INTO backplane_events (payload) VALUES ($1) RETURNING id
+		`, payload).Scan(&id)
+		if err != nil {
+			return err
+		}
+		payload = backplaneRefPrefix + strconv.FormatInt(id, 10)
+
+		_, err = b.db.Exec(`DELETE FROM backplane_events WHERE created_at < $1`, time.Now().UTC().Add(-backplaneEventTTL))
+		if err != nil {
+			log.Printf("[Backplane] Failed to prune stored events: %v", err)
+		}
+	}
+
+	_, err = b.db.Exec(`SELECT pg_notify($1, $2)`, backplaneChannel, payload)
+	return err
+}
+
+func (b *PostgresBackplane) Events() <-chan *Event {
+	return b.events
+}
+
+func (b *PostgresBackplane) Close() error {
+	return b.listener.Close()
+}
+
+func (b *PostgresBackplane) listen() {
+	defer close(b.events)
+
+	for notification := range b.listener.Notify {
+		// A nil notification only signals that the connection was re-established
+		if notification == nil {
+			continue
+		}
+
+		event, err := b.decode(notification.Extra)
+		if err != nil {
+			log.Printf("[Backplane] Dropping event: %v", err)
+			continue
+		}
+		b.events <- event
+	}
+}
+
+func (b *PostgresBackplane) decode(payload string) (*Event, error) {
+	if ref, ok := strings.CutPrefix(payload, backplaneRefPrefix); ok {
+		id, err := strconv.ParseInt(ref, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		err = b.db.QueryRow(`SELECT payload FROM backplane_events WHERE id = $1`, id).Scan(&payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Decoding into a RawMessage keeps the payload exactly as the publishing
+	// server encoded it, rather than a generic map
+	event := &Event{Message: &models.WSMessage{Payload: &json.RawMessage{}}}
+	if err := json.Unmarshal([]byte(payload), event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
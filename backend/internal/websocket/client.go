@@ -14,22 +14,44 @@ const (
 	writeWait      = 10 * time.Second
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
+	maxMessageSize = 4096 // Commands are small JSON objects; anything bigger is dropped with the connection
 )
 
 type Client struct {
-	Hub    *Hub
-	Conn   *websocket.Conn
-	UserID uuid.UUID
-	Send   chan *models.WSMessage
+	Hub         *Hub
+	Conn        *websocket.Conn
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	SessionID   uuid.UUID
+	RemoteAddr  string
+	ConnectedAt time.Time
+	Send        chan *models.WSMessage
+
+	// Users this connection asked to receive presence updates about, guarded
+	// by the hub's lock
+	subscriptions map[uuid.UUID]struct{}
 }
 
-func NewClient(hub *Hub, conn *websocket.Conn, userID uuid.UUID) *Client {
+func NewClient(hub *Hub, conn *websocket.Conn, userID, sessionID uuid.UUID, remoteAddr string) *Client {
 	return &Client{
-		Hub:    hub,
-		Conn:   conn,
-		UserID: userID,
-		Send:   make(chan *models.WSMessage, 256),
+		Hub:         hub,
+		Conn:        conn,
+		ID:          uuid.New(),
+		UserID:      userID,
+		SessionID:   sessionID,
+		RemoteAddr:  remoteAddr,
+		ConnectedAt: time.Now().UTC(),
+		Send:        make(chan *models.WSMessage, 256),
+	}
+}
+
+// Connection describes the connection for listing under its session
+func (c *Client) Connection() models.WSConnection {
+	return models.WSConnection{
+		ID:          c.ID,
+		SessionID:   c.SessionID,
+		RemoteAddr:  c.RemoteAddr,
+		ConnectedAt: c.ConnectedAt,
 	}
 }
 
@@ -54,9 +76,11 @@ func (c *Client) ReadPump() {
 			break
 		}
 
-		var wsMessage models.WSMessage
-		if err := json.Unmarshal(message, &wsMessage); err != nil {
-			continue
+		if ack := c.Hub.commands.Dispatch(c, message); ack != nil {
+			c.Hub.SendToClient(c, &models.WSMessage{
+				Type:    models.WSTypeAck,
+				Payload: ack,
+			})
 		}
 	}
 }
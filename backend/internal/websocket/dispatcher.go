@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"heyspoilme/internal/models"
+)
+
+var (
+	ErrInvalidCommand     = errors.New("invalid command")
+	ErrUnknownCommand     = errors.New("unknown command")
+	ErrInvalidPayload     = errors.New("invalid command payload")
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+)
+
+// CommandFunc handles one command sent on a client's connection. A returned
+// error is sent back to the client in the command's ack.
+type CommandFunc func(client *Client, payload json.RawMessage) error
+
+// Dispatcher routes inbound client commands to the handlers services
+// registered for each command type
+type Dispatcher struct {
+	handlers map[models.WSMessageType]CommandFunc
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: make(map[models.WSMessageType]CommandFunc)}
+}
+
+// Register sets the handler for a command type. Handlers must be registered
+// before clients connect.
+func (d *Dispatcher) Register(commandType models.WSMessageType, fn CommandFunc) {
+	d.handlers[commandType] = fn
+}
+
+// Dispatch decodes a raw frame and runs its handler. It returns the ack to send
+// back, or nil when the command succeeded and the client didn't ask for one.
+func (d *Dispatcher) Dispatch(client *Client, frame []byte) *models.WSAckPayload {
+	var command models.WSCommand
+	if err := json.Unmarshal(frame, &command); err != nil {
+		return &models.WSAckPayload{Error: ErrInvalidCommand.Error()}
+	}
+
+	err := d.run(client, &command)
+	if err == nil {
+		if command.ID == "" {
+			return nil
+		}
+		return &models.WSAckPayload{ID: command.ID, OK: true}
+	}
+
+	return &models.WSAckPayload{ID: command.ID, Error: err.Error()}
+}
+
+func (d *Dispatcher) run(client *Client, command *models.WSCommand) (err error) {
+	if command.Version != 0 && command.Version != models.WSProtocolVersion {
+		return ErrUnsupportedVersion
+	}
+
+	fn, ok := d.handlers[command.Type]
+	if !ok {
+		return ErrUnknownCommand
+	}
+
+	// A bad command must not take the connection down with it
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[WebSocket] %s command from user %s panicked: %v", command.Type, client.UserID, r)
+			err = fmt.Errorf("%s failed", command.Type)
+		}
+	}()
+
+	return fn(client, command.Payload)
+}
+
+// DecodePayload unmarshals a command payload, reporting any failure as
+// ErrInvalidPayload
+func DecodePayload(payload json.RawMessage, v interface{}) error {
+	if len(payload) == 0 {
+		payload = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return ErrInvalidPayload
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+)
+
+func TestDispatchRejects(t *testing.T) {
+	d := NewDispatcher()
+	ran := 0
+	d.Register(models.WSTypeHeartbeat, func(client *Client, payload json.RawMessage) error {
+		ran++
+		return nil
+	})
+	d.Register(models.WSTypeMarkRead, func(client *Client, payload json.RawMessage) error {
+		var target struct {
+			MessageID uuid.UUID `json:"message_id"`
+		}
+		return DecodePayload(payload, &target)
+	})
+
+	tests := []struct {
+		name  string
+		frame string
+		ack   models.WSAckPayload
+	}{
+		{
+			name:  "newer protocol version",
+			frame: `{"v":2,"id":"c1","type":"heartbeat"}`,
+			ack:   models.WSAckPayload{ID: "c1", Error: ErrUnsupportedVersion.Error()},
+		},
+		{
+			name:  "unknown type",
+			frame: `{"v":1,"id":"c2","type":"teleport"}`,
+			ack:   models.WSAckPayload{ID: "c2", Error: ErrUnknownCommand.Error()},
+		},
+		{
+			name:  "server event sent as a command",
+			frame: `{"v":1,"id":"c3","type":"message"}`,
+			ack:   models.WSAckPayload{ID: "c3", Error: ErrUnknownCommand.Error()},
+		},
+		{
+			name:  "unknown type without an ID",
+			frame: `{"v":1,"type":"teleport"}`,
+			ack:   models.WSAckPayload{Error: ErrUnknownCommand.Error()},
+		},
+		{
+			name:  "bad payload",
+			frame: `{"v":1,"id":"c4","type":"mark_read","payload":{"message_id":7}}`,
+			ack:   models.WSAckPayload{ID: "c4", Error: ErrInvalidPayload.Error()},
+		},
+		{
+			name:  "not JSON",
+			frame: `heartbeat`,
+			ack:   models.WSAckPayload{Error: ErrInvalidCommand.Error()},
+		},
+	}
+
+	client := newTestClient(uuid.New())
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ack := d.Dispatch(client, []byte(tc.frame))
+			if ack == nil || *ack != tc.ack {
+				t.Errorf("ack = %+v, want %+v", ack, tc.ack)
+			}
+		})
+	}
+
+	if ran != 0 {
+		t.Errorf("heartbeat handler ran %d times for rejected commands", ran)
+	}
+}
+
+func TestDispatchAcks(t *testing.T) {
+	d := NewDispatcher()
+	failure := errors.New("not allowed")
+	d.Register(models.WSTypeHeartbeat, func(client *Client, payload json.RawMessage) error { return nil })
+	d.Register(models.WSTypeMarkRead, func(client *Client, payload json.RawMessage) error { return failure })
+	d.Register(models.WSTypeTyping, func(client *Client, payload json.RawMessage) error { panic("boom") })
+
+	client := newTestClient(uuid.New())
+	tests := []struct {
+		name  string
+		frame string
+		ack   *models.WSAckPayload
+	}{
+		{"success with an ID", `{"v":1,"id":"c1","type":"heartbeat"}`, &models.WSAckPayload{ID: "c1", OK: true}},
+		{"success without an ID", `{"v":1,"type":"heartbeat"}`, nil},
+		{"version left out", `{"type":"heartbeat","id":"c2"}`, &models.WSAckPayload{ID: "c2", OK: true}},
+		{"handler error", `{"v":1,"id":"c3","type":"mark_read"}`, &models.WSAckPayload{ID: "c3", Error: failure.Error()}},
+		{"handler panic", `{"v":1,"id":"c4","type":"typing"}`, &models.WSAckPayload{ID: "c4", Error: "typing failed"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ack := d.Dispatch(client, []byte(tc.frame))
+			if (ack == nil) != (tc.ack == nil) || (ack != nil && *ack != *tc.ack) {
+				t.Errorf("ack = %+v, want %+v", ack, tc.ack)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package websocket
 
 import (
+	"errors"
+	"log"
 	"sync"
 
 	"github.com/google/uuid"
@@ -8,81 +10,393 @@ import (
 	"heyspoilme/internal/models"
 )
 
+// maxSubscriptions caps how many users one connection can follow the presence
+// of, which is plenty for the profiles on one screen
+const maxSubscriptions = 200
+
+var ErrTooManySubscriptions = errors.New("too many presence subscriptions")
+
+// PresenceFunc is told when a user's first connection opens and when their
+// last one closes
+type PresenceFunc func(userID uuid.UUID, online bool)
+
+// ConnectionFunc is told when each connection opens and closes
+type ConnectionFunc func(client *Client, open bool)
+
+// Hub tracks the connections open on this server. A user can be connected from
+// several tabs and devices at once, so each user maps to a set of clients.
+// Messages and disconnects go through the backplane, which hands them to the
+// hub of every server.
 type Hub struct {
-	clients    map[uuid.UUID]*Client
-	broadcast  chan *models.WSMessage
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.RWMutex
+	nodeID    uuid.UUID
+	clients   map[uuid.UUID]map[*Client]struct{}
+	backplane Backplane
+	mu        sync.RWMutex
+	commands  *Dispatcher
+
+	// Connections on this server subscribed to each user's presence
+	subscribers map[uuid.UUID]map[*Client]struct{}
+
+	// Each user's presence changes are reported one at a time and always with
+	// their state at that moment, so a reconnect racing a disconnect can't leave
+	// a connected user marked offline. presenceMu only guards the maps; the
+	// per-user lock is held while the change is reported, so a slow report
+	// holds up that user alone.
+	presenceMu       sync.Mutex
+	presenceLocks    map[uuid.UUID]*presenceLock
+	reportedOnline   map[uuid.UUID]bool
+	onPresenceChange PresenceFunc
+
+	onConnectionChange ConnectionFunc
+}
+
+// presenceLock serializes one user's presence reports. It is dropped once no
+// goroutine holds or waits for it.
+type presenceLock struct {
+	mu   sync.Mutex
+	refs int
 }
 
-func NewHub() *Hub {
+func NewHub(backplane Backplane) *Hub {
 	return &Hub{
-		clients:    make(map[uuid.UUID]*Client),
-		broadcast:  make(chan *models.WSMessage),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		nodeID:         uuid.New(),
+		clients:        make(map[uuid.UUID]map[*Client]struct{}),
+		backplane:      backplane,
+		commands:       NewDispatcher(),
+		presenceLocks:  make(map[uuid.UUID]*presenceLock),
+		reportedOnline: make(map[uuid.UUID]bool),
+		subscribers:    make(map[uuid.UUID]map[*Client]struct{}),
 	}
 }
 
+// NodeID identifies this server among the ones sharing the backplane
+func (h *Hub) NodeID() uuid.UUID {
+	return h.nodeID
+}
+
+// Run carries out the events published by every server until the backplane
+// is closed
 func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client.UserID] = client
-			h.mu.Unlock()
-
-		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client.UserID]; ok {
-				delete(h.clients, client.UserID)
-				close(client.Send)
+	for event := range h.backplane.Events() {
+		switch event.Kind {
+		case EventMessage:
+			if event.UserID == uuid.Nil {
+				h.deliverToAll(event.Message)
+			} else {
+				h.deliverToUser(event.UserID, event.Message)
 			}
-			h.mu.Unlock()
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			for _, client := range h.clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.clients, client.UserID)
-				}
-			}
-			h.mu.RUnlock()
+		case EventAudience:
+			h.deliverToAudience(event.UserID, event.Audience, event.Message)
+		case EventDisconnect:
+			h.disconnect(event.UserID, event.SessionID)
 		}
 	}
 }
 
+func (h *Hub) publish(event *Event) {
+	if err := h.backplane.Publish(event); err != nil {
+		log.Printf("[Hub] Failed to publish %s event: %v", event.Kind, err)
+	}
+}
+
+// OnPresenceChange sets the function told about users coming online and going
+// offline. It must be called before any client connects.
+func (h *Hub) OnPresenceChange(fn PresenceFunc) {
+	h.onPresenceChange = fn
+}
+
+// OnConnectionChange sets the function told about every connection opening and
+// closing. It must be called before any client connects.
+func (h *Hub) OnConnectionChange(fn ConnectionFunc) {
+	h.onConnectionChange = fn
+}
+
+// Register adds a connection. Other connections of the same user are kept.
 func (h *Hub) Register(client *Client) {
-	h.register <- client
+	h.mu.Lock()
+	clients, ok := h.clients[client.UserID]
+	if !ok {
+		clients = make(map[*Client]struct{})
+		h.clients[client.UserID] = clients
+	}
+	clients[client] = struct{}{}
+	h.mu.Unlock()
+
+	if h.onConnectionChange != nil {
+		h.onConnectionChange(client, true)
+	}
+	h.reportPresence(client.UserID)
 }
 
+// Unregister removes a connection and closes its send channel. The user only
+// goes offline once their last connection is gone.
 func (h *Hub) Unregister(client *Client) {
-	h.unregister <- client
+	h.mu.Lock()
+	clients, ok := h.clients[client.UserID]
+	removed := false
+	if ok {
+		if _, registered := clients[client]; registered {
+			removed = true
+			delete(clients, client)
+			close(client.Send)
+			h.unsubscribeLocked(client, nil)
+		}
+		if len(clients) == 0 {
+			delete(h.clients, client.UserID)
+		}
+	}
+	h.mu.Unlock()
+
+	if removed && h.onConnectionChange != nil {
+		h.onConnectionChange(client, false)
+	}
+	h.reportPresence(client.UserID)
 }
 
-func (h *Hub) BroadcastToUser(userID uuid.UUID, message *models.WSMessage) {
+// reportPresence tells the presence handler the user's current state if it
+// differs from what was last reported
+func (h *Hub) reportPresence(userID uuid.UUID) {
+	if h.onPresenceChange == nil {
+		return
+	}
+
+	lock := h.lockPresence(userID)
+	defer h.unlockPresence(userID, lock)
+
+	online := h.IsUserOnline(userID)
+
+	h.presenceMu.Lock()
+	changed := h.reportedOnline[userID] != online
+	if changed {
+		if online {
+			h.reportedOnline[userID] = true
+		} else {
+			delete(h.reportedOnline, userID)
+		}
+	}
+	h.presenceMu.Unlock()
+
+	if changed {
+		h.onPresenceChange(userID, online)
+	}
+}
+
+func (h *Hub) lockPresence(userID uuid.UUID) *presenceLock {
+	h.presenceMu.Lock()
+	lock, ok := h.presenceLocks[userID]
+	if !ok {
+		lock = &presenceLock{}
+		h.presenceLocks[userID] = lock
+	}
+	lock.refs++
+	h.presenceMu.Unlock()
+
+	lock.mu.Lock()
+	return lock
+}
+
+func (h *Hub) unlockPresence(userID uuid.UUID, lock *presenceLock) {
+	lock.mu.Unlock()
+
+	h.presenceMu.Lock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(h.presenceLocks, userID)
+	}
+	h.presenceMu.Unlock()
+}
+
+// Commands returns the dispatcher for commands sent by clients, for services
+// to register their handlers on
+func (h *Hub) Commands() *Dispatcher {
+	return h.commands
+}
+
+// SendToClient queues a message for one connection, such as the ack for a
+// command it sent or an event it missed. It reports whether the message was
+// queued, which it isn't if the client was unregistered or its buffer is full.
+func (h *Hub) SendToClient(client *Client, message *models.WSMessage) bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	if client, ok := h.clients[userID]; ok {
+	if _, ok := h.clients[client.UserID][client]; ok {
 		select {
 		case client.Send <- message:
+			return true
 		default:
 		}
 	}
+	return false
+}
+
+// BroadcastToUser sends a message to every connection the user has open, on
+// any server
+func (h *Hub) BroadcastToUser(userID uuid.UUID, message *models.WSMessage) {
+	h.publish(&Event{Kind: EventMessage, UserID: userID, Message: message})
 }
 
+// BroadcastToAll sends a message to every connection on every server
 func (h *Hub) BroadcastToAll(message *models.WSMessage) {
-	h.broadcast <- message
+	h.publish(&Event{Kind: EventMessage, Message: message})
 }
 
+// deliverToUser queues a message for the user's connections on this server
+func (h *Hub) deliverToUser(userID uuid.UUID, message *models.WSMessage) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients[userID] {
+		select {
+		case client.Send <- message:
+		default:
+			// Numbered events can't just be dropped. Closing the connection
+			// has the client reconnect and resume from the last one it got.
+			if message.Seq != 0 {
+				client.Conn.Close()
+			}
+		}
+	}
+}
+
+func (h *Hub) deliverToAll(message *models.WSMessage) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, clients := range h.clients {
+		for client := range clients {
+			select {
+			case client.Send <- message:
+			default:
+				// Too slow to keep up. Closing the connection makes its
+				// read pump unregister it like any other disconnect.
+				client.Conn.Close()
+			}
+		}
+	}
+}
+
+// BroadcastToAudience sends a message about a user to the given audience and to
+// every connection subscribed to that user, on any server
+func (h *Hub) BroadcastToAudience(subjectID uuid.UUID, audience []uuid.UUID, message *models.WSMessage) {
+	h.publish(&Event{Kind: EventAudience, UserID: subjectID, Audience: audience, Message: message})
+}
+
+// deliverToAudience queues a message once for each local connection that is
+// either in the audience or subscribed to the subject
+func (h *Hub) deliverToAudience(subjectID uuid.UUID, audience []uuid.UUID, message *models.WSMessage) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sent := make(map[*Client]struct{})
+	deliver := func(client *Client) {
+		if _, ok := sent[client]; ok {
+			return
+		}
+		sent[client] = struct{}{}
+		select {
+		case client.Send <- message:
+		default:
+		}
+	}
+
+	for _, userID := range audience {
+		for client := range h.clients[userID] {
+			deliver(client)
+		}
+	}
+	for client := range h.subscribers[subjectID] {
+		deliver(client)
+	}
+}
+
+// Subscribe has the connection receive messages sent about the given users with
+// BroadcastToAudience, until it unsubscribes or closes. The caller checks that
+// the connection's user may follow them.
+func (h *Hub) Subscribe(client *Client, userIDs []uuid.UUID) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if client.subscriptions == nil {
+		client.subscriptions = make(map[uuid.UUID]struct{})
+	}
+
+	added := 0
+	for _, userID := range userIDs {
+		if _, ok := client.subscriptions[userID]; !ok {
+			added++
+		}
+	}
+	if len(client.subscriptions)+added > maxSubscriptions {
+		return ErrTooManySubscriptions
+	}
+
+	for _, userID := range userIDs {
+		client.subscriptions[userID] = struct{}{}
+		subscribers, ok := h.subscribers[userID]
+		if !ok {
+			subscribers = make(map[*Client]struct{})
+			h.subscribers[userID] = subscribers
+		}
+		subscribers[client] = struct{}{}
+	}
+	return nil
+}
+
+// Unsubscribe stops the connection receiving messages about the given users
+func (h *Hub) Unsubscribe(client *Client, userIDs []uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.unsubscribeLocked(client, userIDs)
+}
+
+// unsubscribeLocked removes the given subscriptions of the connection, or all
+// of them when userIDs is nil. The caller must hold the write lock.
+func (h *Hub) unsubscribeLocked(client *Client, userIDs []uuid.UUID) {
+	if userIDs == nil {
+		for userID := range client.subscriptions {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	for _, userID := range userIDs {
+		delete(client.subscriptions, userID)
+		if subscribers, ok := h.subscribers[userID]; ok {
+			delete(subscribers, client)
+			if len(subscribers) == 0 {
+				delete(h.subscribers, userID)
+			}
+		}
+	}
+}
+
+// IsUserOnline reports whether the user has a connection on this server
 func (h *Hub) IsUserOnline(userID uuid.UUID) bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	_, ok := h.clients[userID]
-	return ok
+	return len(h.clients[userID]) > 0
+}
+
+// DisconnectSession closes the user's connections that were opened with the given session,
+// on every server. The read pump then unregisters them as for any other disconnect.
+func (h *Hub) DisconnectSession(userID, sessionID uuid.UUID) {
+	h.publish(&Event{Kind: EventDisconnect, UserID: userID, SessionID: sessionID})
+}
+
+// DisconnectUser closes all of the user's connections on every server
+func (h *Hub) DisconnectUser(userID uuid.UUID) {
+	h.publish(&Event{Kind: EventDisconnect, UserID: userID})
+}
+
+// disconnect closes the matching connections on this server. A nil session
+// matches all of them.
+func (h *Hub) disconnect(userID, sessionID uuid.UUID) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients[userID] {
+		if sessionID == uuid.Nil || client.SessionID == sessionID {
+			client.Conn.Close()
+		}
+	}
 }
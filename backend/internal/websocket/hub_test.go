@@ -0,0 +1,250 @@
+package websocket
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"heyspoilme/internal/models"
+)
+
+func newTestClient(userID uuid.UUID) *Client {
+	return &Client{ID: uuid.New(), UserID: userID, Send: make(chan *models.WSMessage, 256)}
+}
+
+// presenceRecorder collects the transitions the hub reports, per user
+type presenceRecorder struct {
+	mu          sync.Mutex
+	transitions map[uuid.UUID][]bool
+}
+
+func newPresenceRecorder(hub *Hub) *presenceRecorder {
+	recorder := &presenceRecorder{transitions: make(map[uuid.UUID][]bool)}
+	hub.OnPresenceChange(func(userID uuid.UUID, online bool) {
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		recorder.transitions[userID] = append(recorder.transitions[userID], online)
+	})
+	return recorder
+}
+
+func (r *presenceRecorder) get(userID uuid.UUID) []bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]bool(nil), r.transitions[userID]...)
+}
+
+// concurrently runs fn for every client at the same time
+func concurrently(clients []*Client, fn func(client *Client)) {
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			fn(client)
+		}(client)
+	}
+	wg.Wait()
+}
+
+func TestHubPresenceConcurrentConnections(t *testing.T) {
+	hub := NewHub(NewMemoryBackplane())
+	recorder := newPresenceRecorder(hub)
+	userID := uuid.New()
+
+	clients := make([]*Client, 50)
+	for i := range clients {
+		clients[i] = newTestClient(userID)
+	}
+
+	concurrently(clients, hub.Register)
+	if got := recorder.get(userID); len(got) != 1 || !got[0] {
+		t.Fatalf("after registering: transitions = %v, want [true]", got)
+	}
+
+	concurrently(clients, hub.Unregister)
+	if got := recorder.get(userID); len(got) != 2 || got[1] {
+		t.Fatalf("after unregistering: transitions = %v, want [true false]", got)
+	}
+	if hub.IsUserOnline(userID) {
+		t.Error("user is still online after every connection closed")
+	}
+}
+
+func TestHubPresenceReconnectRacingDisconnect(t *testing.T) {
+	hub := NewHub(NewMemoryBackplane())
+	recorder := newPresenceRecorder(hub)
+	userID := uuid.New()
+
+	for i := 0; i < 100; i++ {
+		old, reconnected := newTestClient(userID), newTestClient(userID)
+		hub.Register(old)
+		concurrently([]*Client{old, reconnected}, func(client *Client) {
+			if client == old {
+				hub.Unregister(client)
+			} else {
+				hub.Register(client)
+			}
+		})
+		hub.Unregister(reconnected)
+	}
+
+	// Whatever the interleaving, transitions alternate and the last one
+	// matches the user's state
+	got := recorder.get(userID)
+	for i, online := range got {
+		if online != (i%2 == 0) {
+			t.Fatalf("transitions = %v, want them to alternate starting online", got)
+		}
+	}
+	if len(got) == 0 || got[len(got)-1] {
+		t.Errorf("transitions = %v, want the last one offline", got)
+	}
+}
+
+func TestHubPresenceReportsDoNotBlockOtherUsers(t *testing.T) {
+	hub := NewHub(NewMemoryBackplane())
+	slowUser, otherUser := uuid.New(), uuid.New()
+
+	release := make(chan struct{})
+	reported := make(chan uuid.UUID, 2)
+	hub.OnPresenceChange(func(userID uuid.UUID, online bool) {
+		if userID == slowUser {
+			<-release
+		}
+		reported <- userID
+	})
+
+	go hub.Register(newTestClient(slowUser))
+	// Give the slow report time to start before the other user connects
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		hub.Register(newTestClient(otherUser))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("registering one user waited for another user's presence report")
+	}
+	if got := <-reported; got != otherUser {
+		t.Errorf("first report was for %s, want %s", got, otherUser)
+	}
+
+	close(release)
+	if got := <-reported; got != slowUser {
+		t.Errorf("second report was for %s, want %s", got, slowUser)
+	}
+}
+
+func TestHubConnectionChanges(t *testing.T) {
+	hub := NewHub(NewMemoryBackplane())
+
+	var mu sync.Mutex
+	open := make(map[uuid.UUID]int)
+	hub.OnConnectionChange(func(client *Client, opened bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if opened {
+			open[client.ID]++
+		} else {
+			open[client.ID]--
+		}
+	})
+
+	userID := uuid.New()
+	clients := make([]*Client, 20)
+	for i := range clients {
+		clients[i] = newTestClient(userID)
+	}
+
+	concurrently(clients, hub.Register)
+	for _, client := range clients {
+		if open[client.ID] != 1 {
+			t.Fatalf("connection %s reported open %d times, want 1", client.ID, open[client.ID])
+		}
+	}
+
+	// Closing a connection twice only reports it once
+	concurrently(append(clients, clients...), hub.Unregister)
+	for _, client := range clients {
+		if open[client.ID] != 0 {
+			t.Errorf("connection %s left with %d opens, want 0", client.ID, open[client.ID])
+		}
+	}
+}
+
+// benchmarkHub connects the given number of users with one connection each
+func benchmarkHub(users int) (*Hub, []uuid.UUID) {
+	hub := NewHub(NewMemoryBackplane())
+	userIDs := make([]uuid.UUID, users)
+	for i := range userIDs {
+		userIDs[i] = uuid.New()
+		hub.Register(newTestClient(userIDs[i]))
+	}
+	return hub, userIDs
+}
+
+// drainEvery empties the send buffers before they fill up, outside the timed
+// part of the benchmark, so no connection is closed as too slow
+func drainEvery(b *testing.B, hub *Hub, i int) {
+	if i%200 != 199 {
+		return
+	}
+	b.StopTimer()
+	for _, clients := range hub.clients {
+		for client := range clients {
+			for len(client.Send) > 0 {
+				<-client.Send
+			}
+		}
+	}
+	b.StartTimer()
+}
+
+var benchmarkSizes = []struct {
+	name  string
+	users int
+}{
+	{"100", 100},
+	{"1000", 1000},
+	{"10000", 10000},
+}
+
+// BenchmarkDeliverToAll measures queuing one presence event the way it was sent
+// before it was scoped, to every connected user
+func BenchmarkDeliverToAll(b *testing.B) {
+	message := &models.WSMessage{Type: models.WSTypePresence}
+	for _, size := range benchmarkSizes {
+		b.Run(size.name, func(b *testing.B) {
+			hub, _ := benchmarkHub(size.users)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				hub.deliverToAll(message)
+				drainEvery(b, hub, i)
+			}
+		})
+	}
+}
+
+// BenchmarkDeliverToAudience measures queuing one presence event for an
+// audience of 20 users
+func BenchmarkDeliverToAudience(b *testing.B) {
+	message := &models.WSMessage{Type: models.WSTypePresence}
+	for _, size := range benchmarkSizes {
+		b.Run(size.name, func(b *testing.B) {
+			hub, userIDs := benchmarkHub(size.users)
+			subjectID, audience := userIDs[0], userIDs[1:21]
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				hub.deliverToAudience(subjectID, audience, message)
+				drainEvery(b, hub, i)
+			}
+		})
+	}
+}
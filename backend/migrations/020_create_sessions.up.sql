@@ -0,0 +1,18 @@
+-- Server-side sessions backing rotating refresh tokens
+CREATE TABLE sessions (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    refresh_token_hash VARCHAR(64) NOT NULL,
+    previous_refresh_token_hash VARCHAR(64), -- Kept to detect reuse of a rotated token
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Refresh token lookups
+CREATE UNIQUE INDEX idx_sessions_refresh_token ON sessions(refresh_token_hash);
+CREATE INDEX idx_sessions_previous_refresh_token ON sessions(previous_refresh_token_hash) WHERE previous_refresh_token_hash IS NOT NULL;
+
+-- Active sessions per user (logout from all devices)
+CREATE INDEX idx_sessions_user_active ON sessions(user_id) WHERE revoked_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS auth_method;
+ALTER TABLE sessions DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
@@ -0,0 +1,4 @@
+-- Device details shown in the active sessions list
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN ip_address VARCHAR(45) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN auth_method VARCHAR(20) NOT NULL DEFAULT 'password'; -- 'password', 'google'
@@ -0,0 +1,5 @@
+ALTER TABLE sessions ALTER COLUMN auth_method TYPE VARCHAR(20);
+
+DROP TABLE IF EXISTS oauth_login_codes;
+DROP TABLE IF EXISTS oauth_states;
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,42 @@
+-- Accounts linked to external identity providers (Google and any configured OIDC provider)
+CREATE TABLE user_identities (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_user_identities_provider_subject ON user_identities(provider, subject);
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+INSERT INTO user_identities (id, user_id, provider, subject, email, created_at)
+SELECT uuid_generate_v4(), id, 'google', google_id, email, created_at
+FROM users WHERE google_id IS NOT NULL;
+
+-- Per-login state for the authorization code flow (CSRF state, OIDC nonce, PKCE verifier)
+CREATE TABLE oauth_states (
+    state_hash VARCHAR(64) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    nonce VARCHAR(255) NOT NULL,
+    code_verifier VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_oauth_states_expires_at ON oauth_states(expires_at);
+
+-- One-time codes handed to the frontend after a provider callback, exchanged for tokens
+CREATE TABLE oauth_login_codes (
+    code_hash VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    is_new BOOLEAN NOT NULL DEFAULT false,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Provider names are configurable now, so allow longer auth methods on sessions
+ALTER TABLE sessions ALTER COLUMN auth_method TYPE VARCHAR(50);
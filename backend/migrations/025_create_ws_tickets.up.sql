@@ -0,0 +1,10 @@
+-- Single-use tickets for opening a WebSocket, so access tokens stay out of URLs
+CREATE TABLE ws_tickets (
+    ticket_hash VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_ws_tickets_expires_at ON ws_tickets(expires_at);
@@ -0,0 +1,11 @@
+-- Pending email change. The new address takes effect once confirmed; the old
+-- address gets a cancel link that also undoes a confirmed change for a while.
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(255);
+ALTER TABLE users ADD COLUMN email_change_token VARCHAR(64);
+ALTER TABLE users ADD COLUMN email_change_token_expires_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN email_change_cancel_token VARCHAR(64);
+ALTER TABLE users ADD COLUMN email_change_cancel_expires_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN previous_email VARCHAR(255);
+
+CREATE UNIQUE INDEX idx_users_email_change_token ON users(email_change_token) WHERE email_change_token IS NOT NULL;
+CREATE UNIQUE INDEX idx_users_email_change_cancel_token ON users(email_change_cancel_token) WHERE email_change_cancel_token IS NOT NULL;
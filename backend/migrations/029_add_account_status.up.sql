@@ -0,0 +1,8 @@
+-- Paused accounts are hidden but kept; accounts pending deletion are purged
+-- once deletion_scheduled_at has passed. Signing in again restores either.
+ALTER TABLE users ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'paused', 'pending_deletion'));
+ALTER TABLE users ADD COLUMN status_changed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_users_deletion_scheduled_at ON users(deletion_scheduled_at) WHERE status = 'pending_deletion';
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS s3_cleanup_queue;
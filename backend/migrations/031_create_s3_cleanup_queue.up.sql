@@ -0,0 +1,13 @@
+-- S3 objects waiting to be deleted. Rows are written in the same transaction
+-- that removes the database records, and a worker deletes the objects with retries.
+CREATE TABLE s3_cleanup_queue (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    s3_key VARCHAR(500) NOT NULL UNIQUE,
+    reason VARCHAR(50) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_s3_cleanup_queue_next_attempt_at ON s3_cleanup_queue(next_attempt_at);
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, created_at DESC);
+
+DROP INDEX IF EXISTS idx_messages_conversation_cursor;
@@ -0,0 +1,5 @@
+-- Keyset pagination orders messages by (created_at, id); id breaks ties
+-- between messages sent in the same microsecond
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_cursor ON messages(conversation_id, created_at DESC, id DESC);
+
+DROP INDEX IF EXISTS idx_messages_conversation;
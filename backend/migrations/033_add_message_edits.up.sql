@@ -0,0 +1,15 @@
+-- Senders can edit a message for a short while after sending it and delete it
+-- for everyone. Deleted messages stay as tombstones so the thread keeps its shape.
+ALTER TABLE messages
+    ADD COLUMN edited_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Content each message had before every edit
+CREATE TABLE message_edits (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    previous_content TEXT NOT NULL,
+    edited_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_message_edits_message_id ON message_edits(message_id, edited_at);
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS read_receipts_enabled;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS delivered_at;
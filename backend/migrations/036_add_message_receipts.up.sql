@@ -0,0 +1,8 @@
+-- delivered_at is set once the recipient's device has the message, read_at once
+-- they've seen it. Messages already read were necessarily delivered.
+ALTER TABLE messages ADD COLUMN delivered_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE messages SET delivered_at = read_at WHERE read_at IS NOT NULL;
+
+-- Users who turn read receipts off neither send nor see them
+ALTER TABLE users ADD COLUMN read_receipts_enabled BOOLEAN NOT NULL DEFAULT TRUE;
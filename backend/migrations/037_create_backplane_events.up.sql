@@ -0,0 +1,9 @@
+-- Hub events too big for a NOTIFY payload (about 8KB) are stored here and only
+-- their id is sent. Rows are read right away and pruned after a few minutes.
+CREATE TABLE backplane_events (
+    id BIGSERIAL PRIMARY KEY,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_backplane_events_created_at ON backplane_events(created_at);
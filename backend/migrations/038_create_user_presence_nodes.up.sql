@@ -0,0 +1,10 @@
+-- Servers the user has WebSocket connections on. With several servers behind the
+-- load balancer, the user stays online until the last of these rows is removed.
+CREATE TABLE user_presence_nodes (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    node_id UUID NOT NULL,
+    connected_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, node_id)
+);
+
+CREATE INDEX idx_user_presence_nodes_node_id ON user_presence_nodes(node_id);
@@ -0,0 +1,4 @@
+ALTER TABLE user_presence DROP COLUMN IF EXISTS forced;
+
+DROP INDEX IF EXISTS idx_user_presence_nodes_last_heartbeat_at;
+ALTER TABLE user_presence_nodes DROP COLUMN IF EXISTS last_heartbeat_at;
@@ -0,0 +1,9 @@
+-- Refreshed by the user's heartbeats. Rows that stop being refreshed belong to a
+-- crashed server or a dead connection and are swept.
+ALTER TABLE user_presence_nodes ADD COLUMN last_heartbeat_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX idx_user_presence_nodes_last_heartbeat_at ON user_presence_nodes(last_heartbeat_at);
+
+-- Set when an admin marks a user online. These users stay online without any
+-- connection until an admin sets them offline.
+ALTER TABLE user_presence ADD COLUMN forced BOOLEAN NOT NULL DEFAULT FALSE;
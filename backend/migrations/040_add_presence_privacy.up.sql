@@ -0,0 +1,6 @@
+-- Invisible mode: other members always see the user as offline
+ALTER TABLE users ADD COLUMN hide_online_status BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- How others see when the user was last online: exact, approximate ("recently",
+-- "this week") or hidden
+ALTER TABLE users ADD COLUMN last_seen_visibility VARCHAR(20) NOT NULL DEFAULT 'exact';
@@ -0,0 +1,30 @@
+-- Last sequence number handed out for each user's events
+CREATE TABLE user_event_sequences (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    last_seq BIGINT NOT NULL DEFAULT 0
+);
+
+-- Outbox of realtime events sent to each user. Clients that reconnect are sent
+-- the ones after the last sequence number they saw.
+CREATE TABLE user_events (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, seq)
+);
+
+-- Retention cleanup
+CREATE INDEX idx_user_events_created_at ON user_events(created_at);
+
+-- Highest sequence number each session has acknowledged. Events acknowledged
+-- by all of a user's live sessions are pruned.
+CREATE TABLE user_event_acks (
+    session_id UUID PRIMARY KEY REFERENCES sessions(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_event_acks_user_id ON user_event_acks(user_id);
@@ -0,0 +1 @@
+ALTER TABLE two_factor_challenges DROP COLUMN IF EXISTS auth_method;
@@ -0,0 +1,3 @@
+-- How the first factor was passed (password, magic link or a provider), so the
+-- session started once the challenge succeeds records it
+ALTER TABLE two_factor_challenges ADD COLUMN auth_method VARCHAR(50) NOT NULL DEFAULT 'password';
@@ -0,0 +1,16 @@
+-- Each open WebSocket connection, on whichever server holds it, so a user's
+-- device list shows every connection and not only those on the server that
+-- answered. Rows are refreshed by the connection's heartbeats and swept with
+-- user_presence_nodes when a server crashes.
+CREATE TABLE user_connections (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id UUID NOT NULL,
+    node_id UUID NOT NULL,
+    remote_addr VARCHAR(64) NOT NULL DEFAULT '',
+    connected_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_heartbeat_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_connections_user_id ON user_connections(user_id);
+CREATE INDEX idx_user_connections_last_heartbeat_at ON user_connections(last_heartbeat_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_messages_conversation_updated;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,17 @@
+-- When a message last changed: sent, edited, deleted or reacted to. Sync walks
+-- messages in (updated_at, id) order so changes made while a client was
+-- offline reach it along with new messages.
+ALTER TABLE messages ADD COLUMN updated_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE messages SET updated_at = GREATEST(
+    created_at,
+    COALESCE(edited_at, created_at),
+    COALESCE(deleted_at, created_at),
+    COALESCE((SELECT MAX(r.created_at) FROM message_reactions r WHERE r.message_id = messages.id), created_at)
+);
+
+ALTER TABLE messages
+    ALTER COLUMN updated_at SET NOT NULL,
+    ALTER COLUMN updated_at SET DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_updated ON messages(conversation_id, updated_at, id);
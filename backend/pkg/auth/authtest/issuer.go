@@ -0,0 +1,227 @@
+// Package authtest runs a fake OpenID Connect provider for tests. It serves
+// discovery, a JWKS and a token endpoint that enforces PKCE, and signs id_tokens
+// for whichever user the test says approved the login.
+package authtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ClientID     = "test-client"
+	ClientSecret = "test-secret"
+	keyID        = "test-key"
+)
+
+// User is the account a test signs in with at the fake provider
+type User struct {
+	Subject string
+	Email   string
+	// EmailVerified is sent as is, so tests can use a bool or a string
+	EmailVerified interface{}
+}
+
+type grant struct {
+	challenge string
+	nonce     string
+	user      User
+}
+
+// Issuer is a running fake provider. Its fields change how the next tokens are
+// issued and must be set before the exchange.
+type Issuer struct {
+	URL string
+
+	// SignWithUnknownKey signs id_tokens with a key that isn't in the JWKS,
+	// under the published key ID
+	SignWithUnknownKey bool
+	// OmitIDToken leaves the id_token out of the token response
+	OmitIDToken bool
+	// Audience overrides the id_token audience, which is the client ID by default
+	Audience string
+
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	grants map[string]*grant
+}
+
+// NewIssuer starts a fake provider that is shut down when the test ends
+func NewIssuer(t testing.TB) *Issuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate signing key: %v", err)
+	}
+
+	issuer := &Issuer{key: key, grants: make(map[string]*grant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/jwks", issuer.jwks)
+	mux.HandleFunc("/token", issuer.token)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	issuer.URL = server.URL
+
+	return issuer
+}
+
+// Authorize plays the user approving the login at the provider's authorization
+// URL and returns the code the provider would redirect back with
+func (i *Issuer) Authorize(authURL string, user User) (string, error) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+
+	if query.Get("client_id") != ClientID {
+		return "", fmt.Errorf("unexpected client_id %q", query.Get("client_id"))
+	}
+	if query.Get("response_type") != "code" {
+		return "", fmt.Errorf("unexpected response_type %q", query.Get("response_type"))
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		return "", fmt.Errorf("authorization request is missing an S256 code challenge")
+	}
+	if query.Get("state") == "" || query.Get("nonce") == "" {
+		return "", fmt.Errorf("authorization request is missing state or nonce")
+	}
+
+	code := randomString()
+	i.mu.Lock()
+	i.grants[code] = &grant{
+		challenge: query.Get("code_challenge"),
+		nonce:     query.Get("nonce"),
+		user:      user,
+	}
+	i.mu.Unlock()
+
+	return code, nil
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"jwks_uri":               i.URL + "/jwks",
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != ClientID || clientSecret != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	// Codes work once, like at a real provider
+	i.mu.Lock()
+	g, ok := i.grants[r.PostForm.Get("code")]
+	delete(i.grants, r.PostForm.Get("code"))
+	i.mu.Unlock()
+
+	if !ok || !verifierMatches(r.PostForm.Get("code_verifier"), g.challenge) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	response := map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	}
+	if !i.OmitIDToken {
+		idToken, err := i.signIDToken(g)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+			return
+		}
+		response["id_token"] = idToken
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (i *Issuer) signIDToken(g *grant) (string, error) {
+	audience := i.Audience
+	if audience == "" {
+		audience = ClientID
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            i.URL,
+		"sub":            g.user.Subject,
+		"aud":            audience,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+	})
+	token.Header["kid"] = keyID
+
+	key := i.key
+	if i.SignWithUnknownKey {
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			return "", err
+		}
+	}
+	return token.SignedString(key)
+}
+
+func verifierMatches(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier != "" && base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
+
+func randomString() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,76 +1,15 @@
 package auth
 
-import (
-	"context"
-	"encoding/json"
-	"io"
-	"net/http"
-
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-)
-
-type GoogleAuth struct {
-	config *oauth2.Config
-}
-
-type GoogleUserInfo struct {
-	ID            string `json:"id"`
-	Email         string `json:"email"`
-	VerifiedEmail bool   `json:"verified_email"`
-	Name          string `json:"name"`
-	GivenName     string `json:"given_name"`
-	FamilyName    string `json:"family_name"`
-	Picture       string `json:"picture"`
-}
-
-func NewGoogleAuth(clientID, clientSecret, redirectURL string) *GoogleAuth {
-	return &GoogleAuth{
-		config: &oauth2.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			RedirectURL:  redirectURL,
-			Scopes: []string{
-				"https://www.googleapis.com/auth/userinfo.email",
-				"https://www.googleapis.com/auth/userinfo.profile",
-			},
-			Endpoint: google.Endpoint,
-		},
-	}
-}
-
-func (g *GoogleAuth) GetAuthURL() string {
-	return g.config.AuthCodeURL("state", oauth2.AccessTypeOffline)
-}
-
-func (g *GoogleAuth) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
-	return g.config.Exchange(ctx, code)
+const googleIssuer = "https://accounts.google.com"
+
+// NewGoogleProvider returns Google sign in as an OpenID Connect provider
+func NewGoogleProvider(clientID, clientSecret, redirectURL string) *OIDCProvider {
+	return NewOIDCProvider(OIDCConfig{
+		Name:         "google",
+		IssuerURL:    googleIssuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	})
 }
-
-func (g *GoogleAuth) GetUserInfo(ctx context.Context, token *oauth2.Token) (*GoogleUserInfo, error) {
-	client := g.config.Client(ctx, token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, err
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	var userInfo GoogleUserInfo
-	if err := json.Unmarshal(body, &userInfo); err != nil {
-		return nil, err
-	}
-
-	return &userInfo, nil
-}
-
-
-
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"heyspoilme/pkg/auth/authtest"
+)
+
+func newTestProvider(issuer *authtest.Issuer) *OIDCProvider {
+	return NewOIDCProvider(OIDCConfig{
+		Name:         "test",
+		IssuerURL:    issuer.URL,
+		ClientID:     authtest.ClientID,
+		ClientSecret: authtest.ClientSecret,
+		RedirectURL:  "http://localhost/callback",
+	})
+}
+
+// login runs the authorization code flow up to the exchange, returning the code
+// and the verifier the authorization URL was built with
+func login(t *testing.T, issuer *authtest.Issuer, provider *OIDCProvider, nonce string, user authtest.User) (string, string) {
+	t.Helper()
+
+	verifier := oauth2.GenerateVerifier()
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	code, err := issuer.Authorize(authURL, user)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	return code, verifier
+}
+
+func TestOIDCExchange(t *testing.T) {
+	issuer := authtest.NewIssuer(t)
+	provider := newTestProvider(issuer)
+
+	code, verifier := login(t, issuer, provider, "nonce-1", authtest.User{
+		Subject:       "subject-1",
+		Email:         "Someone@Example.com",
+		EmailVerified: true,
+	})
+
+	identity, err := provider.Exchange(context.Background(), code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	want := Identity{Provider: "test", Subject: "subject-1", Email: "someone@example.com", EmailVerified: true}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	issuer := authtest.NewIssuer(t)
+	provider := newTestProvider(issuer)
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "the-state", "the-nonce", oauth2.GenerateVerifier())
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("invalid URL %q: %v", authURL, err)
+	}
+	query := parsed.Query()
+	if got := parsed.Scheme + "://" + parsed.Host + parsed.Path; got != issuer.URL+"/authorize" {
+		t.Errorf("endpoint = %q, want the discovered authorization endpoint", got)
+	}
+	for param, want := range map[string]string{
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge_method": "S256",
+	} {
+		if got := query.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+}
+
+func TestOIDCExchangeRejects(t *testing.T) {
+	user := authtest.User{Subject: "subject-1", Email: "someone@example.com", EmailVerified: true}
+
+	tests := []struct {
+		name    string
+		setup   func(issuer *authtest.Issuer)
+		nonce   string
+		badPKCE bool
+		wantErr error
+	}{
+		{
+			name:    "nonce mismatch",
+			nonce:   "another-nonce",
+			wantErr: ErrNonceMismatch,
+		},
+		{
+			name:    "wrong code verifier",
+			badPKCE: true,
+		},
+		{
+			name:    "signature by unknown key",
+			setup:   func(issuer *authtest.Issuer) { issuer.SignWithUnknownKey = true },
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name:    "token for another client",
+			setup:   func(issuer *authtest.Issuer) { issuer.Audience = "another-client" },
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name:    "missing id_token",
+			setup:   func(issuer *authtest.Issuer) { issuer.OmitIDToken = true },
+			wantErr: ErrMissingIDToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := authtest.NewIssuer(t)
+			provider := newTestProvider(issuer)
+			if tt.setup != nil {
+				tt.setup(issuer)
+			}
+
+			code, verifier := login(t, issuer, provider, "nonce-1", user)
+			if tt.badPKCE {
+				verifier = oauth2.GenerateVerifier()
+			}
+			nonce := "nonce-1"
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			identity, err := provider.Exchange(context.Background(), code, verifier, nonce)
+			if err == nil {
+				t.Fatalf("Exchange returned %+v, want an error", identity)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			var retrieveErr *oauth2.RetrieveError
+			if tt.badPKCE && (!errors.As(err, &retrieveErr) || retrieveErr.ErrorCode != "invalid_grant") {
+				t.Errorf("err = %v, want the provider to reject the grant", err)
+			}
+		})
+	}
+}
+
+func TestOIDCExchangeCodeIsSingleUse(t *testing.T) {
+	issuer := authtest.NewIssuer(t)
+	provider := newTestProvider(issuer)
+
+	code, verifier := login(t, issuer, provider, "nonce-1", authtest.User{Subject: "subject-1", Email: "someone@example.com", EmailVerified: true})
+	if _, err := provider.Exchange(context.Background(), code, verifier, "nonce-1"); err != nil {
+		t.Fatalf("first Exchange: %v", err)
+	}
+	if _, err := provider.Exchange(context.Background(), code, verifier, "nonce-1"); err == nil {
+		t.Fatal("second Exchange with the same code succeeded")
+	}
+}
+
+func TestOIDCEmailVerified(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim interface{}
+		want  bool
+	}{
+		{"bool true", true, true},
+		{"bool false", false, false},
+		{"string true", "true", true},
+		{"string false", "false", false},
+		{"missing", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := authtest.NewIssuer(t)
+			provider := newTestProvider(issuer)
+
+			code, verifier := login(t, issuer, provider, "nonce-1", authtest.User{
+				Subject:       "subject-1",
+				Email:         "someone@example.com",
+				EmailVerified: tt.claim,
+			})
+
+			identity, err := provider.Exchange(context.Background(), code, verifier, "nonce-1")
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			if identity.EmailVerified != tt.want {
+				t.Errorf("EmailVerified = %v, want %v", identity.EmailVerified, tt.want)
+			}
+		})
+	}
+}
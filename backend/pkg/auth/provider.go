@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sort"
+)
+
+// Provider is an external identity provider users can sign in with using the
+// authorization code flow. Implementations must check the nonce and send the
+// PKCE verifier on exchange.
+type Provider interface {
+	Name() string
+	AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error)
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error)
+}
+
+// Identity is the user an identity provider vouched for
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Providers looks up configured identity providers by name
+type Providers map[string]Provider
+
+func NewProviders(providers ...Provider) Providers {
+	registry := make(Providers, len(providers))
+	for _, p := range providers {
+		registry[p.Name()] = p
+	}
+	return registry
+}
+
+func (p Providers) Get(name string) (Provider, bool) {
+	provider, ok := p[name]
+	return provider, ok
+}
+
+// Names returns the configured provider names in a stable order
+func (p Providers) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RandomToken returns a URL-safe random string for state, nonce and one-time codes
+func RandomToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
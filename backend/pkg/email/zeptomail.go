@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
 	"time"
@@ -109,6 +110,330 @@ func (c *ZeptoMailClient) SendVerificationEmail(toEmail, token string) error {
 	return c.sendEmail(toEmail, "Verify your HeySpoilMe email", htmlBody)
 }
 
+func (c *ZeptoMailClient) SendPasswordResetEmail(toEmail, token string) error {
+	resetURL := fmt.Sprintf("%s/auth/reset-password?token=%s", c.frontendURL, token)
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Reset Your Password</title>
+</head>
+<body style="margin: 0; padding: 0; background-color: #0a0a0a; font-family: 'Montserrat', -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif;">
+    <table role="presentation" style="width: 100%%; max-width: 600px; margin: 0 auto; padding: 40px 20px;">
+        <tr>
+            <td style="text-align: center; padding-bottom: 30px;">
+                <h1 style="color: #ffffff; font-size: 28px; margin: 0; font-weight: 600;">HeySpoilMe</h1>
+            </td>
+        </tr>
+        <tr>
+            <td style="background: rgba(255, 255, 255, 0.05); border: 1px solid rgba(255, 255, 255, 0.1); padding: 40px;">
+                <h2 style="color: #ffffff; font-size: 24px; margin: 0 0 20px 0; font-weight: 500;">Reset Your Password</h2>
+                <p style="color: rgba(255, 255, 255, 0.7); font-size: 16px; line-height: 1.6; margin: 0 0 30px 0;">
+                    We received a request to reset the password for your HeySpoilMe account. Click the button below to choose a new one.
+                </p>
+                <table role="presentation" style="width: 100%%;">
+                    <tr>
+                        <td style="text-align: center;">
+                            <a href="%s" style="display: inline-block; background: #ffffff; color: #000000; padding: 16px 40px; text-decoration: none; font-weight: 600; font-size: 16px;">
+                                Reset Password
+                            </a>
+                        </td>
+                    </tr>
+                </table>
+                <p style="color: rgba(255, 255, 255, 0.5); font-size: 14px; line-height: 1.6; margin: 30px 0 0 0;">
+                    This link will expire in 1 hour and can only be used once. Resetting your password signs you out on all devices. If you didn't request a reset, you can safely ignore this email.
+                </p>
+            </td>
+        </tr>
+        <tr>
+            <td style="text-align: center; padding-top: 30px;">
+                <p style="color: rgba(255, 255, 255, 0.4); font-size: 12px; margin: 0;">
+                    © 2026 HeySpoilMe. All rights reserved.
+                </p>
+            </td>
+        </tr>
+    </table>
+</body>
+</html>
+`, resetURL)
+
+	return c.sendEmail(toEmail, "Reset your HeySpoilMe password", htmlBody)
+}
+
+// SendMagicLinkEmail sends a one-time passwordless sign-in link
+func (c *ZeptoMailClient) SendMagicLinkEmail(toEmail, token string) error {
+	signinURL := fmt.Sprintf("%s/auth/magic-link?token=%s", c.frontendURL, token)
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Sign In to HeySpoilMe</title>
+</head>
+<body style="margin: 0; padding: 0; background-color: #0a0a0a; font-family: 'Montserrat', -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif;">
+    <table role="presentation" style="width: 100%%; max-width: 600px; margin: 0 auto; padding: 40px 20px;">
+        <tr>
+            <td style="text-align: center; padding-bottom: 30px;">
+                <h1 style="color: #ffffff; font-size: 28px; margin: 0; font-weight: 600;">HeySpoilMe</h1>
+            </td>
+        </tr>
+        <tr>
+            <td style="background: rgba(255, 255, 255, 0.05); border: 1px solid rgba(255, 255, 255, 0.1); padding: 40px;">
+                <h2 style="color: #ffffff; font-size: 24px; margin: 0 0 20px 0; font-weight: 500;">Sign In to HeySpoilMe</h2>
+                <p style="color: rgba(255, 255, 255, 0.7); font-size: 16px; line-height: 1.6; margin: 0 0 30px 0;">
+                    Click the button below to sign in. If you don't have an account yet, one will be created for this email address.
+                </p>
+                <table role="presentation" style="width: 100%%;">
+                    <tr>
+                        <td style="text-align: center;">
+                            <a href="%s" style="display: inline-block; background: #ffffff; color: #000000; padding: 16px 40px; text-decoration: none; font-weight: 600; font-size: 16px;">
+                                Sign In
+                            </a>
+                        </td>
+                    </tr>
+                </table>
+                <p style="color: rgba(255, 255, 255, 0.5); font-size: 14px; line-height: 1.6; margin: 30px 0 0 0;">
+                    This link will expire in 15 minutes and can only be used once. If you didn't request it, you can safely ignore this email.
+                </p>
+            </td>
+        </tr>
+        <tr>
+            <td style="text-align: center; padding-top: 30px;">
+                <p style="color: rgba(255, 255, 255, 0.4); font-size: 12px; margin: 0;">
+                    © 2026 HeySpoilMe. All rights reserved.
+                </p>
+            </td>
+        </tr>
+    </table>
+</body>
+</html>
+`, signinURL)
+
+	return c.sendEmail(toEmail, "Your HeySpoilMe sign-in link", htmlBody)
+}
+
+// SendAccountLockedEmail tells the account owner that signin was temporarily locked
+// after repeated failed password attempts
+func (c *ZeptoMailClient) SendAccountLockedEmail(toEmail string, lockedMinutes int) error {
+	loginURL := fmt.Sprintf("%s/auth/login", c.frontendURL)
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Sign In Temporarily Locked</title>
+</head>
+<body style="margin: 0; padding: 0; background-color: #0a0a0a; font-family: 'Montserrat', -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif;">
+    <table role="presentation" style="width: 100%%; max-width: 600px; margin: 0 auto; padding: 40px 20px;">
+        <tr>
+            <td style="text-align: center; padding-bottom: 30px;">
+                <h1 style="color: #ffffff; font-size: 28px; margin: 0; font-weight: 600;">HeySpoilMe</h1>
+            </td>
+        </tr>
+        <tr>
+            <td style="background: rgba(255, 255, 255, 0.05); border: 1px solid rgba(255, 255, 255, 0.1); padding: 40px;">
+                <h2 style="color: #ffffff; font-size: 24px; margin: 0 0 20px 0; font-weight: 500;">Sign In Temporarily Locked</h2>
+                <p style="color: rgba(255, 255, 255, 0.7); font-size: 16px; line-height: 1.6; margin: 0 0 30px 0;">
+                    There were several failed attempts to sign in to your HeySpoilMe account, so we have paused password sign in for %d minutes to keep it safe.
+                </p>
+                <table role="presentation" style="width: 100%%;">
+                    <tr>
+                        <td style="text-align: center;">
+                            <a href="%s" style="display: inline-block; background: #ffffff; color: #000000; padding: 16px 40px; text-decoration: none; font-weight: 600; font-size: 16px;">
+                                Go to Sign In
+                            </a>
+                        </td>
+                    </tr>
+                </table>
+                <p style="color: rgba(255, 255, 255, 0.5); font-size: 14px; line-height: 1.6; margin: 30px 0 0 0;">
+                    If this was you, just wait and try again. If it wasn't, we recommend resetting your password once the lock expires.
+                </p>
+            </td>
+        </tr>
+        <tr>
+            <td style="text-align: center; padding-top: 30px;">
+                <p style="color: rgba(255, 255, 255, 0.4); font-size: 12px; margin: 0;">
+                    © 2026 HeySpoilMe. All rights reserved.
+                </p>
+            </td>
+        </tr>
+    </table>
+</body>
+</html>
+`, lockedMinutes, loginURL)
+
+	return c.sendEmail(toEmail, "Sign in to your HeySpoilMe account was locked", htmlBody)
+}
+
+// SendEmailChangeConfirmation asks the owner of the new address to confirm an email change
+func (c *ZeptoMailClient) SendEmailChangeConfirmation(toEmail, token string) error {
+	confirmURL := fmt.Sprintf("%s/auth/confirm-email-change?token=%s", c.frontendURL, token)
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Confirm Your New Email</title>
+</head>
+<body style="margin: 0; padding: 0; background-color: #0a0a0a; font-family: 'Montserrat', -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif;">
+    <table role="presentation" style="width: 100%%; max-width: 600px; margin: 0 auto; padding: 40px 20px;">
+        <tr>
+            <td style="text-align: center; padding-bottom: 30px;">
+                <h1 style="color: #ffffff; font-size: 28px; margin: 0; font-weight: 600;">HeySpoilMe</h1>
+            </td>
+        </tr>
+        <tr>
+            <td style="background: rgba(255, 255, 255, 0.05); border: 1px solid rgba(255, 255, 255, 0.1); padding: 40px;">
+                <h2 style="color: #ffffff; font-size: 24px; margin: 0 0 20px 0; font-weight: 500;">Confirm Your New Email</h2>
+                <p style="color: rgba(255, 255, 255, 0.7); font-size: 16px; line-height: 1.6; margin: 0 0 30px 0;">
+                    Someone asked to use this address for their HeySpoilMe account. Click the button below to confirm the change.
+                </p>
+                <table role="presentation" style="width: 100%%;">
+                    <tr>
+                        <td style="text-align: center;">
+                            <a href="%s" style="display: inline-block; background: #ffffff; color: #000000; padding: 16px 40px; text-decoration: none; font-weight: 600; font-size: 16px;">
+                                Confirm Email
+                            </a>
+                        </td>
+                    </tr>
+                </table>
+                <p style="color: rgba(255, 255, 255, 0.5); font-size: 14px; line-height: 1.6; margin: 30px 0 0 0;">
+                    This link will expire in 24 hours. Your account keeps its current email until you confirm. If you didn't request this, you can safely ignore this email.
+                </p>
+            </td>
+        </tr>
+        <tr>
+            <td style="text-align: center; padding-top: 30px;">
+                <p style="color: rgba(255, 255, 255, 0.4); font-size: 12px; margin: 0;">
+                    © 2026 HeySpoilMe. All rights reserved.
+                </p>
+            </td>
+        </tr>
+    </table>
+</body>
+</html>
+`, confirmURL)
+
+	return c.sendEmail(toEmail, "Confirm your new HeySpoilMe email", htmlBody)
+}
+
+// SendEmailChangeNotice tells the current address that an email change was
+// requested and offers a link to cancel it
+func (c *ZeptoMailClient) SendEmailChangeNotice(toEmail, newEmail, cancelToken string) error {
+	cancelURL := fmt.Sprintf("%s/auth/cancel-email-change?token=%s", c.frontendURL, cancelToken)
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Email Change Requested</title>
+</head>
+<body style="margin: 0; padding: 0; background-color: #0a0a0a; font-family: 'Montserrat', -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif;">
+    <table role="presentation" style="width: 100%%; max-width: 600px; margin: 0 auto; padding: 40px 20px;">
+        <tr>
+            <td style="text-align: center; padding-bottom: 30px;">
+                <h1 style="color: #ffffff; font-size: 28px; margin: 0; font-weight: 600;">HeySpoilMe</h1>
+            </td>
+        </tr>
+        <tr>
+            <td style="background: rgba(255, 255, 255, 0.05); border: 1px solid rgba(255, 255, 255, 0.1); padding: 40px;">
+                <h2 style="color: #ffffff; font-size: 24px; margin: 0 0 20px 0; font-weight: 500;">Email Change Requested</h2>
+                <p style="color: rgba(255, 255, 255, 0.7); font-size: 16px; line-height: 1.6; margin: 0 0 30px 0;">
+                    We received a request to change the email on your HeySpoilMe account to <strong style="color: #ffffff;">%s</strong>. The change takes effect once the new address is confirmed.
+                </p>
+                <table role="presentation" style="width: 100%%;">
+                    <tr>
+                        <td style="text-align: center;">
+                            <a href="%s" style="display: inline-block; background: #ffffff; color: #000000; padding: 16px 40px; text-decoration: none; font-weight: 600; font-size: 16px;">
+                                This Wasn't Me
+                            </a>
+                        </td>
+                    </tr>
+                </table>
+                <p style="color: rgba(255, 255, 255, 0.5); font-size: 14px; line-height: 1.6; margin: 30px 0 0 0;">
+                    If you didn't make this request, click the button above within 7 days to cancel it, even if it was already confirmed, and sign out all devices. We also recommend changing your password.
+                </p>
+            </td>
+        </tr>
+        <tr>
+            <td style="text-align: center; padding-top: 30px;">
+                <p style="color: rgba(255, 255, 255, 0.4); font-size: 12px; margin: 0;">
+                    © 2026 HeySpoilMe. All rights reserved.
+                </p>
+            </td>
+        </tr>
+    </table>
+</body>
+</html>
+`, html.EscapeString(newEmail), cancelURL)
+
+	return c.sendEmail(toEmail, "Your HeySpoilMe email is being changed", htmlBody)
+}
+
+// SendDataExportReadyEmail sends the download link for a personal data export
+func (c *ZeptoMailClient) SendDataExportReadyEmail(toEmail, downloadURL string, validHours int) error {
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Your Data Export Is Ready</title>
+</head>
+<body style="margin: 0; padding: 0; background-color: #0a0a0a; font-family: 'Montserrat', -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif;">
+    <table role="presentation" style="width: 100%%; max-width: 600px; margin: 0 auto; padding: 40px 20px;">
+        <tr>
+            <td style="text-align: center; padding-bottom: 30px;">
+                <h1 style="color: #ffffff; font-size: 28px; margin: 0; font-weight: 600;">HeySpoilMe</h1>
+            </td>
+        </tr>
+        <tr>
+            <td style="background: rgba(255, 255, 255, 0.05); border: 1px solid rgba(255, 255, 255, 0.1); padding: 40px;">
+                <h2 style="color: #ffffff; font-size: 24px; margin: 0 0 20px 0; font-weight: 500;">Your Data Export Is Ready</h2>
+                <p style="color: rgba(255, 255, 255, 0.7); font-size: 16px; line-height: 1.6; margin: 0 0 30px 0;">
+                    The copy of your HeySpoilMe data you asked for is ready. It contains your account, profile, photos, likes, conversations and notifications.
+                </p>
+                <table role="presentation" style="width: 100%%;">
+                    <tr>
+                        <td style="text-align: center;">
+                            <a href="%s" style="display: inline-block; background: #ffffff; color: #000000; padding: 16px 40px; text-decoration: none; font-weight: 600; font-size: 16px;">
+                                Download Archive
+                            </a>
+                        </td>
+                    </tr>
+                </table>
+                <p style="color: rgba(255, 255, 255, 0.5); font-size: 14px; line-height: 1.6; margin: 30px 0 0 0;">
+                    This link will expire in %d hours. The archive contains personal information, so keep it somewhere safe. If you didn't request it, please change your password.
+                </p>
+            </td>
+        </tr>
+        <tr>
+            <td style="text-align: center; padding-top: 30px;">
+                <p style="color: rgba(255, 255, 255, 0.4); font-size: 12px; margin: 0;">
+                    © 2026 HeySpoilMe. All rights reserved.
+                </p>
+            </td>
+        </tr>
+    </table>
+</body>
+</html>
+`, downloadURL, validHours)
+
+	return c.sendEmail(toEmail, "Your HeySpoilMe data export is ready", htmlBody)
+}
+
 func (c *ZeptoMailClient) SendNewMessageNotification(toEmail, senderName, messagePreview string) error {
 	messagesURL := fmt.Sprintf("%s/messages", c.frontendURL)
 	
@@ -0,0 +1,21 @@
+package storage
+
+import "strings"
+
+// KeyFromURL extracts the object key from a public URL
+// Example: https://cdn.heyspoilme.com/chat/xxx/yyy/file.webp -> chat/xxx/yyy/file.webp
+func KeyFromURL(url string) string {
+	// Find common prefixes
+	prefixes := []string{
+		"/profiles/",
+		"/chat/",
+	}
+
+	for _, prefix := range prefixes {
+		if idx := strings.Index(url, prefix); idx != -1 {
+			return url[idx+1:] // Skip the leading slash
+		}
+	}
+
+	return ""
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"time"
 
@@ -103,3 +104,46 @@ func (s *S3Client) DeleteObject(key string) error {
 	return err
 }
 
+// GetObject opens an object for reading. The caller must close the body.
+func (s *S3Client) GetObject(key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// PutObject uploads an object. The body should be seekable (e.g. an *os.File)
+// so the SDK can sign the payload without buffering it.
+func (s *S3Client) PutObject(key, contentType string, body io.Reader) error {
+	_, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		Body:        body,
+	})
+	return err
+}
+
+// GetPresignedDownloadURL returns a time-limited link to a private object.
+// S3 caps the lifetime at 7 days.
+func (s *S3Client) GetPresignedDownloadURL(key, filename string, expires time.Duration) (string, error) {
+	presignClient := s3.NewPresignClient(s.client)
+
+	req, err := presignClient.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket:                     aws.String(s.bucket),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(fmt.Sprintf(`attachment; filename="%s"`, filename)),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = expires
+	})
+	if err != nil {
+		log.Printf("[S3] ERROR creating presigned download URL: %v", err)
+		return "", err
+	}
+
+	return req.URL, nil
+}
@@ -0,0 +1,171 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSABits = 2048
+
+var supportedAlgorithms = []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+
+type key struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer // nil for keys that are only kept to verify older tokens
+	public  crypto.PublicKey
+}
+
+// KeySet holds every key tokens may be verified with and the one new tokens
+// are signed with.
+//
+// Rotation: add the new key to the set, deploy, switch the active key ID to it,
+// and remove the old key once tokens signed with it have expired.
+type KeySet struct {
+	keys   map[string]*key
+	active *key
+}
+
+// LoadKeySet reads keys from spec, a comma separated list of kid:path entries
+// pointing at PEM files. Private keys (PKCS#8 or PKCS#1, RSA or Ed25519) can
+// sign; public keys are only used to verify tokens from a retired key.
+// activeKID selects the signing key and defaults to the first private key.
+func LoadKeySet(spec, activeKID string) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*key)}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kid, path, ok := strings.Cut(entry, ":")
+		if !ok || kid == "" || path == "" {
+			return nil, fmt.Errorf("invalid key entry %q, expected kid:path", entry)
+		}
+		if _, exists := ks.keys[kid]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", kid)
+		}
+
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %q: %w", kid, err)
+		}
+
+		k, err := parseKey(kid, pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys[kid] = k
+
+		if ks.active == nil && k.private != nil && activeKID == "" {
+			ks.active = k
+		}
+	}
+
+	if activeKID != "" {
+		k, ok := ks.keys[activeKID]
+		if !ok {
+			return nil, fmt.Errorf("active key %q is not in the key set", activeKID)
+		}
+		if k.private == nil {
+			return nil, fmt.Errorf("active key %q has no private key", activeKID)
+		}
+		ks.active = k
+	}
+
+	if ks.active == nil {
+		return nil, errors.New("key set has no private key to sign with")
+	}
+
+	return ks, nil
+}
+
+// NewEphemeralKeySet generates a throwaway Ed25519 key for local development.
+// Tokens signed with it stop working when the process restarts.
+func NewEphemeralKeySet() (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &key{
+		id:      "ephemeral",
+		method:  jwt.SigningMethodEdDSA,
+		private: private,
+		public:  public,
+	}
+	log.Println("[Token] WARNING: no JWT signing keys configured, using an ephemeral key. Tokens will not survive a restart.")
+
+	return &KeySet{
+		keys:   map[string]*key{k.id: k},
+		active: k,
+	}, nil
+}
+
+// ActiveKeyID returns the ID of the key new tokens are signed with
+func (ks *KeySet) ActiveKeyID() string {
+	return ks.active.id
+}
+
+func parseKey(kid string, pemBytes []byte) (*key, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("key %q is not PEM encoded", kid)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY", "RSA PRIVATE KEY":
+		var parsed interface{}
+		var err error
+		if block.Type == "RSA PRIVATE KEY" {
+			parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		} else {
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key %q: %w", kid, err)
+		}
+
+		switch private := parsed.(type) {
+		case *rsa.PrivateKey:
+			if private.N.BitLen() < minRSABits {
+				return nil, fmt.Errorf("RSA key %q must be at least %d bits", kid, minRSABits)
+			}
+			return &key{id: kid, method: jwt.SigningMethodRS256, private: private, public: private.Public()}, nil
+		case ed25519.PrivateKey:
+			return &key{id: kid, method: jwt.SigningMethodEdDSA, private: private, public: private.Public()}, nil
+		}
+		return nil, fmt.Errorf("key %q must be an RSA or Ed25519 key", kid)
+
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %q: %w", kid, err)
+		}
+
+		switch public := parsed.(type) {
+		case *rsa.PublicKey:
+			if public.N.BitLen() < minRSABits {
+				return nil, fmt.Errorf("RSA key %q must be at least %d bits", kid, minRSABits)
+			}
+			return &key{id: kid, method: jwt.SigningMethodRS256, public: public}, nil
+		case ed25519.PublicKey:
+			return &key{id: kid, method: jwt.SigningMethodEdDSA, public: public}, nil
+		}
+		return nil, fmt.Errorf("key %q must be an RSA or Ed25519 key", kid)
+	}
+
+	return nil, fmt.Errorf("key %q has unsupported PEM type %q", kid, block.Type)
+}
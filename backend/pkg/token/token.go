@@ -0,0 +1,82 @@
+// Package token signs and validates the access tokens used by the HTTP API and
+// the WebSocket endpoint. Tokens are signed with asymmetric keys (RS256 or
+// EdDSA) and carry a key ID, so keys can be rotated without logging users out.
+package token
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const issuer = "heyspoilme"
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrUnknownKey   = errors.New("token signed with unknown key")
+)
+
+// Claims are the claims carried by an access token
+type Claims struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	SessionID uuid.UUID `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// NewClaims returns claims for an access token valid for ttl
+func NewClaims(userID uuid.UUID, email string, sessionID uuid.UUID, ttl time.Duration) *Claims {
+	now := time.Now()
+	return &Claims{
+		UserID:    userID,
+		Email:     email,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    issuer,
+		},
+	}
+}
+
+// Sign signs the claims with the key set's active key
+func (ks *KeySet) Sign(claims *Claims) (string, error) {
+	if ks.active == nil {
+		return "", errors.New("no active signing key")
+	}
+
+	t := jwt.NewWithClaims(ks.active.method, claims)
+	t.Header["kid"] = ks.active.id
+	return t.SignedString(ks.active.private)
+}
+
+// Parse validates a token and returns its claims. The token must name a known
+// key ID and use that key's algorithm, so it can't be forged with a shared
+// secret or by switching algorithms.
+func (ks *KeySet) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims,
+		func(t *jwt.Token) (interface{}, error) {
+			kid, _ := t.Header["kid"].(string)
+			key, ok := ks.keys[kid]
+			if !ok {
+				return nil, ErrUnknownKey
+			}
+			if t.Method.Alg() != key.method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+			}
+			return key.public, nil
+		},
+		jwt.WithValidMethods(supportedAlgorithms),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	return claims, nil
+}
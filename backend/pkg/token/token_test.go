@@ -0,0 +1,299 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// testKeys are generated once, RSA keys are slow to create
+var (
+	testRSAKey     *rsa.PrivateKey
+	testEd25519Key ed25519.PrivateKey
+	testRetiredKey ed25519.PrivateKey
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	if testRSAKey, err = rsa.GenerateKey(rand.Reader, minRSABits); err != nil {
+		panic(err)
+	}
+	if _, testEd25519Key, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		panic(err)
+	}
+	if _, testRetiredKey, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// writePEM writes a PKCS#8 private key, or its PKIX public key, to a file in
+// dir and returns its path
+func writePEM(t *testing.T, dir, name string, private crypto.Signer, publicOnly bool) string {
+	t.Helper()
+
+	var block *pem.Block
+	if publicOnly {
+		der, err := x509.MarshalPKIXPublicKey(private.Public())
+		if err != nil {
+			t.Fatalf("marshal public key: %v", err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			t.Fatalf("marshal private key: %v", err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+
+	path := filepath.Join(dir, name+".pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path
+}
+
+// signRaw signs claims outside the key set, so tests can pick the kid, the
+// algorithm and the key independently
+func signRaw(t *testing.T, method jwt.SigningMethod, kid string, signingKey interface{}, claims *Claims) string {
+	t.Helper()
+
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	signed, err := tok.SignedString(signingKey)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed
+}
+
+func testClaims() *Claims {
+	return NewClaims(uuid.New(), "someone@example.com", uuid.New(), time.Hour)
+}
+
+func TestKeySetRotation(t *testing.T) {
+	dir := t.TempDir()
+	rsaPath := writePEM(t, dir, "rsa", testRSAKey, false)
+	edPath := writePEM(t, dir, "ed", testEd25519Key, false)
+	edPublicPath := writePEM(t, dir, "ed-public", testEd25519Key, true)
+
+	// Before the rotation the RSA key signs
+	before, err := LoadKeySet("old:"+rsaPath+",new:"+edPath, "old")
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	oldToken, err := before.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	// After switching the active key, tokens from the old key still verify
+	after, err := LoadKeySet("old:"+rsaPath+",new:"+edPath, "new")
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	if after.ActiveKeyID() != "new" {
+		t.Errorf("ActiveKeyID = %q, want new", after.ActiveKeyID())
+	}
+	if _, err := after.Parse(oldToken); err != nil {
+		t.Errorf("token from the previous key: %v", err)
+	}
+
+	claims := testClaims()
+	newToken, err := after.Sign(claims)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	parsed, err := after.Parse(newToken)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if parsed.UserID != claims.UserID || parsed.SessionID != claims.SessionID {
+		t.Errorf("parsed claims = %+v, want %+v", parsed, claims)
+	}
+
+	// A public key alone verifies but can't be made the signing key
+	if _, err := LoadKeySet("new:"+edPublicPath, "new"); err == nil {
+		t.Error("LoadKeySet accepted a public key as the active key")
+	}
+	verifyOnly, err := LoadKeySet("old:"+rsaPath+",new:"+edPublicPath, "")
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	if verifyOnly.ActiveKeyID() != "old" {
+		t.Errorf("ActiveKeyID = %q, want the first private key", verifyOnly.ActiveKeyID())
+	}
+	if _, err := verifyOnly.Parse(newToken); err != nil {
+		t.Errorf("token verified with a public key: %v", err)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := LoadKeySet("rsa:"+writePEM(t, dir, "rsa", testRSAKey, false)+",ed:"+writePEM(t, dir, "ed", testEd25519Key, false), "ed")
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+
+	rsaPublicDER, err := x509.MarshalPKIXPublicKey(&testRSAKey.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	rsaPublicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPublicDER})
+
+	expired := testClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	wrongIssuer := testClaims()
+	wrongIssuer.Issuer = "someone-else"
+
+	noExpiry := testClaims()
+	noExpiry.ExpiresAt = nil
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr error
+	}{
+		{
+			name: "retired key",
+			token: func() string {
+				return signRaw(t, jwt.SigningMethodEdDSA, "retired", testRetiredKey, testClaims())
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name: "unknown kid",
+			token: func() string {
+				return signRaw(t, jwt.SigningMethodEdDSA, "missing", testEd25519Key, testClaims())
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name: "missing kid",
+			token: func() string {
+				return signRaw(t, jwt.SigningMethodEdDSA, "", testEd25519Key, testClaims())
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name: "known kid signed by another key",
+			token: func() string {
+				return signRaw(t, jwt.SigningMethodEdDSA, "ed", testRetiredKey, testClaims())
+			},
+		},
+		{
+			name: "alg mismatched with the kid",
+			token: func() string {
+				return signRaw(t, jwt.SigningMethodRS256, "ed", testRSAKey, testClaims())
+			},
+		},
+		{
+			name: "HS256 with the public key as secret",
+			token: func() string {
+				return signRaw(t, jwt.SigningMethodHS256, "rsa", rsaPublicPEM, testClaims())
+			},
+		},
+		{
+			name: "alg none",
+			token: func() string {
+				return signRaw(t, jwt.SigningMethodNone, "ed", jwt.UnsafeAllowNoneSignatureType, testClaims())
+			},
+		},
+		{
+			name: "expired",
+			token: func() string {
+				return signRaw(t, jwt.SigningMethodEdDSA, "ed", testEd25519Key, expired)
+			},
+		},
+		{
+			name: "no expiry",
+			token: func() string {
+				return signRaw(t, jwt.SigningMethodEdDSA, "ed", testEd25519Key, noExpiry)
+			},
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				return signRaw(t, jwt.SigningMethodEdDSA, "ed", testEd25519Key, wrongIssuer)
+			},
+		},
+		{
+			name: "tampered payload",
+			token: func() string {
+				signed := signRaw(t, jwt.SigningMethodEdDSA, "ed", testEd25519Key, testClaims())
+				parts := strings.Split(signed, ".")
+				other := strings.Split(signRaw(t, jwt.SigningMethodEdDSA, "ed", testEd25519Key, testClaims()), ".")
+				return parts[0] + "." + other[1] + "." + parts[2]
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ks.Parse(tt.token())
+			if err == nil {
+				t.Fatalf("Parse accepted the token, claims = %+v", claims)
+			}
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("err = %v, want ErrInvalidToken", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	dir := t.TempDir()
+	edPath := writePEM(t, dir, "ed", testEd25519Key, false)
+
+	weakRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	weakPath := writePEM(t, dir, "weak", weakRSA, false)
+
+	notPEM := filepath.Join(dir, "not-pem")
+	if err := os.WriteFile(notPEM, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		spec      string
+		activeKID string
+	}{
+		{"empty", "", ""},
+		{"missing path", "ed", ""},
+		{"duplicate kid", "ed:" + edPath + ",ed:" + edPath, ""},
+		{"unknown active kid", "ed:" + edPath, "other"},
+		{"missing file", "ed:" + filepath.Join(dir, "missing.pem"), ""},
+		{"not PEM", "ed:" + notPEM, ""},
+		{"weak RSA key", "weak:" + weakPath, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadKeySet(tt.spec, tt.activeKID); err == nil {
+				t.Error("LoadKeySet succeeded, want an error")
+			}
+		})
+	}
+}
@@ -92,7 +92,7 @@ validate_env_vars() {
     [ -z "$POSTGRES_PASSWORD" ] && MISSING_VARS="$MISSING_VARS POSTGRES_PASSWORD"
     [ -z "$GOOGLE_CLIENT_ID" ] && MISSING_VARS="$MISSING_VARS GOOGLE_CLIENT_ID"
     [ -z "$GOOGLE_CLIENT_SECRET" ] && MISSING_VARS="$MISSING_VARS GOOGLE_CLIENT_SECRET"
-    [ -z "$JWT_SECRET" ] && MISSING_VARS="$MISSING_VARS JWT_SECRET"
+    [ -z "$JWT_SIGNING_KEYS" ] && MISSING_VARS="$MISSING_VARS JWT_SIGNING_KEYS"
     [ -z "$AWS_ACCESS_KEY_ID" ] && MISSING_VARS="$MISSING_VARS AWS_ACCESS_KEY_ID"
     [ -z "$AWS_SECRET_ACCESS_KEY" ] && MISSING_VARS="$MISSING_VARS AWS_SECRET_ACCESS_KEY"
     [ -z "$S3_BASE_URL" ] && MISSING_VARS="$MISSING_VARS S3_BASE_URL"
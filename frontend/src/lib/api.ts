@@ -1,5 +1,11 @@
 const API_BASE = import.meta.env.VITE_API_URL || 'http://localhost:8080';
 
+export interface PrivacySettings {
+	read_receipts: boolean;
+	hide_online_status: boolean;
+	last_seen: 'exact' | 'approximate' | 'hidden';
+}
+
 interface FetchOptions extends RequestInit {
 	body?: any;
 }
@@ -10,7 +16,37 @@ function getAuthHeader(): Record<string, string> {
 	return token ? { Authorization: `Bearer ${token}` } : {};
 }
 
-async function fetchAPI<T>(endpoint: string, options: FetchOptions = {}): Promise<T> {
+let refreshPromise: Promise<boolean> | null = null;
+
+// Exchange the stored refresh token for a new access token. Concurrent callers share one request
+// because the refresh token is rotated on every use.
+async function refreshAccessToken(): Promise<boolean> {
+	if (typeof window === 'undefined') return false;
+	const refreshToken = localStorage.getItem('refresh_token');
+	if (!refreshToken) return false;
+
+	if (!refreshPromise) {
+		refreshPromise = fetch(`${API_BASE}/api/auth/refresh`, {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({ refresh_token: refreshToken }),
+		})
+			.then(async (response) => {
+				if (!response.ok) return false;
+				const data = await response.json();
+				localStorage.setItem('token', data.token);
+				localStorage.setItem('refresh_token', data.refresh_token);
+				return true;
+			})
+			.catch(() => false)
+			.finally(() => {
+				refreshPromise = null;
+			});
+	}
+	return refreshPromise;
+}
+
+async function fetchAPI<T>(endpoint: string, options: FetchOptions = {}, retried = false): Promise<T> {
 	const { body, ...rest } = options;
 	
 	const config: RequestInit = {
@@ -32,9 +68,13 @@ async function fetchAPI<T>(endpoint: string, options: FetchOptions = {}): Promis
 	if (!response.ok) {
 		if (response.status === 401) {
 			// Don't redirect for auth endpoints (login/signup failures should show error, not redirect)
-			const isAuthEndpoint = endpoint.startsWith('/api/auth/signin') || endpoint.startsWith('/api/auth/signup');
+			const isAuthEndpoint = endpoint.startsWith('/api/auth/signin') || endpoint.startsWith('/api/auth/signup') || endpoint.startsWith('/api/auth/oauth');
+			if (!isAuthEndpoint && !retried && await refreshAccessToken()) {
+				return fetchAPI<T>(endpoint, options, true);
+			}
 			if (!isAuthEndpoint && typeof window !== 'undefined') {
 				localStorage.removeItem('token');
+				localStorage.removeItem('refresh_token');
 				window.location.href = '/auth/login';
 				throw new Error('Unauthorized');
 			}
@@ -54,10 +94,36 @@ export const api = {
 		fetchAPI('/api/auth/signin', { method: 'POST', body: { email, password } }),
 	getCurrentUser: () => fetchAPI('/api/auth/me'),
 	logout: () => fetchAPI('/api/auth/logout', { method: 'POST' }),
+	logoutAll: () => fetchAPI('/api/auth/logout-all', { method: 'POST' }),
+	refreshToken: refreshAccessToken,
 	getGoogleAuthUrl: () => `${API_BASE}/api/auth/google`,
+	requestMagicLink: (email: string) =>
+		fetchAPI('/api/auth/magic-link', { method: 'POST', body: { email } }),
+	verifyMagicLink: (token: string) =>
+		fetchAPI('/api/auth/magic-link/verify', { method: 'POST', body: { token } }),
+	requestPasswordReset: (email: string) =>
+		fetchAPI('/api/auth/password-reset/request', { method: 'POST', body: { email } }),
+	confirmPasswordReset: (token: string, password: string) =>
+		fetchAPI('/api/auth/password-reset/confirm', { method: 'POST', body: { token, password } }),
+	exchangeLoginCode: (code: string) =>
+		fetchAPI('/api/auth/oauth/exchange', { method: 'POST', body: { code } }),
 	verifyEmail: (token: string) => fetchAPI(`/api/auth/verify-email?token=${token}`),
 	resendVerificationEmail: () => fetchAPI('/api/auth/resend-verification', { method: 'POST' }),
 	deleteAccount: () => fetchAPI('/api/auth/account', { method: 'DELETE' }),
+	pauseAccount: () => fetchAPI('/api/auth/account/pause', { method: 'POST' }),
+	getPrivacySettings: () => fetchAPI<PrivacySettings>('/api/privacy'),
+	updatePrivacySettings: (settings: Partial<PrivacySettings>) =>
+		fetchAPI<PrivacySettings>('/api/privacy', { method: 'PUT', body: settings }),
+	requestDataExport: () => fetchAPI('/api/auth/account/export', { method: 'POST' }),
+	getDataExport: () =>
+		fetchAPI<{ status: string; download_url?: string; expires_at?: string }>('/api/auth/account/export'),
+	requestEmailChange: (newEmail: string, password?: string) =>
+		fetchAPI('/api/auth/email/change', { method: 'POST', body: { new_email: newEmail, password } }),
+	confirmEmailChange: (token: string) =>
+		fetchAPI('/api/auth/email/confirm', { method: 'POST', body: { token } }),
+	cancelEmailChange: (token: string) =>
+		fetchAPI<{ email: string; reverted: boolean }>('/api/auth/email/cancel', { method: 'POST', body: { token } }),
+	getWebSocketTicket: () => fetchAPI('/api/ws/ticket', { method: 'POST' }),
 
 	// Profile
 	createProfile: (data: {
@@ -104,10 +170,28 @@ export const api = {
 	getInbox: () => fetchAPI('/api/inbox'), // Returns locked message support for males
 	createConversation: (recipientId: string, message: string) => 
 		fetchAPI('/api/conversations', { method: 'POST', body: { recipient_id: recipientId, message } }),
-	getMessages: (conversationId: string, limit = 50, offset = 0) => 
-		fetchAPI(`/api/conversations/${conversationId}/messages?limit=${limit}&offset=${offset}`),
-	sendMessage: (conversationId: string, content: string, imageUrl?: string) =>
-		fetchAPI(`/api/conversations/${conversationId}/messages`, { method: 'POST', body: { content, image_url: imageUrl } }),
+	getMessages: (conversationId: string, cursor: { before?: string; after?: string } = {}, limit = 50) => {
+		const params = new URLSearchParams({ limit: String(limit) });
+		if (cursor.before) params.set('before', cursor.before);
+		if (cursor.after) params.set('after', cursor.after);
+		return fetchAPI(`/api/conversations/${conversationId}/messages?${params}`);
+	},
+	editMessage: (conversationId: string, messageId: string, content: string) =>
+		fetchAPI(`/api/conversations/${conversationId}/messages/${messageId}`, { method: 'PUT', body: { content } }),
+	deleteMessage: (conversationId: string, messageId: string) =>
+		fetchAPI(`/api/conversations/${conversationId}/messages/${messageId}`, { method: 'DELETE' }),
+	getMessageEdits: (conversationId: string, messageId: string) =>
+		fetchAPI<{ edits: Array<{ id: string; previous_content: string; edited_at: string }> }>(`/api/conversations/${conversationId}/messages/${messageId}/edits`),
+	addReaction: (conversationId: string, messageId: string, emoji: string) =>
+		fetchAPI(`/api/conversations/${conversationId}/messages/${messageId}/reactions/${encodeURIComponent(emoji)}`, { method: 'PUT' }),
+	removeReaction: (conversationId: string, messageId: string, emoji: string) =>
+		fetchAPI(`/api/conversations/${conversationId}/messages/${messageId}/reactions/${encodeURIComponent(emoji)}`, { method: 'DELETE' }),
+	markConversationRead: (conversationId: string, messageId: string) =>
+		fetchAPI(`/api/conversations/${conversationId}/read`, { method: 'POST', body: { message_id: messageId } }),
+	syncMessages: (since: string, limit = 200) =>
+		fetchAPI<{ messages: any[]; updated: any[]; cursor: string; has_more: boolean }>(`/api/messages/sync?since=${encodeURIComponent(since)}&limit=${limit}`),
+	sendMessage: (conversationId: string, content: string, imageUrl?: string, replyToId?: string) =>
+		fetchAPI(`/api/conversations/${conversationId}/messages`, { method: 'POST', body: { content, image_url: imageUrl, reply_to_id: replyToId } }),
 	getUnreadMessageCount: () => fetchAPI('/api/messages/unread-count'),
 	getChatImagePresignedUrl: (conversationId: string, fileExt: string, contentType: string) =>
 		fetchAPI('/api/upload/chat-image-url', { method: 'POST', body: { conversation_id: conversationId, file_ext: fileExt, content_type: contentType } }),
@@ -92,6 +92,7 @@ function createAuthStore() {
 			} catch {
 				// Token invalid, clear it
 				localStorage.removeItem('token');
+				localStorage.removeItem('refresh_token');
 				localStorage.removeItem('user');
 				set({ user: null, profile: null, loading: false, initialized: true });
 			}
@@ -109,6 +110,7 @@ function createAuthStore() {
 		logout() {
 			api.logout().catch(() => {});
 			localStorage.removeItem('token');
+			localStorage.removeItem('refresh_token');
 			localStorage.removeItem('user');
 			set({ user: null, profile: null, loading: false, initialized: true });
 		},
@@ -1,13 +1,28 @@
 import { writable, get } from 'svelte/store';
 import { notifications } from './notifications';
+import { api } from '$lib/api';
 
 const WS_URL = import.meta.env.VITE_WS_URL || 'ws://localhost:8080/ws';
 
+// Version of the command protocol spoken to the server
+const PROTOCOL_VERSION = 1;
+const ACK_TIMEOUT_MS = 10000;
+// Commands must stay under the server's frame limit, so subscriptions are sent in batches
+const PRESENCE_BATCH_SIZE = 50;
+// How long events past a gap in sequence numbers wait for the missing ones
+// before asking the server for them
+const GAP_TIMEOUT_MS = 5000;
+// Acks are batched so the server isn't written to for every event
+const EVENT_ACK_DELAY_MS = 5000;
+
 interface WSState {
 	connected: boolean;
 	reconnecting: boolean;
 }
 
+// Latest online state of users whose presence is followed, by user ID
+export const presence = writable<Record<string, boolean>>({});
+
 function createWebSocketStore() {
 	const { subscribe, set, update } = writable<WSState>({
 		connected: false,
@@ -20,14 +35,53 @@ function createWebSocketStore() {
 
 	const messageHandlers = new Map<string, (payload: any) => void>();
 
-	function connect(token: string) {
-		if (ws?.readyState === WebSocket.OPEN) return;
+	// Commands waiting for the server's ack, by request ID
+	const pendingAcks = new Map<string, { resolve: () => void; reject: (err: Error) => void; timer: ReturnType<typeof setTimeout> }>();
+	let nextRequestId = 1;
+
+	let connecting = false;
+
+	// Cursor of the newest chat message seen. Used to catch up on messages when
+	// the server no longer has all the events missed while disconnected.
+	let lastCursor: string | null = null;
 
-		ws = new WebSocket(`${WS_URL}?token=${token}`);
+	// Sequence number of the last event handled with none missing before it.
+	// Reconnects resume from here, and it is what gets acknowledged.
+	let lastSeq: number | null = null;
+	// Events already handled that arrived past a gap
+	const aheadSeqs = new Set<number>();
+	let ackedSeq = 0;
+	let gapTimer: ReturnType<typeof setTimeout> | null = null;
+	let ackTimer: ReturnType<typeof setTimeout> | null = null;
+
+	// Users whose presence is followed, with how many components asked for each.
+	// The server forgets subscriptions with the connection, so they are resent on reconnect.
+	const presenceWatchers = new Map<string, number>();
+
+	// Each connection needs a fresh single-use ticket, so access tokens never end up in the URL
+	async function connect() {
+		if (connecting || ws?.readyState === WebSocket.OPEN) return;
+
+		connecting = true;
+		let ticket: string;
+		try {
+			const data = await api.getWebSocketTicket() as { ticket: string };
+			ticket = data.ticket;
+		} catch {
+			connecting = false;
+			set({ connected: false, reconnecting: true });
+			scheduleReconnect();
+			return;
+		}
+		connecting = false;
+
+		const since = lastSeq !== null ? `&since=${lastSeq}` : '';
+		ws = new WebSocket(`${WS_URL}?ticket=${encodeURIComponent(ticket)}${since}`);
 
 		ws.onopen = () => {
 			set({ connected: true, reconnecting: false });
 			startHeartbeat();
+			sendPresenceCommand('presence_subscribe', [...presenceWatchers.keys()]);
 		};
 
 		ws.onmessage = (event) => {
@@ -42,7 +96,9 @@ function createWebSocketStore() {
 		ws.onclose = () => {
 			set({ connected: false, reconnecting: true });
 			stopHeartbeat();
-			scheduleReconnect(token);
+			clearGapTimer();
+			rejectPendingAcks();
+			scheduleReconnect();
 		};
 
 		ws.onerror = () => {
@@ -50,7 +106,105 @@ function createWebSocketStore() {
 		};
 	}
 
-	function handleMessage(message: { type: string; payload: any }) {
+	// Replays messages sent while the socket was down through the normal
+	// handlers, along with earlier messages edited, deleted or reacted to
+	async function syncMissed() {
+		if (!lastCursor) return;
+		try {
+			let hasMore = true;
+			while (hasMore && lastCursor) {
+				const data = await api.syncMessages(lastCursor);
+				for (const message of data.messages) {
+					handleMessage({ type: 'message', payload: message });
+				}
+				for (const message of data.updated ?? []) {
+					handleMessage({ type: message.deleted_at ? 'message_deleted' : 'message_edited', payload: message });
+				}
+				lastCursor = data.cursor;
+				hasMore = data.has_more;
+			}
+		} catch {
+			console.error('Failed to sync missed messages');
+		}
+	}
+
+	// Events can arrive both live and replayed, and out of order when they race
+	// each other. Returns false for events that were already handled.
+	function acceptSeq(seq: number): boolean {
+		if ((lastSeq !== null && seq <= lastSeq) || aheadSeqs.has(seq)) return false;
+		aheadSeqs.add(seq);
+		advanceSeq();
+		return true;
+	}
+
+	function advanceSeq() {
+		if (lastSeq === null) return;
+		while (aheadSeqs.has(lastSeq + 1)) {
+			lastSeq++;
+		}
+		for (const seq of aheadSeqs) {
+			if (seq <= lastSeq) aheadSeqs.delete(seq);
+		}
+		if (aheadSeqs.size > 0) {
+			scheduleGapCheck();
+		} else {
+			clearGapTimer();
+		}
+		scheduleAck();
+	}
+
+	// Sent once the server has replayed what was missed. Reset means it no longer
+	// had all of it, so chat messages are caught up through the sync endpoint and
+	// counting starts over from the server's sequence, which may be lower than
+	// ours after a restore.
+	function handleResume(resume: { seq: number; reset: boolean }) {
+		if (resume.reset) {
+			lastSeq = resume.seq;
+			ackedSeq = Math.min(ackedSeq, resume.seq);
+			aheadSeqs.clear();
+			clearGapTimer();
+			syncMissed();
+		} else {
+			lastSeq = Math.max(lastSeq ?? 0, resume.seq);
+		}
+		advanceSeq();
+	}
+
+	function scheduleGapCheck() {
+		if (gapTimer) return;
+		gapTimer = setTimeout(() => {
+			gapTimer = null;
+			if (aheadSeqs.size > 0 && lastSeq !== null) {
+				send('resume', { since: lastSeq });
+			}
+		}, GAP_TIMEOUT_MS);
+	}
+
+	function clearGapTimer() {
+		if (gapTimer) {
+			clearTimeout(gapTimer);
+			gapTimer = null;
+		}
+	}
+
+	// Lets the server prune events this device no longer needs
+	function scheduleAck() {
+		if (ackTimer || lastSeq === null || lastSeq <= ackedSeq) return;
+		ackTimer = setTimeout(() => {
+			ackTimer = null;
+			if (lastSeq !== null && lastSeq > ackedSeq && send('ack_events', { seq: lastSeq })) {
+				ackedSeq = lastSeq;
+			}
+		}, EVENT_ACK_DELAY_MS);
+	}
+
+	function handleMessage(message: { type: string; payload: any; seq?: number }) {
+		if (message.seq && !acceptSeq(message.seq)) return;
+
+		if (message.type === 'message' && message.payload?.cursor) {
+			lastCursor = message.payload.cursor;
+		}
+
 		const handler = messageHandlers.get(message.type);
 		if (handler) {
 			handler(message.payload);
@@ -58,20 +212,40 @@ function createWebSocketStore() {
 
 		// Handle built-in message types
 		switch (message.type) {
+			case 'ack':
+				settleAck(message.payload);
+				break;
+			case 'resume':
+				handleResume(message.payload);
+				break;
 			case 'notification':
 				notifications.addNotification(message.payload);
 				break;
 			case 'presence':
-				// Could update a presence store here
+				presence.update((p) => ({ ...p, [message.payload.user_id]: message.payload.is_online }));
 				break;
 		}
 	}
 
+	function settleAck(ack: { id?: string; ok: boolean; error?: string }) {
+		if (!ack.id) {
+			if (!ack.ok) console.error('WebSocket command failed:', ack.error);
+			return;
+		}
+		const pending = pendingAcks.get(ack.id);
+		if (!pending) return;
+		pendingAcks.delete(ack.id);
+		clearTimeout(pending.timer);
+		if (ack.ok) {
+			pending.resolve();
+		} else {
+			pending.reject(new Error(ack.error || 'Command failed'));
+		}
+	}
+
 	function startHeartbeat() {
 		heartbeatTimer = setInterval(() => {
-			if (ws?.readyState === WebSocket.OPEN) {
-				ws.send(JSON.stringify({ type: 'ping' }));
-			}
+			send('heartbeat', {});
 		}, 30000);
 	}
 
@@ -82,31 +256,100 @@ function createWebSocketStore() {
 		}
 	}
 
-	function scheduleReconnect(token: string) {
+	function scheduleReconnect() {
 		if (reconnectTimer) return;
 		reconnectTimer = setTimeout(() => {
 			reconnectTimer = null;
-			connect(token);
+			connect();
 		}, 3000);
 	}
 
+	function rejectPendingAcks() {
+		for (const [id, pending] of pendingAcks) {
+			clearTimeout(pending.timer);
+			pending.reject(new Error('Connection closed'));
+			pendingAcks.delete(id);
+		}
+	}
+
 	function disconnect() {
 		if (reconnectTimer) {
 			clearTimeout(reconnectTimer);
 			reconnectTimer = null;
 		}
 		stopHeartbeat();
+		clearGapTimer();
+		if (ackTimer) {
+			clearTimeout(ackTimer);
+			ackTimer = null;
+		}
 		ws?.close();
 		ws = null;
+		lastCursor = null;
+		lastSeq = null;
+		aheadSeqs.clear();
+		ackedSeq = 0;
 		set({ connected: false, reconnecting: false });
 	}
 
-	function send(type: string, payload: any) {
+	// Fire-and-forget command. Returns false when the socket is down so callers
+	// can fall back to the REST API.
+	function send(type: string, payload: any): boolean {
 		if (ws?.readyState === WebSocket.OPEN) {
-			ws.send(JSON.stringify({ type, payload }));
+			ws.send(JSON.stringify({ v: PROTOCOL_VERSION, type, payload }));
+			return true;
+		}
+		return false;
+	}
+
+	// Sends a command and resolves once the server acks it
+	function request(type: string, payload: any): Promise<void> {
+		return new Promise((resolve, reject) => {
+			if (ws?.readyState !== WebSocket.OPEN) {
+				reject(new Error('Not connected'));
+				return;
+			}
+			const id = String(nextRequestId++);
+			const timer = setTimeout(() => {
+				pendingAcks.delete(id);
+				reject(new Error('Timed out waiting for the server'));
+			}, ACK_TIMEOUT_MS);
+			pendingAcks.set(id, { resolve, reject, timer });
+			ws.send(JSON.stringify({ v: PROTOCOL_VERSION, id, type, payload }));
+		});
+	}
+
+	function sendPresenceCommand(type: string, userIds: string[]) {
+		for (let i = 0; i < userIds.length; i += PRESENCE_BATCH_SIZE) {
+			send(type, { user_ids: userIds.slice(i, i + PRESENCE_BATCH_SIZE) });
 		}
 	}
 
+	// Follows the presence of users shown on screen. The server leaves out
+	// members in invisible mode. Call the returned function once they are no
+	// longer shown.
+	function watchPresence(userIds: string[]) {
+		const added = userIds.filter((id) => {
+			const count = presenceWatchers.get(id) || 0;
+			presenceWatchers.set(id, count + 1);
+			return count === 0;
+		});
+		sendPresenceCommand('presence_subscribe', added);
+
+		return () => {
+			const removed = userIds.filter((id) => {
+				const count = (presenceWatchers.get(id) || 0) - 1;
+				if (count > 0) {
+					presenceWatchers.set(id, count);
+					return false;
+				}
+				presenceWatchers.delete(id);
+				return true;
+			});
+			sendPresenceCommand('presence_unsubscribe', removed);
+		};
+	}
+
 	function onMessage(type: string, handler: (payload: any) => void) {
 		messageHandlers.set(type, handler);
 		return () => messageHandlers.delete(type);
@@ -117,6 +360,8 @@ function createWebSocketStore() {
 		connect,
 		disconnect,
 		send,
+		request,
+		watchPresence,
 		onMessage,
 	};
 }